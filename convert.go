@@ -0,0 +1,68 @@
+// convert.go implements the convert subcommand: show the import target one
+// project name becomes, without a tenant, to check parsing quickly.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/snyk-playground/snyk-target-export/internal"
+)
+
+// writeConvertedTarget writes the Target that ProjectToTarget makes of the
+// project as indented JSON, or an "unsupported" line saying why there is
+// none. It reports whether a target was made.
+func writeConvertedTarget(w io.Writer, name, origin, branch string) (bool, error) {
+	target, ok := internal.ProjectToTarget(name, origin, branch)
+	if !ok {
+		reason := "cannot parse a repo from the name"
+		if !internal.IsSCMOrigin(origin) {
+			reason = fmt.Sprintf("origin %q is not a supported SCM origin (want one of %v)", origin, internal.SCMOrigins())
+		}
+		_, err := fmt.Fprintf(w, "unsupported: %s\n", reason)
+		return false, err
+	}
+	data, err := json.MarshalIndent(target, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return true, err
+}
+
+// runConvert implements the convert subcommand. It makes no network calls and
+// exits 1 when the project yields no target.
+func runConvert(args []string) {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	name := fs.String("name", "", "Project name, e.g. owner/repo(main):pom.xml")
+	origin := fs.String("origin", "", "Project origin, e.g. github or bitbucket-server")
+	branch := fs.String("branch", "", "Project branch or target reference (optional)")
+	projectNameTemplate := fs.String("project-name-template", "", "Parse the name with this regular expression, as refresh does with the same flag")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: snyk-target-export convert --name=<project name> --origin=<origin> [--branch=<branch>]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	if *name == "" || *origin == "" || fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Error: convert takes --name and --origin and no other arguments\n")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := internal.SetProjectNameTemplate(*projectNameTemplate); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --project-name-template: %v\n", err)
+		os.Exit(1)
+	}
+	ok, err := writeConvertedTarget(os.Stdout, *name, *origin, *branch)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
@@ -3,13 +3,20 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math/rand/v2"
 	"os"
+	"regexp"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/snyk-playground/snyk-target-export/internal"
 )
@@ -34,13 +41,141 @@ func duplicateGroupKey(p internal.Project, considerOrigin bool) string {
 	return p.Name
 }
 
-// findDuplicateGroups groups projects by name (and optionally by origin when considerOrigin is true)
-// and returns only groups with 2+ projects (duplicates).
+// dedupKeyFunc returns the grouping key for a project; projects with equal keys are duplicates.
+type dedupKeyFunc func(p internal.Project) string
+
+// nameDedupKey groups by project name, and also by origin when considerOrigin is true.
+func nameDedupKey(considerOrigin bool) dedupKeyFunc {
+	return func(p internal.Project) string { return duplicateGroupKey(p, considerOrigin) }
+}
+
+// canonicalDedupKey groups by the repo identity parsed from the project name
+// (owner/repo or projectKey/repoSlug), the branch, and the manifest path, ignoring
+// origin. The same manifest on the same branch imported through two integrations
+// collapses to one group, while other branches and manifests stay separate.
+// Projects whose name cannot be parsed fall back to the raw name.
+func canonicalDedupKey(p internal.Project) string {
+	branch := p.Branch
+	if branch == "" {
+		branch = p.TargetReference
+	}
+	t, ok := internal.ProjectToTarget(p.Name, p.Origin, branch)
+	if !ok {
+		return p.Name
+	}
+	repo := t.Owner + "/" + t.Name
+	if t.ProjectKey != "" {
+		repo = t.ProjectKey + "/" + t.RepoSlug
+	}
+	return repo + duplicateKeySeparator + branch + duplicateKeySeparator + internal.ManifestPath(p.Name)
+}
+
+// normalizedNameKey groups by the repo identity parsed from the project name,
+// lower-cased and without the manifest, plus the branch (--normalize-names),
+// so "PROJ/Repo:pom.xml" and "proj/repo:build.gradle" on one branch are one
+// set. With considerOrigin the origin must match too. Projects whose name
+// cannot be parsed fall back to the lower-cased raw name.
+func normalizedNameKey(considerOrigin bool) dedupKeyFunc {
+	return func(p internal.Project) string {
+		branch := p.Branch
+		if branch == "" {
+			branch = p.TargetReference
+		}
+		key := strings.ToLower(p.Name)
+		if t, ok := internal.ProjectToTarget(p.Name, p.Origin, branch); ok {
+			repo := t.Owner + "/" + t.Name
+			if t.ProjectKey != "" {
+				repo = t.ProjectKey + "/" + t.RepoSlug
+			}
+			key = strings.ToLower(repo) + duplicateKeySeparator + branch
+		}
+		if considerOrigin && p.Origin != "" {
+			key += duplicateKeySeparator + p.Origin
+		}
+		return key
+	}
+}
+
+// matchedNames describes a duplicate group whose members have different raw
+// names, e.g. under --normalize-names, as the distinct names and the key
+// they were matched on. It returns "" when every name is the same.
+func matchedNames(key string, names []string) string {
+	var distinct []string
+	seen := make(map[string]bool)
+	for _, n := range names {
+		if !seen[n] {
+			seen[n] = true
+			distinct = append(distinct, n)
+		}
+	}
+	if len(distinct) < 2 {
+		return ""
+	}
+	return fmt.Sprintf("%s  (matched as %s)", strings.Join(distinct, ", "), strings.ReplaceAll(key, duplicateKeySeparator, " | "))
+}
+
+// dedupKeyForMode returns the key function for a --dedup-mode value.
+// canonical groups across origins by design, so it rejects considerOrigin
+// rather than silently deleting copies the user asked to keep.
+func dedupKeyForMode(mode string, considerOrigin bool) (dedupKeyFunc, error) {
+	switch mode {
+	case "name":
+		return nameDedupKey(considerOrigin), nil
+	case "canonical":
+		if considerOrigin {
+			return nil, fmt.Errorf("--dedup-mode canonical groups projects across origins and cannot be combined with --considerOrigin or --strict-dedup")
+		}
+		return canonicalDedupKey, nil
+	default:
+		return nil, fmt.Errorf("--dedup-mode must be name or canonical, got %q", mode)
+	}
+}
+
+// nameBranchDedupKey groups by project name and branch, so the same manifest
+// imported for two branches is not a duplicate.
+func nameBranchDedupKey(p internal.Project) string {
+	branch := p.Branch
+	if branch == "" {
+		branch = p.TargetReference
+	}
+	return p.Name + duplicateKeySeparator + branch
+}
+
+// targetDedupKey groups by the Snyk target a project belongs to and its
+// manifest path, so only repeated imports of one manifest into the same
+// target are duplicates. Projects without a target ID fall back to the name.
+func targetDedupKey(p internal.Project) string {
+	if p.TargetID == "" {
+		return p.Name
+	}
+	return p.TargetID + duplicateKeySeparator + internal.ManifestPath(p.Name)
+}
+
+// dedupKeyPresets are the --dedup-key values, from the loosest grouping
+// (name: any origin, branch, or target) to the strictest.
+var dedupKeyPresets = map[string]dedupKeyFunc{
+	"name":           nameDedupKey(false),
+	"name+origin":    nameDedupKey(true),
+	"name+branch":    nameBranchDedupKey,
+	"target":         targetDedupKey,
+	"canonical-repo": canonicalDedupKey,
+}
+
+// dedupKeyForPreset returns the key function for a --dedup-key value.
+func dedupKeyForPreset(preset string) (dedupKeyFunc, error) {
+	keyFn, ok := dedupKeyPresets[preset]
+	if !ok {
+		return nil, fmt.Errorf("--dedup-key must be name, name+origin, name+branch, target, or canonical-repo, got %q", preset)
+	}
+	return keyFn, nil
+}
+
+// findDuplicateGroups groups projects by keyFn and returns only groups with 2+ projects (duplicates).
 // Projects within each group are sorted by Created ascending (oldest first).
-func findDuplicateGroups(projects []internal.Project, considerOrigin bool) []duplicateGroup {
+func findDuplicateGroups(projects []internal.Project, keyFn dedupKeyFunc) []duplicateGroup {
 	grouped := make(map[string][]internal.Project)
 	for _, p := range projects {
-		key := duplicateGroupKey(p, considerOrigin)
+		key := keyFn(p)
 		grouped[key] = append(grouped[key], p)
 	}
 	var out []duplicateGroup
@@ -68,12 +203,12 @@ type duplicateGroupGroupWide struct {
 	items []projectInOrg
 }
 
-// findDuplicateGroupsGroupWide groups projects from multiple orgs by name (and optionally origin).
+// findDuplicateGroupsGroupWide groups projects from multiple orgs by keyFn.
 // Returns only groups with 2+ projects. Items within each group are sorted by Created ascending.
-func findDuplicateGroupsGroupWide(items []projectInOrg, considerOrigin bool) []duplicateGroupGroupWide {
+func findDuplicateGroupsGroupWide(items []projectInOrg, keyFn dedupKeyFunc) []duplicateGroupGroupWide {
 	grouped := make(map[string][]projectInOrg)
 	for _, item := range items {
-		key := duplicateGroupKey(item.project, considerOrigin)
+		key := keyFn(item.project)
 		grouped[key] = append(grouped[key], item)
 	}
 	var out []duplicateGroupGroupWide
@@ -94,22 +229,174 @@ type dedupCollectedResult struct {
 	groups   []duplicateGroup
 }
 
+// projectDeletion identifies one project to delete.
+type projectDeletion struct {
+	orgID     string
+	projectID string
+}
+
+// deleteProjectsConcurrently issues all deletions in parallel, bounded by the API's
+// delete limiter, and returns one error (or nil) per request in input order.
+func deleteProjectsConcurrently(ctx context.Context, api SnykAPI, reqs []projectDeletion) []error {
+	errs := make([]error, len(reqs))
+	var wg sync.WaitGroup
+	for i, r := range reqs {
+		wg.Add(1)
+		go func(i int, r projectDeletion) {
+			defer wg.Done()
+			errs[i] = api.DeleteProject(internal.WithRequestLabel(ctx, "org "+r.orgID), r.orgID, r.projectID)
+		}(i, r)
+	}
+	wg.Wait()
+	return errs
+}
+
+// deleteCap enforces --max-deletes-per-org: once an org has used its budget,
+// further duplicates there are kept. A zero max means unlimited.
+type deleteCap struct {
+	max     int
+	used    map[string]int
+	skipped map[string]int    // org ID -> duplicates kept because of the cap
+	labels  map[string]string // org ID -> label, for capped orgs
+}
+
+func newDeleteCap(max int) *deleteCap {
+	return &deleteCap{max: max, used: make(map[string]int), skipped: make(map[string]int), labels: make(map[string]string)}
+}
+
+// allow reports whether one more duplicate in orgID may be deleted, counting
+// it against the org's budget. The first refusal for an org is logged.
+func (c *deleteCap) allow(orgID, orgLabel string) bool {
+	if c.max <= 0 || c.used[orgID] < c.max {
+		c.used[orgID]++
+		return true
+	}
+	if c.skipped[orgID] == 0 {
+		log.Printf("Org %s: reached --max-deletes-per-org (%d); keeping its remaining duplicates", orgLabel, c.max)
+	}
+	c.skipped[orgID]++
+	c.labels[orgID] = orgLabel
+	return false
+}
+
+// cappedOrgs returns the labels of orgs that hit the cap, sorted, and the
+// total number of duplicates kept because of it.
+func (c *deleteCap) cappedOrgs() (labels []string, skipped int) {
+	for orgID, n := range c.skipped {
+		labels = append(labels, c.labels[orgID])
+		skipped += n
+	}
+	sort.Strings(labels)
+	return labels, skipped
+}
+
+// plannedDeletes returns how many duplicates would be deleted, given the org ID
+// of each duplicate in report order and the --max-deletes-per-org cap (0 =
+// unlimited). It matches what deleteCap allows without logging.
+func plannedDeletes(max int, dupOrgIDs []string) int {
+	used := make(map[string]int)
+	n := 0
+	for _, orgID := range dupOrgIDs {
+		if max <= 0 || used[orgID] < max {
+			used[orgID]++
+			n++
+		}
+	}
+	return n
+}
+
+// duplicatesInReportOrder lists every duplicate (each group's projects after
+// the kept one) in the order the report prints and deletes them.
+func duplicatesInReportOrder(orgsWithDuplicates []dedupCollectedResult, groupsWide []duplicateGroupGroupWide) []projectInOrg {
+	var dups []projectInOrg
+	for _, res := range orgsWithDuplicates {
+		for _, g := range res.groups {
+			for _, p := range g.projects[1:] {
+				dups = append(dups, projectInOrg{orgID: res.orgID, orgLabel: res.orgLabel, project: p})
+			}
+		}
+	}
+	for _, g := range groupsWide {
+		dups = append(dups, g.items[1:]...)
+	}
+	return dups
+}
+
+// undoRecord is one project in the --undo-file: everything needed to
+// re-import it after it is deleted.
+type undoRecord struct {
+	OrgID string `json:"orgId"`
+	internal.Project
+}
+
+// writeUndoFile records the duplicates that will be deleted, honouring the
+// --max-deletes-per-org cap as deleteCap does, and returns how many it wrote.
+func writeUndoFile(path string, max int, dups []projectInOrg) (int, error) {
+	safePath, err := sanitizeOutputPath(path)
+	if err != nil {
+		return 0, err
+	}
+	used := make(map[string]int)
+	records := []undoRecord{}
+	for _, d := range dups {
+		if max <= 0 || used[d.orgID] < max {
+			used[d.orgID]++
+			records = append(records, undoRecord{OrgID: d.orgID, Project: d.project})
+		}
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return 0, fmt.Errorf("marshal undo records: %w", err)
+	}
+	return len(records), writeFileAtomic(safePath, append(data, '\n'))
+}
+
 // reportAndDeleteDuplicates prints duplicate groups (per-org) and optionally deletes duplicate projects.
+// Deletes for an org run concurrently; output is printed afterwards in group order.
+// Duplicates beyond dc's per-org cap are reported as capped and kept.
 // Returns orgsAffected (org IDs that had duplicates) and counts.
-func reportAndDeleteDuplicates(ctx context.Context, api SnykAPI, doDelete bool, orgsWithDuplicates []dedupCollectedResult) (orgsAffected map[string]bool, totalDuplicates, totalDeleted, totalFailed int) {
+func reportAndDeleteDuplicates(ctx context.Context, api SnykAPI, doDelete bool, dc *deleteCap, orgsWithDuplicates []dedupCollectedResult) (orgsAffected map[string]bool, totalDuplicates, totalDeleted, totalFailed int) {
 	orgsAffected = make(map[string]bool)
 	for _, res := range orgsWithDuplicates {
 		orgsAffected[res.orgID] = true
+		var allowed []bool
+		var reqs []projectDeletion
+		for _, g := range res.groups {
+			for _, d := range g.projects[1:] {
+				ok := dc.allow(res.orgID, res.orgLabel)
+				allowed = append(allowed, ok)
+				if ok {
+					reqs = append(reqs, projectDeletion{orgID: res.orgID, projectID: d.ID})
+				}
+			}
+		}
+		var errs []error
+		if doDelete {
+			errs = deleteProjectsConcurrently(ctx, api, reqs)
+		}
+		i, next := 0, 0
 		fmt.Printf("\nOrg: %s\n", res.orgLabel)
 		for _, g := range res.groups {
 			original := g.projects[0]
 			dupes := g.projects[1:]
 			totalDuplicates += len(dupes)
 			fmt.Printf("  DUPLICATE  %s\n", original.Name)
+			names := make([]string, len(g.projects))
+			for j, p := range g.projects {
+				names[j] = p.Name
+			}
+			if m := matchedNames(g.key, names); m != "" {
+				fmt.Printf("    names:   %s\n", m)
+			}
 			fmt.Printf("    keep:    %s  origin=%s  created %s\n", original.ID, original.Origin, original.Created)
 			for _, d := range dupes {
-				if doDelete {
-					err := api.DeleteProject(ctx, res.orgID, d.ID)
+				ok := allowed[i]
+				i++
+				if !ok {
+					fmt.Printf("    capped:  %s  origin=%s  created %s  (--max-deletes-per-org reached, kept)\n", d.ID, d.Origin, d.Created)
+				} else if doDelete {
+					err := errs[next]
+					next++
 					if err != nil {
 						totalFailed++
 						fmt.Printf("    FAILED:  %s  origin=%s  created %s  error: %v\n", d.ID, d.Origin, d.Created, err)
@@ -127,19 +414,49 @@ func reportAndDeleteDuplicates(ctx context.Context, api SnykAPI, doDelete bool,
 }
 
 // reportAndDeleteDuplicatesGroupWide prints duplicate groups (across orgs) and optionally deletes.
+// Deletes run concurrently before anything is printed; output follows group order.
+// Duplicates beyond dc's per-org cap are reported as capped and kept.
 // Returns orgsAffected (org IDs we deleted from or would delete from) and counts.
-func reportAndDeleteDuplicatesGroupWide(ctx context.Context, api SnykAPI, doDelete bool, groups []duplicateGroupGroupWide) (orgsAffected map[string]bool, totalDuplicates, totalDeleted, totalFailed int) {
+func reportAndDeleteDuplicatesGroupWide(ctx context.Context, api SnykAPI, doDelete bool, dc *deleteCap, groups []duplicateGroupGroupWide) (orgsAffected map[string]bool, totalDuplicates, totalDeleted, totalFailed int) {
 	orgsAffected = make(map[string]bool)
+	var allowed []bool
+	var reqs []projectDeletion
+	for _, g := range groups {
+		for _, d := range g.items[1:] {
+			ok := dc.allow(d.orgID, d.orgLabel)
+			allowed = append(allowed, ok)
+			if ok {
+				reqs = append(reqs, projectDeletion{orgID: d.orgID, projectID: d.project.ID})
+			}
+		}
+	}
+	var errs []error
+	if doDelete {
+		errs = deleteProjectsConcurrently(ctx, api, reqs)
+	}
+	i, next := 0, 0
 	for _, g := range groups {
 		keep := g.items[0]
 		dupes := g.items[1:]
 		totalDuplicates += len(dupes)
 		fmt.Printf("\nDUPLICATE  %s (keep oldest: %s %s)\n", keep.project.Name, keep.orgLabel, keep.project.ID)
+		names := make([]string, len(g.items))
+		for j, it := range g.items {
+			names[j] = it.project.Name
+		}
+		if m := matchedNames(g.key, names); m != "" {
+			fmt.Printf("    names:   %s\n", m)
+		}
 		fmt.Printf("    keep:    %s  org=%s  origin=%s  created %s\n", keep.project.ID, keep.orgLabel, keep.project.Origin, keep.project.Created)
 		for _, d := range dupes {
 			orgsAffected[d.orgID] = true
-			if doDelete {
-				err := api.DeleteProject(ctx, d.orgID, d.project.ID)
+			ok := allowed[i]
+			i++
+			if !ok {
+				fmt.Printf("    capped:  %s  org=%s  origin=%s  created %s  (--max-deletes-per-org reached, kept)\n", d.project.ID, d.orgLabel, d.project.Origin, d.project.Created)
+			} else if doDelete {
+				err := errs[next]
+				next++
 				if err != nil {
 					totalFailed++
 					fmt.Printf("    FAILED:  %s  org=%s  origin=%s  created %s  error: %v\n", d.project.ID, d.orgLabel, d.project.Origin, d.project.Created, err)
@@ -155,75 +472,381 @@ func reportAndDeleteDuplicatesGroupWide(ctx context.Context, api SnykAPI, doDele
 	return orgsAffected, totalDuplicates, totalDeleted, totalFailed
 }
 
+// branchAnnotation matches a trailing "(branch)" on a target display name.
+var branchAnnotation = regexp.MustCompile(`\s*\([^()]*\)$`)
+
+// targetMatchKey is the key targets are grouped on as duplicates: the display
+// name as-is or, with --target-match-normalized, lower-cased and without a
+// trailing "(branch)" annotation, so "Org/Repo (main)" matches "org/repo".
+func targetMatchKey(displayName string, normalized bool) string {
+	if !normalized {
+		return displayName
+	}
+	return strings.ToLower(strings.TrimSpace(branchAnnotation.ReplaceAllString(strings.TrimSpace(displayName), "")))
+}
+
 // cleanupEmptyTargets finds targets that have no projects (after duplicate project deletion) and optionally deletes them.
-func cleanupEmptyTargets(ctx context.Context, api SnykAPI, doDelete bool, orgsAffected map[string]bool) (targetsDeleted, targetsFailed int) {
+// Targets are grouped by targetMatchKey. Up to concurrency orgs are processed
+// at once; each org's lines are buffered under an org header and printed
+// together when it finishes.
+func cleanupEmptyTargets(ctx context.Context, api SnykAPI, doDelete, normalized bool, concurrency int, orgsAffected map[string]bool) (targetsDeleted, targetsFailed int) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	orgLim := newLimiter(concurrency)
 	for orgID := range orgsAffected {
-		targets, err := api.FetchTargets(ctx, orgID)
-		if err != nil {
-			log.Printf("WARNING: Could not fetch targets for org %s: %v", orgID, err)
-			continue
-		}
-		activeTargets := make(map[string]bool)
-		projects, err := api.FetchProjects(ctx, orgID)
-		if err != nil {
-			log.Printf("WARNING: Could not re-fetch projects for org %s: %v", orgID, err)
-			continue
-		}
-		for _, p := range projects {
-			if p.TargetID != "" {
-				activeTargets[p.TargetID] = true
+		wg.Add(1)
+		go func(orgID string) {
+			defer wg.Done()
+			if err := orgLim.acquire(ctx); err != nil {
+				return
+			}
+			defer orgLim.release()
+			var out bytes.Buffer
+			var warnings []string
+			deleted, failed := cleanupOrgEmptyTargets(ctx, api, doDelete, normalized, orgID, &out, &warnings)
+			mu.Lock()
+			defer mu.Unlock()
+			targetsDeleted += deleted
+			targetsFailed += failed
+			for _, w := range warnings {
+				log.Print(w)
 			}
+			os.Stdout.Write(out.Bytes())
+		}(orgID)
+	}
+	wg.Wait()
+	return targetsDeleted, targetsFailed
+}
+
+// writeTargetCleanupSummary writes the summary of a --delete-targets-only
+// run, where deleted counts the targets deleted or, in a dry run, those that
+// would be.
+func writeTargetCleanupSummary(w io.Writer, doDelete bool, deleted, failed int) {
+	switch {
+	case deleted == 0 && failed == 0:
+		fmt.Fprintln(w, "No empty duplicate targets found.")
+	case doDelete:
+		fmt.Fprintf(w, "Summary: %d empty target(s) cleaned up, %d failed.\n", deleted, failed)
+	default:
+		fmt.Fprintf(w, "Summary: %d empty duplicate target(s) would be removed.\nRun with --delete to remove them.\n", deleted)
+	}
+}
+
+// cleanupOrgEmptyTargets is cleanupEmptyTargets for one org. Report lines are
+// written to out, after an "Org <id>:" header when there are any, and
+// warnings appended to warnings, for the caller to print once the org is done.
+func cleanupOrgEmptyTargets(ctx context.Context, api SnykAPI, doDelete, normalized bool, orgID string, out io.Writer, warnings *[]string) (targetsDeleted, targetsFailed int) {
+	ctx = internal.WithRequestLabel(ctx, "org "+orgID)
+	warn := func(format string, args ...interface{}) {
+		*warnings = append(*warnings, fmt.Sprintf(format, args...))
+	}
+	headed := false
+	report := func(format string, args ...interface{}) {
+		if !headed {
+			fmt.Fprintf(out, "Org %s:\n", orgID)
+			headed = true
 		}
-		targetsByName := make(map[string][]internal.APITarget)
-		for _, t := range targets {
-			targetsByName[t.DisplayName] = append(targetsByName[t.DisplayName], t)
+		fmt.Fprintf(out, format, args...)
+	}
+	targets, err := api.FetchTargets(ctx, orgID)
+	if err != nil {
+		warn("WARNING: Could not fetch targets for org %s: %v", orgID, err)
+		return 0, 0
+	}
+	activeTargets := make(map[string]bool)
+	projects, err := api.FetchProjects(ctx, orgID)
+	if err != nil {
+		warn("WARNING: Could not re-fetch projects for org %s: %v", orgID, err)
+		return 0, 0
+	}
+	for _, p := range projects {
+		if p.TargetID != "" {
+			activeTargets[p.TargetID] = true
 		}
-		for name, tgts := range targetsByName {
-			if len(tgts) < 2 {
+	}
+	targetsByName := make(map[string][]internal.APITarget)
+	for _, t := range targets {
+		key := targetMatchKey(t.DisplayName, normalized)
+		targetsByName[key] = append(targetsByName[key], t)
+	}
+	for _, tgts := range targetsByName {
+		if len(tgts) < 2 {
+			continue
+		}
+		for _, t := range tgts {
+			if activeTargets[t.ID] {
 				continue
 			}
-			for _, t := range tgts {
-				if activeTargets[t.ID] {
-					continue
-				}
-				if doDelete {
-					err := api.DeleteTarget(ctx, orgID, t.ID)
-					if err != nil {
-						targetsFailed++
-						log.Printf("  target %s (%s, %s): failed to delete: %v", t.ID, name, t.IntegrationType, err)
-					} else {
-						targetsDeleted++
-						fmt.Printf("  target %s (%s, %s): deleted\n", t.ID, name, t.IntegrationType)
-					}
+			name := t.DisplayName
+			if doDelete {
+				err := api.DeleteTarget(ctx, orgID, t.ID)
+				if err != nil {
+					targetsFailed++
+					warn("WARNING: Org %s: target %s (%s, %s): failed to delete: %v", orgID, t.ID, name, t.IntegrationType, err)
 				} else {
-					fmt.Printf("  target %s (%s, %s): empty, would be deleted\n", t.ID, name, t.IntegrationType)
 					targetsDeleted++
+					report("  target %s (%s, %s): deleted\n", t.ID, name, t.IntegrationType)
 				}
+			} else {
+				report("  target %s (%s, %s): empty, would be deleted\n", t.ID, name, t.IntegrationType)
+				targetsDeleted++
 			}
 		}
 	}
 	return targetsDeleted, targetsFailed
 }
 
+// duplicateTargetGroup holds targets in one org that share a DisplayName,
+// sorted by CreatedAt (oldest first), with the IDs of those that have no projects.
+type duplicateTargetGroup struct {
+	displayName string
+	targets     []internal.APITarget
+	empty       map[string]bool
+}
+
+// findDuplicateTargets groups targets by targetMatchKey and returns groups with 2+
+// targets, sorted by display name. A target is empty when no project references it.
+func findDuplicateTargets(targets []internal.APITarget, projects []internal.Project, normalized bool) []duplicateTargetGroup {
+	active := make(map[string]bool)
+	for _, p := range projects {
+		if p.TargetID != "" {
+			active[p.TargetID] = true
+		}
+	}
+	byName := make(map[string][]internal.APITarget)
+	for _, t := range targets {
+		key := targetMatchKey(t.DisplayName, normalized)
+		byName[key] = append(byName[key], t)
+	}
+	var out []duplicateTargetGroup
+	for _, tgts := range byName {
+		if len(tgts) < 2 {
+			continue
+		}
+		sort.Slice(tgts, func(i, j int) bool { return tgts[i].CreatedAt < tgts[j].CreatedAt })
+		g := duplicateTargetGroup{displayName: tgts[0].DisplayName, targets: tgts, empty: make(map[string]bool)}
+		for _, t := range tgts {
+			if !active[t.ID] {
+				g.empty[t.ID] = true
+			}
+		}
+		out = append(out, g)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].displayName < out[j].displayName })
+	return out
+}
+
+// reportDuplicateTargets fetches targets and projects for each org in parallel and
+// prints targets duplicated by DisplayName, marking which are empty. It never deletes.
+// Returns the number of duplicate target groups found and orgs that failed.
+func reportDuplicateTargets(ctx context.Context, api SnykAPI, orgs []internal.Org, normalized bool) (groupsFound, failedOrgs int) {
+	type orgReport struct {
+		label  string
+		groups []duplicateTargetGroup
+		err    error
+	}
+	reports := make([]orgReport, len(orgs))
+	var wg sync.WaitGroup
+	for i, org := range orgs {
+		wg.Add(1)
+		go func(i int, o internal.Org) {
+			defer wg.Done()
+			rep := orgReport{label: orgLabel(o)}
+			ctx := internal.WithRequestLabel(ctx, "org "+rep.label)
+			targets, err := api.FetchTargets(ctx, o.ID)
+			if err != nil {
+				rep.err = fmt.Errorf("fetch targets: %w", err)
+				reports[i] = rep
+				return
+			}
+			projects, err := api.FetchProjects(ctx, o.ID)
+			if err != nil {
+				rep.err = fmt.Errorf("fetch projects: %w", err)
+				reports[i] = rep
+				return
+			}
+			rep.groups = findDuplicateTargets(targets, projects, normalized)
+			reports[i] = rep
+		}(i, org)
+	}
+	wg.Wait()
+
+	for _, rep := range reports {
+		if rep.err != nil {
+			failedOrgs++
+			log.Printf("WARNING: Failed to process org %s: %v", rep.label, rep.err)
+			continue
+		}
+		if len(rep.groups) == 0 {
+			continue
+		}
+		fmt.Printf("\nOrg: %s\n", rep.label)
+		for _, g := range rep.groups {
+			groupsFound++
+			fmt.Printf("  DUPLICATE TARGET  %s (%d targets)\n", g.displayName, len(g.targets))
+			for _, t := range g.targets {
+				state := "has projects"
+				if g.empty[t.ID] {
+					state = "empty"
+				}
+				fmt.Printf("    %s  integration=%s  created %s  %s\n", t.ID, t.IntegrationType, t.CreatedAt, state)
+			}
+		}
+	}
+	return groupsFound, failedOrgs
+}
+
+// dedupGroupScope resolves --scope and the older --withinOrg flag to whether
+// duplicates are matched across all orgs in the group. explicit holds the
+// names of flags given on the command line; an explicit --scope wins, and
+// contradicting it with an explicit --withinOrg is an error.
+func dedupGroupScope(scope string, withinOrg bool, explicit map[string]bool) (bool, error) {
+	if !explicit["scope"] {
+		return !withinOrg, nil
+	}
+	var group bool
+	switch scope {
+	case "org":
+	case "group":
+		group = true
+	default:
+		return false, fmt.Errorf("--scope must be org or group, got %q", scope)
+	}
+	if explicit["withinOrg"] && withinOrg == group {
+		return false, fmt.Errorf("--scope=%s contradicts --withinOrg=%t", scope, withinOrg)
+	}
+	return group, nil
+}
+
 // runDedup implements the dedup subcommand.
 func runDedup(args []string) {
 	fs := flag.NewFlagSet("dedup", flag.ExitOnError)
+	apiOpts := addAPIFlags(fs)
 	groupID := fs.String("groupId", "", "Snyk group ID (all orgs in this group will be scanned)")
+	orgName := fs.String("org-name", "", "Resolve a single org in --groupId by name or slug (case-insensitive)")
+	fastOrgFetch := fs.Bool("fast-org-fetch", false, "List group orgs with the REST API (cursor pagination), falling back to the v1 API on error")
 	orgID := fs.String("orgId", "", "Single Snyk org ID to scan")
-	concurrency := fs.Int("concurrency", 5, "Number of orgs to process in parallel")
+	concurrency := fs.Int("concurrency", 5, "Maximum number of concurrent Snyk API calls (bounds orgs processed in parallel)")
+	maxConcurrency := fs.Int("max-concurrency", 0, "Enable adaptive concurrency: start at --concurrency, halve on 429s, grow when clean, never above this ceiling (0 disables)")
+	concurrencyRamp := fs.Duration("concurrency-ramp", 2*time.Second, "Stagger concurrency up to --concurrency over this duration to avoid initial 429 bursts (0 disables)")
+	deleteConcurrency := fs.Int("delete-concurrency", 2, "Maximum number of concurrent delete calls (separate from --concurrency)")
 	doDelete := fs.Bool("delete", false, "Actually delete duplicates (default is dry-run)")
 	debug := fs.Bool("debug", false, "Print detailed project info for debugging")
 	considerOrigin := fs.Bool("considerOrigin", false, "Only treat as duplicates when name and integration origin match (e.g. keep same repo from github and gitlab)")
-	withinOrg := fs.Bool("withinOrg", true, "Only treat as duplicates within the same org (when false, same name across orgs in the group is deduped)")
+	strictDedup := fs.Bool("strict-dedup", false, "Group duplicates by (name, origin) instead of name only; same as --considerOrigin")
+	dedupMode := fs.String("dedup-mode", "name", "How to group duplicates: name (project name, see --considerOrigin) or canonical (repo+branch+manifest across origins)")
+	deleteTargetsOnly := fs.Bool("delete-targets-only", false, "Skip the duplicate-project phase and only clean up empty duplicate targets in the selected orgs (dry run unless --delete)")
+	reportDupTargets := fs.Bool("report-duplicate-targets", false, "Only report targets duplicated by display name (including non-empty ones); never deletes")
+	heartbeat := fs.Duration("heartbeat", 0, "Log a one-line progress summary with an ETA at this interval, e.g. 1m (0 disables)")
+	shuffle := fs.Bool("shuffle-orgs", false, "Scan orgs in random order so a cluster of large orgs does not hold every concurrency slot")
+	maxDeletesPerOrg := fs.Int("max-deletes-per-org", 0, "Stop deleting duplicates in an org after this many (0 = unlimited); remaining duplicates there are kept and reported")
+	dedupKey := fs.String("dedup-key", "", "Grouping preset: name, name+origin, name+branch, target, or canonical-repo (replaces --dedup-mode and --considerOrigin)")
+	normalizeNames := fs.Bool("normalize-names", false, "Group duplicates by repo (owner/repo, case-insensitive, manifest ignored) and branch instead of the exact project name")
+	targetMatchNormalized := fs.Bool("target-match-normalized", false, "Match duplicate targets on display names lower-cased and without a trailing (branch), instead of exactly")
+	undoFile := fs.String("undo-file", "", "With --delete, first write the full details of every project about to be deleted to this JSON file")
+	expectDeletes := fs.Int("expect-deletes", -1, "With --delete, abort unless exactly this many duplicate projects would be deleted (-1 = no check)")
+	withinOrg := fs.Bool("withinOrg", true, "Only treat as duplicates within the same org (false is the same as --scope=group)")
+	scope := fs.String("scope", "org", "Where to look for duplicates: org (within each org) or group (across all orgs in --groupId; deleting requires --yes)")
+	yes := fs.Bool("yes", false, "Confirm --delete with --scope=group, which deletes projects in other orgs than the one kept")
 	if err := fs.Parse(args); err != nil {
 		os.Exit(1)
 	}
+	if err := applyEnvDefaults(fs); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := configureAPI(apiOpts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer warnDeprecatedAPIVersion()
 
 	if err := validateGroupOrOrg(*groupID, *orgID); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		fs.Usage()
 		os.Exit(1)
 	}
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	groupScope, err := dedupGroupScope(*scope, *withinOrg, explicit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	*withinOrg = !groupScope
+	if groupScope && *groupID == "" {
+		fmt.Fprintf(os.Stderr, "Error: --scope=group requires --groupId\n")
+		os.Exit(1)
+	}
+	if groupScope && *doDelete && !*yes {
+		fmt.Fprintf(os.Stderr, "Error: --scope=group --delete removes projects across orgs; review a dry run first, then add --yes to confirm\n")
+		os.Exit(1)
+	}
+	if *deleteTargetsOnly {
+		for _, name := range []string{"report-duplicate-targets", "undo-file", "expect-deletes", "max-deletes-per-org"} {
+			if explicit[name] {
+				fmt.Fprintf(os.Stderr, "Error: --delete-targets-only deletes no projects and cannot be combined with --%s\n", name)
+				os.Exit(1)
+			}
+		}
+		if groupScope {
+			fmt.Fprintf(os.Stderr, "Error: --delete-targets-only works per org and cannot be combined with --scope=group\n")
+			os.Exit(1)
+		}
+	}
+	if *strictDedup {
+		*considerOrigin = true
+	}
+	keyFn, err := dedupKeyForMode(*dedupMode, *considerOrigin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *dedupKey != "" {
+		for _, name := range []string{"dedup-mode", "considerOrigin", "strict-dedup", "normalize-names"} {
+			if explicit[name] {
+				fmt.Fprintf(os.Stderr, "Error: --dedup-key replaces --%s; pass only one\n", name)
+				os.Exit(1)
+			}
+		}
+		if keyFn, err = dedupKeyForPreset(*dedupKey); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *normalizeNames {
+		if *dedupMode != "name" {
+			fmt.Fprintf(os.Stderr, "Error: --normalize-names applies to --dedup-mode=name only\n")
+			os.Exit(1)
+		}
+		keyFn = normalizedNameKey(*considerOrigin)
+	}
+	if *heartbeat < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --heartbeat must be 0 (off) or positive, got %v\n", *heartbeat)
+		os.Exit(1)
+	}
+	if *maxDeletesPerOrg < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --max-deletes-per-org must be 0 (unlimited) or positive, got %d\n", *maxDeletesPerOrg)
+		os.Exit(1)
+	}
+	if *expectDeletes < -1 || (*expectDeletes >= 0 && !*doDelete) {
+		fmt.Fprintf(os.Stderr, "Error: --expect-deletes must be 0 or more and requires --delete\n")
+		os.Exit(1)
+	}
+	if *undoFile != "" && !*doDelete {
+		fmt.Fprintf(os.Stderr, "Error: --undo-file requires --delete\n")
+		os.Exit(1)
+	}
+	for name, n := range map[string]int{"concurrency": *concurrency, "delete-concurrency": *deleteConcurrency} {
+		if err := validateConcurrency(name, n); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := validateMaxConcurrency(*maxConcurrency, *concurrency); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 
 	token, err := internal.GetSnykToken()
 	if err != nil {
@@ -232,19 +855,61 @@ func runDedup(args []string) {
 	}
 
 	ctx := context.Background()
-	api := newSnykAPI(internal.NewHTTPClient(), token)
+	lim := newRunLimiter(*concurrency, *maxConcurrency)
+	lim.ramp(ctx, *concurrencyRamp)
+	lim.adapt(ctx, adaptInterval)
+	api := withLimiter(newSnykAPI(internal.NewHTTPClient(), token, *fastOrgFetch), lim, newLimiter(*deleteConcurrency))
 
-	orgs, err := resolveOrgs(ctx, api, *groupID, *orgID)
+	orgs, err := resolveOrgs(ctx, api, *groupID, *orgID, *orgName)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error fetching orgs: %v\n", err)
 		os.Exit(1)
 	}
+	if *shuffle {
+		orgs = shuffleOrgs(orgs, rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())))
+	}
+
+	if *reportDupTargets {
+		log.Printf("Scanning %d organization(s) for duplicate targets...", len(orgs))
+		groupsFound, failedOrgs := reportDuplicateTargets(ctx, api, orgs, *targetMatchNormalized)
+		fmt.Println()
+		if groupsFound == 0 {
+			fmt.Print("No duplicate targets found.")
+		} else {
+			fmt.Printf("Summary: %d duplicate target group(s).", groupsFound)
+		}
+		if failedOrgs > 0 {
+			fmt.Printf(" (%d org(s) failed to scan)", failedOrgs)
+		}
+		fmt.Println()
+		return
+	}
+
+	if *deleteTargetsOnly {
+		if !*doDelete {
+			log.Println("DRY RUN -- no targets will be deleted. Use --delete to remove them.")
+		}
+		log.Printf("Checking %d organization(s) for empty duplicate targets (--delete-targets-only)...", len(orgs))
+		orgIDs := make(map[string]bool, len(orgs))
+		for _, o := range orgs {
+			orgIDs[o.ID] = true
+		}
+		targetsDeleted, targetsFailed := cleanupEmptyTargets(ctx, api, *doDelete, *targetMatchNormalized, *concurrency, orgIDs)
+		log.Printf("API retries during run: %d", internal.RetryCount())
+		lim.logSaturation()
+		fmt.Println()
+		writeTargetCleanupSummary(os.Stdout, *doDelete, targetsDeleted, targetsFailed)
+		return
+	}
 
 	if !*doDelete {
 		log.Println("DRY RUN -- no projects will be deleted. Use --delete to remove duplicates.")
 	}
 
 	log.Printf("Scanning %d organization(s) for duplicates with concurrency %d...", len(orgs), *concurrency)
+	if groupScope {
+		log.Printf("Scope: group -- duplicates are matched across all %d org(s); the oldest project is kept, whichever org it is in", len(orgs))
+	}
 
 	type dedupResult struct {
 		orgID        string
@@ -256,17 +921,22 @@ func runDedup(args []string) {
 	}
 
 	results := make(chan dedupResult, len(orgs))
-	sem := make(chan struct{}, *concurrency)
 	var wg sync.WaitGroup
+	prog := newProgress(len(orgs))
+	stopWatch := watchProgressSignal(prog)
+	defer stopWatch()
+	stopHeartbeat := startHeartbeat(prog, *heartbeat)
+	defer stopHeartbeat()
 
 	for _, org := range orgs {
 		wg.Add(1)
 		go func(o internal.Org) {
 			defer wg.Done()
-			sem <- struct{}{}        // acquire
-			defer func() { <-sem }() // release
 
 			res := dedupResult{orgID: o.ID, orgLabel: orgLabel(o)}
+			prog.start(res.orgLabel)
+			defer func() { prog.finish(res.orgLabel, res.err) }()
+			ctx := internal.WithRequestLabel(ctx, "org "+res.orgLabel)
 
 			projects, err := api.FetchProjects(ctx, o.ID)
 			if err != nil {
@@ -285,7 +955,7 @@ func runDedup(args []string) {
 				}
 			}
 
-			res.groups = findDuplicateGroups(projects, *considerOrigin)
+			res.groups = findDuplicateGroups(projects, keyFn)
 			results <- res
 		}(org)
 	}
@@ -318,16 +988,43 @@ func runDedup(args []string) {
 		}
 	}
 
+	var groupsWide []duplicateGroupGroupWide
+	if !*withinOrg {
+		groupsWide = findDuplicateGroupsGroupWide(allProjectsInOrg, keyFn)
+	}
+
+	dups := duplicatesInReportOrder(orgsWithDuplicates, groupsWide)
+	if *expectDeletes >= 0 {
+		dupOrgIDs := make([]string, len(dups))
+		for i, d := range dups {
+			dupOrgIDs[i] = d.orgID
+		}
+		if n := plannedDeletes(*maxDeletesPerOrg, dupOrgIDs); n != *expectDeletes {
+			fmt.Fprintf(os.Stderr, "Error: --expect-deletes=%d but this run would delete %d duplicate project(s); nothing was deleted. Re-run without --delete to review.\n", *expectDeletes, n)
+			os.Exit(1)
+		}
+		log.Printf("Planned deletions match --expect-deletes=%d; proceeding", *expectDeletes)
+	}
+	if *undoFile != "" {
+		// Written before any deletion so a crash mid-run still leaves the record.
+		n, err := writeUndoFile(*undoFile, *maxDeletesPerOrg, dups)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: writing --undo-file: %v; nothing was deleted\n", err)
+			os.Exit(1)
+		}
+		log.Printf("Recorded %d project(s) to be deleted in %s", n, *undoFile)
+	}
+
 	var orgsAffected map[string]bool
 	var totalDuplicates, totalDeleted, totalFailed int
+	dc := newDeleteCap(*maxDeletesPerOrg)
 
 	if *withinOrg {
 		// Phase 1 (per-org): Report and optionally delete duplicate projects
-		orgsAffected, totalDuplicates, totalDeleted, totalFailed = reportAndDeleteDuplicates(ctx, api, *doDelete, orgsWithDuplicates)
+		orgsAffected, totalDuplicates, totalDeleted, totalFailed = reportAndDeleteDuplicates(ctx, api, *doDelete, dc, orgsWithDuplicates)
 	} else {
-		// Phase 1 (group-wide): Find duplicate groups across orgs, report and optionally delete
-		groupsWide := findDuplicateGroupsGroupWide(allProjectsInOrg, *considerOrigin)
-		orgsAffected, totalDuplicates, totalDeleted, totalFailed = reportAndDeleteDuplicatesGroupWide(ctx, api, *doDelete, groupsWide)
+		// Phase 1 (group-wide): Report duplicate groups across orgs and optionally delete
+		orgsAffected, totalDuplicates, totalDeleted, totalFailed = reportAndDeleteDuplicatesGroupWide(ctx, api, *doDelete, dc, groupsWide)
 	}
 
 	// Phase 2: Find and clean up empty duplicate targets
@@ -338,9 +1035,12 @@ func runDedup(args []string) {
 		} else if totalDuplicates > 0 {
 			fmt.Println("\nEmpty duplicate targets that would be removed:")
 		}
-		targetsDeleted, targetsFailed = cleanupEmptyTargets(ctx, api, *doDelete, orgsAffected)
+		targetsDeleted, targetsFailed = cleanupEmptyTargets(ctx, api, *doDelete, *targetMatchNormalized, *concurrency, orgsAffected)
 	}
 
+	log.Printf("API retries during run: %d", internal.RetryCount())
+	lim.logSaturation()
+
 	// Summary
 	fmt.Println()
 	if totalDuplicates == 0 && targetsDeleted == 0 {
@@ -358,10 +1058,18 @@ func runDedup(args []string) {
 		if targetsDeleted > 0 {
 			fmt.Printf("\n         %d empty duplicate target(s) would be removed.", targetsDeleted)
 		}
-		fmt.Printf("\nRun with --delete to remove them.")
+		if *withinOrg {
+			fmt.Printf("\nRun with --delete to remove them.")
+		} else {
+			fmt.Printf("\nRun with --delete --yes to remove them.")
+		}
 	}
 	if failedOrgs > 0 {
 		fmt.Printf(" (%d org(s) failed to scan)", failedOrgs)
 	}
+	if capped, skipped := dc.cappedOrgs(); len(capped) > 0 {
+		fmt.Printf("\n         %d org(s) hit --max-deletes-per-org; %d duplicate(s) kept: %s",
+			len(capped), skipped, strings.Join(capped, ", "))
+	}
 	fmt.Println()
 }
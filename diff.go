@@ -0,0 +1,237 @@
+// diff.go implements the diff subcommand: compare two refresh output files and
+// report added/removed targets and changed org/integration metadata, so drift
+// between runs can be reviewed before importing.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/snyk-playground/snyk-target-export/internal"
+)
+
+// metaChange is one org or integration whose metadata differs between files.
+// Old is empty for additions and New is empty for removals.
+type metaChange struct {
+	ID  string `json:"id"`
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+// refreshDiff is the result of comparing two refresh outputs.
+type refreshDiff struct {
+	Added              []internal.ImportTarget `json:"added"`
+	Removed            []internal.ImportTarget `json:"removed"`
+	OrgChanges         []metaChange            `json:"orgChanges"`
+	IntegrationChanges []metaChange            `json:"integrationChanges"`
+}
+
+// empty reports whether the two files were equivalent.
+func (d refreshDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.OrgChanges) == 0 && len(d.IntegrationChanges) == 0
+}
+
+// importTargetID is the key targets are matched on between files.
+func importTargetID(t internal.ImportTarget) string {
+	return internal.TargetID(t.OrgID, t.IntegrationID, t.Target)
+}
+
+// diffRefreshOutputs compares old and new. Targets are matched by TargetID;
+// results are sorted so output is stable.
+func diffRefreshOutputs(old, new RefreshOutput) refreshDiff {
+	d := refreshDiff{
+		Added:              []internal.ImportTarget{},
+		Removed:            []internal.ImportTarget{},
+		OrgChanges:         []metaChange{},
+		IntegrationChanges: []metaChange{},
+	}
+
+	oldTargets := make(map[string]bool, len(old.Targets))
+	for _, t := range old.Targets {
+		oldTargets[importTargetID(t)] = true
+	}
+	newTargets := make(map[string]bool, len(new.Targets))
+	for _, t := range new.Targets {
+		id := importTargetID(t)
+		newTargets[id] = true
+		if !oldTargets[id] {
+			d.Added = append(d.Added, t)
+		}
+	}
+	for _, t := range old.Targets {
+		if !newTargets[importTargetID(t)] {
+			d.Removed = append(d.Removed, t)
+		}
+	}
+	byID := func(ts []internal.ImportTarget) {
+		sort.Slice(ts, func(i, j int) bool { return importTargetID(ts[i]) < importTargetID(ts[j]) })
+	}
+	byID(d.Added)
+	byID(d.Removed)
+
+	d.OrgChanges = diffMeta(orgMetaStrings(old.Orgs), orgMetaStrings(new.Orgs))
+	d.IntegrationChanges = diffMeta(old.Integrations, new.Integrations)
+	return d
+}
+
+// orgMetaStrings renders org metadata as "name (slug)" for comparison.
+func orgMetaStrings(orgs map[string]OrgMeta) map[string]string {
+	m := make(map[string]string, len(orgs))
+	for id, meta := range orgs {
+		s := meta.Name
+		if meta.Slug != "" {
+			s = fmt.Sprintf("%s (%s)", s, meta.Slug)
+		}
+		m[id] = s
+	}
+	return m
+}
+
+// diffMeta returns the keys whose values were added, removed, or changed,
+// sorted by key.
+func diffMeta(old, new map[string]string) []metaChange {
+	changes := []metaChange{}
+	for id, o := range old {
+		if n, ok := new[id]; !ok || n != o {
+			changes = append(changes, metaChange{ID: id, Old: o, New: n})
+		}
+	}
+	for id, n := range new {
+		if _, ok := old[id]; !ok {
+			changes = append(changes, metaChange{ID: id, New: n})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].ID < changes[j].ID })
+	return changes
+}
+
+// loadRefreshOutput reads a refresh output file. Grouped (--group-output) and
+// --stable-maps files are converted to the flat form; v1 files load with
+// targets only.
+func loadRefreshOutput(path string) (RefreshOutput, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return RefreshOutput{}, err
+	}
+	var probe struct {
+		Orgs    json.RawMessage `json:"orgs"`
+		Targets json.RawMessage `json:"targets"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return RefreshOutput{}, fmt.Errorf("decode %s: %w", path, err)
+	}
+	orgs := bytes.TrimSpace(probe.Orgs)
+	switch {
+	case bytes.HasPrefix(orgs, []byte("[")):
+		var stable StableRefreshOutput
+		if err := json.Unmarshal(data, &stable); err != nil {
+			return RefreshOutput{}, fmt.Errorf("decode %s: %w", path, err)
+		}
+		return fromStableOutput(stable), nil
+	case probe.Targets == nil && bytes.HasPrefix(orgs, []byte("{")):
+		var grouped GroupedRefreshOutput
+		if err := json.Unmarshal(data, &grouped); err != nil {
+			return RefreshOutput{}, fmt.Errorf("decode %s: %w", path, err)
+		}
+		out := RefreshOutput{
+			GroupID:      grouped.GroupID,
+			Orgs:         make(map[string]OrgMeta, len(grouped.Orgs)),
+			Integrations: grouped.Integrations,
+			PartialOrgs:  grouped.PartialOrgs,
+		}
+		for id, o := range grouped.Orgs {
+			out.Orgs[id] = o.Meta
+			out.Targets = append(out.Targets, o.Targets...)
+		}
+		return out, nil
+	}
+	var out RefreshOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return RefreshOutput{}, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return out, nil
+}
+
+// printDiffText writes d in a human-readable form.
+func printDiffText(w io.Writer, d refreshDiff) {
+	if d.empty() {
+		fmt.Fprintln(w, "No differences.")
+		return
+	}
+	fmt.Fprintf(w, "Targets: %d added, %d removed\n", len(d.Added), len(d.Removed))
+	for _, t := range d.Added {
+		fmt.Fprintf(w, "  + %s\n", importTargetID(t))
+	}
+	for _, t := range d.Removed {
+		fmt.Fprintf(w, "  - %s\n", importTargetID(t))
+	}
+	printMetaChanges(w, "Orgs", d.OrgChanges)
+	printMetaChanges(w, "Integrations", d.IntegrationChanges)
+}
+
+func printMetaChanges(w io.Writer, title string, changes []metaChange) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%s changed: %d\n", title, len(changes))
+	for _, c := range changes {
+		switch {
+		case c.Old == "":
+			fmt.Fprintf(w, "  + %s: %q\n", c.ID, c.New)
+		case c.New == "":
+			fmt.Fprintf(w, "  - %s: %q\n", c.ID, c.Old)
+		default:
+			fmt.Fprintf(w, "  ~ %s: %q -> %q\n", c.ID, c.Old, c.New)
+		}
+	}
+}
+
+// runDiff implements the diff subcommand.
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	format := fs.String("format", "text", "Output format: text or json")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: snyk-target-export diff [--format=text|json] <old.json> <new.json>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Error: --format must be text or json, got %q\n", *format)
+		os.Exit(1)
+	}
+	if fs.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "Error: diff takes exactly two refresh output files\n")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	old, err := loadRefreshOutput(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	new, err := loadRefreshOutput(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	d := diffRefreshOutputs(old, new)
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(d); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	printDiffText(os.Stdout, d)
+}
@@ -0,0 +1,73 @@
+// hcl.go renders refresh output as Terraform-friendly HCL (--format=hcl). This
+// is experimental and not readable by snyk-api-import.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// hclLocalName is the Terraform local the targets are assigned to.
+const hclLocalName = "snyk_import_targets"
+
+// hclString quotes s as an HCL string literal. JSON string escapes are valid
+// HCL; template sequences are escaped so values are taken literally.
+func hclString(s string) string {
+	b, _ := json.Marshal(s)
+	q := strings.ReplaceAll(string(b), "${", "$${")
+	return strings.ReplaceAll(q, "%{", "%%{")
+}
+
+// renderHCL writes out.Targets as a list of objects in a Terraform locals
+// block, one object per target, with attributes aligned as terraform fmt
+// would. Empty target fields are omitted.
+func renderHCL(out RefreshOutput) []byte {
+	var b strings.Builder
+	b.WriteString("# Generated by snyk-target-export --format=hcl (experimental).\n")
+	fmt.Fprintf(&b, "# Reference the targets as local.%s.\n", hclLocalName)
+	b.WriteString("locals {\n")
+	fmt.Fprintf(&b, "  %s = [\n", hclLocalName)
+	for _, t := range out.Targets {
+		type attr struct{ key, value string }
+		attrs := []attr{
+			{"org_id", hclString(t.OrgID)},
+			{"integration_id", hclString(t.IntegrationID)},
+		}
+		add := func(key, value string) {
+			if value != "" {
+				attrs = append(attrs, attr{key, hclString(value)})
+			}
+		}
+		add("integration_type", out.Integrations[t.IntegrationID])
+		add("owner", t.Target.Owner)
+		add("name", t.Target.Name)
+		add("branch", t.Target.Branch)
+		add("project_key", t.Target.ProjectKey)
+		add("repo_slug", t.Target.RepoSlug)
+		add("project_id", t.ProjectID)
+		add("project_name", t.ProjectName)
+		if len(t.Files) > 0 {
+			paths := make([]string, len(t.Files))
+			for i, f := range t.Files {
+				paths[i] = hclString(f.Path)
+			}
+			attrs = append(attrs, attr{"files", "[" + strings.Join(paths, ", ") + "]"})
+		}
+
+		width := 0
+		for _, a := range attrs {
+			if len(a.key) > width {
+				width = len(a.key)
+			}
+		}
+		b.WriteString("    {\n")
+		for _, a := range attrs {
+			fmt.Fprintf(&b, "      %-*s = %s\n", width, a.key, a.value)
+		}
+		b.WriteString("    },\n")
+	}
+	b.WriteString("  ]\n")
+	b.WriteString("}\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,184 @@
+// End-to-end tests for the main package: a local httptest server serves the
+// canned responses under testdata/ in place of the Snyk API, and the
+// subcommands run against it via --base-url.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/snyk-playground/snyk-target-export/internal"
+)
+
+// fixtureServer is a fake Snyk API backed by testdata/ files. It records the
+// request paths it served so tests can assert on API usage.
+type fixtureServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	paths []string
+}
+
+// newFixtureServer starts a server that answers the endpoints used by the tool
+// with testdata/mock_*_response.json. Every org gets the same integrations,
+// projects, and targets. Skips the test if testdata is missing.
+func newFixtureServer(t *testing.T) *fixtureServer {
+	t.Helper()
+	load := func(name string) []byte {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Skipf("testdata not available: %v (run tests from module root)", err)
+		}
+		return data
+	}
+	orgs := load("mock_orgs_response.json")
+	integrations := load("mock_integrations_response.json")
+	projects := load("mock_projects_response.json")
+	targets := load("mock_targets_response.json")
+
+	fs := &fixtureServer{}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fs.mu.Lock()
+		fs.paths = append(fs.paths, r.Method+" "+r.URL.Path)
+		fs.mu.Unlock()
+		if r.Header.Get("Authorization") != "token test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		p := r.URL.Path
+		switch {
+		case strings.HasPrefix(p, "/v1/group/") && strings.HasSuffix(p, "/orgs"):
+			w.Write(orgs)
+		case strings.HasPrefix(p, "/v1/org/") && strings.HasSuffix(p, "/integrations"):
+			w.Write(integrations)
+		case strings.HasPrefix(p, "/rest/orgs/") && strings.HasSuffix(p, "/projects"):
+			w.Write(projects)
+		case strings.HasPrefix(p, "/rest/orgs/") && strings.HasSuffix(p, "/targets"):
+			w.Write(targets)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(fs.Close)
+	return fs
+}
+
+// TestRunRefresh_EndToEnd runs the refresh subcommand against the fixture
+// server and checks the written export file.
+func TestRunRefresh_EndToEnd(t *testing.T) {
+	srv := newFixtureServer(t)
+	t.Setenv("SNYK_TOKEN", "test-token")
+	defer internal.SetSnykAPIBaseURL("")
+
+	out := filepath.Join(t.TempDir(), "export-targets.json")
+	runRefresh([]string{
+		"--base-url=" + srv.URL,
+		"--groupId=group-1",
+		"--concurrency-ramp=0",
+		"--output=" + out,
+	})
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	var got RefreshOutput
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if got.GroupID != "group-1" {
+		t.Errorf("groupId = %q, want group-1", got.GroupID)
+	}
+	if len(got.Orgs) != 3 || got.Orgs["a0000001-0001-4000-8000-000000000001"].Slug != "example-org" {
+		t.Errorf("orgs = %+v, want 3 orgs from mock_orgs_response.json", got.Orgs)
+	}
+	// Each org sees the same 4 projects: two repo-a manifests (one target),
+	// demo-app, and app-dvja.
+	if len(got.Targets) != 9 {
+		t.Fatalf("len(targets) = %d, want 9 (3 per org)", len(got.Targets))
+	}
+	names := make(map[string]int)
+	for _, tgt := range got.Targets {
+		names[tgt.Target.Owner+"/"+tgt.Target.Name+"@"+tgt.Target.Branch]++
+		if tgt.IntegrationID == "" {
+			t.Errorf("target %+v has no integrationId", tgt)
+		}
+	}
+	for _, want := range []string{"example-org/repo-a@main", "example-org/demo-app@master", "example-org/app-dvja@master"} {
+		if names[want] != 3 {
+			t.Errorf("target %s appears %d time(s), want 3", want, names[want])
+		}
+	}
+}
+
+// TestRunRefresh_CountOnly checks that --count-only prints the target totals
+// and per-integration counts and writes no output file.
+func TestRunRefresh_CountOnly(t *testing.T) {
+	srv := newFixtureServer(t)
+	t.Setenv("SNYK_TOKEN", "test-token")
+	defer internal.SetSnykAPIBaseURL("")
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	old := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = old }()
+
+	out := filepath.Join(t.TempDir(), "export-targets.json")
+	runRefresh([]string{
+		"--base-url=" + srv.URL,
+		"--groupId=group-1",
+		"--concurrency-ramp=0",
+		"--count-only",
+		"--output=" + out,
+	})
+	w.Close()
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		t.Fatalf("read stdout: %v", err)
+	}
+
+	got := buf.String()
+	for _, want := range []string{"Total: 9 target(s) across 3 org(s)", "By integration: bitbucket-connect-app: 3, github: 3, github-enterprise: 3", "No output written (--count-only)."} {
+		if !strings.Contains(got, want) {
+			t.Errorf("stdout does not contain %q:\n%s", want, got)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(out)); len(entries) != 0 {
+		t.Errorf("--count-only left %d file(s) in the output directory, want none", len(entries))
+	}
+}
+
+// TestFastOrgFetch_FallsBackToV1 checks that --fast-org-fetch tries the REST
+// group orgs endpoint first and falls back to v1 when it fails (the fixture
+// server has no REST orgs endpoint).
+func TestFastOrgFetch_FallsBackToV1(t *testing.T) {
+	srv := newFixtureServer(t)
+	if err := internal.SetSnykAPIBaseURL(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	defer internal.SetSnykAPIBaseURL("")
+
+	api := newSnykAPI(internal.NewHTTPClient(), "test-token", true)
+	orgs, err := api.FetchOrgs(context.Background(), "group-1")
+	if err != nil {
+		t.Fatalf("FetchOrgs: %v", err)
+	}
+	if len(orgs) != 3 {
+		t.Errorf("got %d orgs, want 3 from the v1 fallback", len(orgs))
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.paths) != 2 || srv.paths[0] != "GET /rest/groups/group-1/orgs" || srv.paths[1] != "GET /v1/group/group-1/orgs" {
+		t.Errorf("paths = %v, want REST attempt then v1", srv.paths)
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 // Org represents a Snyk organization.
@@ -16,33 +18,83 @@ type Org struct {
 	Slug string `json:"slug"`
 }
 
+// StatusError is returned when the API answers with an unexpected HTTP
+// status, so callers can act on specific codes (e.g. --skip-status).
+type StatusError struct {
+	Op         string // e.g. "fetch projects"
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: status %d, body: %s", e.Op, e.StatusCode, e.Body)
+}
+
+// Group represents a Snyk group the token can access.
+type Group struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
 // Project represents a Snyk project with the fields we need for refresh.
 type Project struct {
-	ID              string
-	Name            string
-	Origin          string
-	Branch          string
-	TargetReference string
-	Created         string // ISO 8601 timestamp from Snyk API
-	TargetID        string // Snyk target ID from relationships
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	Origin          string `json:"origin"`
+	Branch          string `json:"branch,omitempty"`
+	TargetReference string `json:"targetReference,omitempty"`
+	Created         string `json:"created,omitempty"`  // ISO 8601 timestamp from Snyk API
+	TargetID        string `json:"targetId,omitempty"` // Snyk target ID from relationships
+	Status          string `json:"status,omitempty"`   // e.g. "active", "inactive", or an in-progress import state
+	// RepoURL is the repo URL of the project's target, when the response
+	// includes it (expanded target attributes or a remote repo URL).
+	RepoURL string `json:"repoUrl,omitempty"`
+}
+
+// importingStatuses are project status values that mean an import has not
+// finished, so origin/target data may still be incomplete.
+var importingStatuses = map[string]bool{
+	"importing":   true,
+	"pending":     true,
+	"in_progress": true,
+}
+
+// brokenStatuses are project status values that suggest the remote repo is
+// gone or unreachable. Snyk documents only "active" and "inactive", and a
+// project can also be inactive because someone deactivated it, so this is a
+// best-effort signal.
+var brokenStatuses = map[string]bool{
+	"inactive": true,
+	"broken":   true,
+	"deleted":  true,
+}
+
+// IsBroken reports whether the project's status suggests its remote repo no
+// longer exists, so re-importing it would fail.
+func (p Project) IsBroken() bool {
+	return brokenStatuses[strings.ToLower(p.Status)]
+}
+
+// IsImporting reports whether the project is still being imported.
+func (p Project) IsImporting() bool {
+	return importingStatuses[strings.ToLower(p.Status)]
 }
 
 // FetchOrgs fetches all organizations in a Snyk group, handling pagination.
 func FetchOrgs(ctx context.Context, client *http.Client, token, groupID string) ([]Org, error) {
-	baseURL := GetSnykAPIBaseURL()
 	var allOrgs []Org
 	page := 1
 	perPage := 100
 
 	for {
-		apiURL := fmt.Sprintf("%s/v1/group/%s/orgs?perPage=%d&page=%d",
-			baseURL, url.PathEscape(groupID), perPage, page)
+		apiURL := fmt.Sprintf("%s/group/%s/orgs?perPage=%d&page=%d",
+			V1BaseURL(), url.PathEscape(groupID), perPage, page)
 
 		req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
 		if err != nil {
 			return nil, fmt.Errorf("create request: %w", err)
 		}
-		req.Header.Set("Authorization", "token "+token)
+		setAuth(req, token)
 		req.Header.Set("Accept", "application/json")
 
 		resp, body, err := DoWithRetry(ctx, client, req)
@@ -50,7 +102,7 @@ func FetchOrgs(ctx context.Context, client *http.Client, token, groupID string)
 			return nil, fmt.Errorf("fetch orgs page %d: %w", page, err)
 		}
 		if resp.StatusCode != 200 {
-			return nil, fmt.Errorf("fetch orgs: status %d, body: %s", resp.StatusCode, string(body))
+			return nil, &StatusError{Op: "fetch orgs", StatusCode: resp.StatusCode, Body: string(body)}
 		}
 
 		var response struct {
@@ -82,17 +134,129 @@ func FetchOrgs(ctx context.Context, client *http.Client, token, groupID string)
 	return allOrgs, nil
 }
 
+// FetchOrgsREST fetches all orgs in a group via the REST API
+// (/rest/groups/{id}/orgs), following cursor pagination. It is an alternative
+// to the v1 FetchOrgs for large groups (--fast-org-fetch).
+func FetchOrgsREST(ctx context.Context, client *http.Client, token, groupID string) ([]Org, error) {
+	baseURL := GetSnykAPIBaseURL()
+	nextURL := fmt.Sprintf("%s/groups/%s/orgs?version=%s&limit=100",
+		RESTBaseURL(), url.PathEscape(groupID), RESTVersion())
+	var orgs []Org
+
+	apiHost := "api.snyk.io"
+	if parsed, err := url.Parse(baseURL); err == nil && parsed.Host != "" {
+		apiHost = parsed.Host
+	}
+
+	for nextURL != "" {
+		req, err := http.NewRequestWithContext(ctx, "GET", nextURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+		setAuth(req, token)
+		req.Header.Set("Accept", RESTAccept())
+
+		resp, body, err := DoWithRetry(ctx, client, req)
+		if err != nil {
+			return nil, fmt.Errorf("fetch orgs: %w", err)
+		}
+		if resp.StatusCode != 200 {
+			return nil, &StatusError{Op: "fetch orgs", StatusCode: resp.StatusCode, Body: string(body)}
+		}
+
+		var result struct {
+			Data []struct {
+				ID         string `json:"id"`
+				Attributes struct {
+					Name string `json:"name"`
+					Slug string `json:"slug"`
+				} `json:"attributes"`
+			} `json:"data"`
+			Links map[string]interface{} `json:"links"`
+			Meta  map[string]interface{} `json:"meta"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("decode orgs response: %w", err)
+		}
+		for _, o := range result.Data {
+			orgs = append(orgs, Org{ID: o.ID, Name: o.Attributes.Name, Slug: o.Attributes.Slug})
+		}
+
+		var lastID string
+		if n := len(result.Data); n > 0 {
+			lastID = result.Data[n-1].ID
+		}
+		nextURL = nextPageURL(baseURL, apiHost, nextURL, result.Links, result.Meta, lastID, len(orgs))
+	}
+
+	return orgs, nil
+}
+
+// FetchGroups lists the groups the token can access via the REST API
+// (/rest/groups), following cursor pagination.
+func FetchGroups(ctx context.Context, client *http.Client, token string) ([]Group, error) {
+	baseURL := GetSnykAPIBaseURL()
+	nextURL := fmt.Sprintf("%s/groups?version=%s&limit=100", RESTBaseURL(), RESTVersion())
+	var groups []Group
+
+	apiHost := "api.snyk.io"
+	if parsed, err := url.Parse(baseURL); err == nil && parsed.Host != "" {
+		apiHost = parsed.Host
+	}
+
+	for nextURL != "" {
+		req, err := http.NewRequestWithContext(ctx, "GET", nextURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+		setAuth(req, token)
+		req.Header.Set("Accept", RESTAccept())
+
+		resp, body, err := DoWithRetry(ctx, client, req)
+		if err != nil {
+			return nil, fmt.Errorf("fetch groups: %w", err)
+		}
+		if resp.StatusCode != 200 {
+			return nil, &StatusError{Op: "fetch groups", StatusCode: resp.StatusCode, Body: string(body)}
+		}
+
+		var result struct {
+			Data []struct {
+				ID         string `json:"id"`
+				Attributes struct {
+					Name string `json:"name"`
+				} `json:"attributes"`
+			} `json:"data"`
+			Links map[string]interface{} `json:"links"`
+			Meta  map[string]interface{} `json:"meta"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("decode groups response: %w", err)
+		}
+		for _, g := range result.Data {
+			groups = append(groups, Group{ID: g.ID, Name: g.Attributes.Name})
+		}
+
+		var lastID string
+		if n := len(result.Data); n > 0 {
+			lastID = result.Data[n-1].ID
+		}
+		nextURL = nextPageURL(baseURL, apiHost, nextURL, result.Links, result.Meta, lastID, len(groups))
+	}
+
+	return groups, nil
+}
+
 // ListIntegrations lists integrations for a Snyk org.
 // Returns a map of integration type name to integration ID.
 func ListIntegrations(ctx context.Context, client *http.Client, token, orgID string) (map[string]string, error) {
-	baseURL := GetSnykAPIBaseURL()
-	apiURL := fmt.Sprintf("%s/v1/org/%s/integrations", baseURL, url.PathEscape(orgID))
+	apiURL := fmt.Sprintf("%s/org/%s/integrations", V1BaseURL(), url.PathEscape(orgID))
 
 	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("create request: %w", err)
 	}
-	req.Header.Set("Authorization", "token "+token)
+	setAuth(req, token)
 	req.Header.Set("Accept", "application/json")
 
 	resp, body, err := DoWithRetry(ctx, client, req)
@@ -100,7 +264,7 @@ func ListIntegrations(ctx context.Context, client *http.Client, token, orgID str
 		return nil, fmt.Errorf("list integrations: %w", err)
 	}
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("list integrations: status %d, body: %s", resp.StatusCode, string(body))
+		return nil, &StatusError{Op: "list integrations", StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	var data map[string]string
@@ -110,12 +274,62 @@ func ListIntegrations(ctx context.Context, client *http.Client, token, orgID str
 	return data, nil
 }
 
+// activeIntegrationStatuses are the integration status values treated as
+// able to import.
+var activeIntegrationStatuses = map[string]bool{
+	"active":  true,
+	"enabled": true,
+	"ok":      true,
+}
+
+// IntegrationActive reports whether an integration can still import, using
+// the v1 integration settings endpoint. An integration that no longer exists
+// (404), that reports disabled, or whose status is not active counts as
+// inactive. Settings with no status are taken as active.
+func IntegrationActive(ctx context.Context, client *http.Client, token, orgID, integrationID string) (bool, error) {
+	apiURL := fmt.Sprintf("%s/org/%s/integrations/%s/settings", V1BaseURL(), url.PathEscape(orgID), url.PathEscape(integrationID))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return false, fmt.Errorf("create request: %w", err)
+	}
+	setAuth(req, token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, body, err := DoWithRetry(ctx, client, req)
+	if err != nil {
+		return false, fmt.Errorf("get integration settings: %w", err)
+	}
+	if resp.StatusCode == 404 {
+		return false, nil
+	}
+	if resp.StatusCode != 200 {
+		return false, &StatusError{Op: "get integration settings", StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var settings map[string]interface{}
+	if err := json.Unmarshal(body, &settings); err != nil {
+		return false, fmt.Errorf("decode integration settings: %w", err)
+	}
+	if disabled, _ := settings["disabled"].(bool); disabled {
+		return false, nil
+	}
+	if status, ok := settings["status"].(string); ok && !activeIntegrationStatuses[strings.ToLower(status)] {
+		return false, nil
+	}
+	return true, nil
+}
+
 // FetchProjects fetches all projects for a Snyk org via the REST API,
 // including the origin and targetReference fields needed for refresh.
+//
+// Pages are pipelined: as soon as a page's links are known, the next page is
+// requested in the background while the current page's projects are decoded.
+// Projects are still returned in page order.
 func FetchProjects(ctx context.Context, client *http.Client, token, orgID string) ([]Project, error) {
 	baseURL := GetSnykAPIBaseURL()
-	firstURL := fmt.Sprintf("%s/rest/orgs/%s/projects?version=2025-09-28&limit=100",
-		baseURL, url.PathEscape(orgID))
+	firstURL := fmt.Sprintf("%s/orgs/%s/projects?version=%s&limit=100",
+		RESTBaseURL(), url.PathEscape(orgID), RESTVersion())
 	var projects []Project
 	nextURL := firstURL
 
@@ -125,92 +339,154 @@ func FetchProjects(ctx context.Context, client *http.Client, token, orgID string
 		apiHost = parsed.Host
 	}
 
-	for nextURL != "" {
-		req, err := http.NewRequestWithContext(ctx, "GET", nextURL, nil)
-		if err != nil {
-			return nil, fmt.Errorf("create request: %w", err)
-		}
-		req.Header.Set("Authorization", "token "+token)
-		req.Header.Set("Accept", "application/vnd.api+json")
+	// Cancels an in-flight prefetch if we return early.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-		resp, body, err := DoWithRetry(ctx, client, req)
-		if err != nil {
-			return nil, fmt.Errorf("fetch projects: %w", err)
+	page := fetchProjectsPage(ctx, client, token, nextURL)
+	for pages := 1; ; pages++ {
+		if page.err != nil {
+			return nil, page.err
 		}
-		if resp.StatusCode == 404 {
+		if page.status == 404 {
 			// Org not found or no projects
 			return projects, nil
 		}
-		if resp.StatusCode != 200 {
-			return nil, fmt.Errorf("fetch projects: status %d, body: %s", resp.StatusCode, string(body))
+		if page.status != 200 {
+			return nil, &StatusError{Op: "fetch projects", StatusCode: page.status, Body: string(page.body)}
 		}
 
-		var result struct {
+		// Decode just the page envelope to find the next URL, so the next
+		// request can start before the full decode below.
+		var envelope struct {
 			Data []struct {
-				ID            string                 `json:"id"`
-				Attributes    map[string]interface{} `json:"attributes"`
-				Relationships map[string]interface{} `json:"relationships"`
+				ID string `json:"id"`
 			} `json:"data"`
 			Links map[string]interface{} `json:"links"`
+			Meta  map[string]interface{} `json:"meta"`
 		}
-		if err := json.Unmarshal(body, &result); err != nil {
+		if err := json.Unmarshal(page.body, &envelope); err != nil {
 			return nil, fmt.Errorf("decode projects: %w", err)
 		}
 
-		for _, p := range result.Data {
-			attrs := p.Attributes
-			name, _ := attrs["name"].(string)
-			origin, _ := attrs["origin"].(string)
-			created, _ := attrs["created"].(string)
+		// Pagination: follow links.next with SSRF validation, falling back
+		// to a starting_after cursor when only meta says more data remains
+		var lastID string
+		if n := len(envelope.Data); n > 0 {
+			lastID = envelope.Data[n-1].ID
+		}
+		currentURL := nextURL
+		nextURL = nextPageURL(baseURL, apiHost, currentURL, envelope.Links, envelope.Meta, lastID, len(projects)+len(envelope.Data))
+		if nextURL != "" && pages >= maxPages {
+			warnTruncated(ctx, "projects", orgID)
+			nextURL = ""
+		}
 
-			// Extract branch: prefer targetReference, fall back to branch
-			targetRef, _ := attrs["targetReference"].(string)
-			if targetRef == "" {
-				targetRef, _ = attrs["target_reference"].(string)
-			}
-			branch, _ := attrs["branch"].(string)
-			if branch == "" {
-				branch = targetRef
-			}
+		var prefetch chan projectsPage
+		if nextURL != "" {
+			prefetch = make(chan projectsPage, 1)
+			go func(u string) { prefetch <- fetchProjectsPage(ctx, client, token, u) }(nextURL)
+		}
 
-			// Extract target ID from relationships
-			var targetID string
-			if rels := p.Relationships; rels != nil {
-				if targetRel, ok := rels["target"].(map[string]interface{}); ok {
-					if targetData, ok := targetRel["data"].(map[string]interface{}); ok {
-						targetID, _ = targetData["id"].(string)
-					}
-				}
-			}
+		decoded, err := decodeProjects(page.body)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, decoded...)
 
-			projects = append(projects, Project{
-				ID:              p.ID,
-				Name:            name,
-				Origin:          origin,
-				Branch:          branch,
-				TargetReference: targetRef,
-				Created:         created,
-				TargetID:        targetID,
-			})
+		if prefetch == nil {
+			return projects, nil
 		}
+		page = <-prefetch
+	}
+}
 
-		// Pagination: follow links.next with SSRF validation
-		nextURL = ""
-		if result.Links != nil {
-			if nextRaw, ok := result.Links["next"]; ok {
-				if nextStr, ok := nextRaw.(string); ok && nextStr != "" {
-					if isAllowedNextURL(nextStr, apiHost) {
-						if strings.HasPrefix(nextStr, "/") {
-							nextURL = baseURL + nextStr
-						} else {
-							nextURL = nextStr
-						}
+// projectsPage is one fetched page of the projects list.
+type projectsPage struct {
+	status int
+	body   []byte
+	err    error
+}
+
+// fetchProjectsPage requests one page of the projects list.
+func fetchProjectsPage(ctx context.Context, client *http.Client, token, pageURL string) projectsPage {
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return projectsPage{err: fmt.Errorf("create request: %w", err)}
+	}
+	setAuth(req, token)
+	req.Header.Set("Accept", RESTAccept())
+
+	resp, body, err := DoWithRetry(ctx, client, req)
+	if err != nil {
+		return projectsPage{err: fmt.Errorf("fetch projects: %w", err)}
+	}
+	return projectsPage{status: resp.StatusCode, body: body}
+}
+
+// decodeProjects decodes the projects in one page of the projects list.
+func decodeProjects(body []byte) ([]Project, error) {
+	var result struct {
+		Data []struct {
+			ID            string                 `json:"id"`
+			Attributes    map[string]interface{} `json:"attributes"`
+			Relationships map[string]interface{} `json:"relationships"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("decode projects: %w", err)
+	}
+
+	projects := make([]Project, 0, len(result.Data))
+	for _, p := range result.Data {
+		attrs := p.Attributes
+		name, _ := attrs["name"].(string)
+		origin, _ := attrs["origin"].(string)
+		created, _ := attrs["created"].(string)
+		status, _ := attrs["status"].(string)
+
+		// Extract branch: prefer targetReference, fall back to branch
+		targetRef, _ := attrs["targetReference"].(string)
+		if targetRef == "" {
+			targetRef, _ = attrs["target_reference"].(string)
+		}
+		branch, _ := attrs["branch"].(string)
+		if branch == "" {
+			branch = targetRef
+		}
+
+		// Extract target ID, and the repo URL when the target is expanded,
+		// from relationships
+		var targetID, repoURL string
+		if rels := p.Relationships; rels != nil {
+			if targetRel, ok := rels["target"].(map[string]interface{}); ok {
+				if targetData, ok := targetRel["data"].(map[string]interface{}); ok {
+					targetID, _ = targetData["id"].(string)
+					if targetAttrs, ok := targetData["attributes"].(map[string]interface{}); ok {
+						repoURL, _ = targetAttrs["url"].(string)
 					}
 				}
 			}
 		}
-	}
+		if repoURL == "" {
+			repoURL, _ = attrs["remoteRepoUrl"].(string)
+		}
+		if repoURL == "" {
+			repoURL, _ = attrs["remote_repo_url"].(string)
+		}
 
+		projects = append(projects, Project{
+			ID:              p.ID,
+			Name:            name,
+			Origin:          origin,
+			Branch:          branch,
+			TargetReference: targetRef,
+			Created:         created,
+			TargetID:        targetID,
+			Status:          status,
+			RepoURL:         repoURL,
+		})
+	}
 	return projects, nil
 }
 
@@ -229,8 +505,8 @@ type APITarget struct {
 // targets left behind after project deletion.
 func FetchTargets(ctx context.Context, client *http.Client, token, orgID string) ([]APITarget, error) {
 	baseURL := GetSnykAPIBaseURL()
-	firstURL := fmt.Sprintf("%s/rest/orgs/%s/targets?version=2025-09-28&limit=100&exclude_empty=false",
-		baseURL, url.PathEscape(orgID))
+	firstURL := fmt.Sprintf("%s/orgs/%s/targets?version=%s&limit=100&exclude_empty=false",
+		RESTBaseURL(), url.PathEscape(orgID), RESTVersion())
 	var targets []APITarget
 	nextURL := firstURL
 
@@ -239,13 +515,13 @@ func FetchTargets(ctx context.Context, client *http.Client, token, orgID string)
 		apiHost = parsed.Host
 	}
 
-	for nextURL != "" {
+	for pages := 1; nextURL != ""; pages++ {
 		req, err := http.NewRequestWithContext(ctx, "GET", nextURL, nil)
 		if err != nil {
 			return nil, fmt.Errorf("create request: %w", err)
 		}
-		req.Header.Set("Authorization", "token "+token)
-		req.Header.Set("Accept", "application/vnd.api+json")
+		setAuth(req, token)
+		req.Header.Set("Accept", RESTAccept())
 
 		resp, body, err := DoWithRetry(ctx, client, req)
 		if err != nil {
@@ -255,7 +531,7 @@ func FetchTargets(ctx context.Context, client *http.Client, token, orgID string)
 			return targets, nil
 		}
 		if resp.StatusCode != 200 {
-			return nil, fmt.Errorf("fetch targets: status %d, body: %s", resp.StatusCode, string(body))
+			return nil, &StatusError{Op: "fetch targets", StatusCode: resp.StatusCode, Body: string(body)}
 		}
 
 		var result struct {
@@ -265,6 +541,7 @@ func FetchTargets(ctx context.Context, client *http.Client, token, orgID string)
 				Relationships map[string]interface{} `json:"relationships"`
 			} `json:"data"`
 			Links map[string]interface{} `json:"links"`
+			Meta  map[string]interface{} `json:"meta"`
 		}
 		if err := json.Unmarshal(body, &result); err != nil {
 			return nil, fmt.Errorf("decode targets: %w", err)
@@ -296,74 +573,263 @@ func FetchTargets(ctx context.Context, client *http.Client, token, orgID string)
 			})
 		}
 
-		nextURL = ""
-		if result.Links != nil {
-			if nextRaw, ok := result.Links["next"]; ok {
-				if nextStr, ok := nextRaw.(string); ok && nextStr != "" {
-					if isAllowedNextURL(nextStr, apiHost) {
-						if strings.HasPrefix(nextStr, "/") {
-							nextURL = baseURL + nextStr
-						} else {
-							nextURL = nextStr
-						}
-					}
-				}
-			}
+		var lastID string
+		if n := len(result.Data); n > 0 {
+			lastID = result.Data[n-1].ID
+		}
+		nextURL = nextPageURL(baseURL, apiHost, nextURL, result.Links, result.Meta, lastID, len(targets))
+		if nextURL != "" && pages >= maxPages {
+			warnTruncated(ctx, "targets", orgID)
+			nextURL = ""
 		}
 	}
 
 	return targets, nil
 }
 
+// Deletes can return 409 Conflict while a related deletion is still
+// propagating on the backend; these settings bound the extra retries.
+var (
+	conflictMaxRetries = 3
+	conflictBackoff    = 2 * time.Second
+)
+
+// doDeleteWithConflictRetry runs a DELETE through DoWithRetry and, if the
+// response is 409 Conflict, retries up to conflictMaxRetries times with a
+// linearly growing pause. This is separate from the 429/5xx handling in
+// DoWithRetry because a conflict is not a rate or server problem. retried
+// reports whether an earlier DELETE may have reached the backend: it was lost
+// to a transport error, a timeout, or a server error such as a 504, or it got
+// a 409. Resends after a 429, or a 503 with Retry-After, do not count, since
+// those responses mean nothing was deleted.
+func doDeleteWithConflictRetry(ctx context.Context, client *http.Client, req *http.Request) (resp *http.Response, body []byte, retried bool, err error) {
+	for attempt := 0; ; attempt++ {
+		var lost bool
+		resp, body, lost, err = doWithRetry(ctx, client, req)
+		retried = retried || attempt > 0 || lost
+		if err != nil || resp.StatusCode != 409 {
+			if err == nil && attempt > 0 {
+				log.Printf("[INFO] Conflict (409) on %s cleared after %d retr(ies)", req.URL.Path, attempt)
+			}
+			return resp, body, retried, err
+		}
+		if attempt >= conflictMaxRetries {
+			return resp, body, retried, nil
+		}
+		wait := conflictBackoff * time.Duration(attempt+1)
+		log.Printf("[INFO] %s", backoffMessage(ctx, "Conflict (409) on "+req.URL.Path, wait, attempt+1, conflictMaxRetries))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, nil, retried, ctx.Err()
+		}
+	}
+}
+
+// checkDeleteResponse returns nil if a DELETE succeeded. DELETE is idempotent,
+// so a 404 when retried is set also counts as success: an earlier attempt
+// whose response was lost (e.g. to a timeout) most likely removed the resource.
+func checkDeleteResponse(what, path string, resp *http.Response, body []byte, retried bool) error {
+	switch {
+	case resp.StatusCode == 204 || resp.StatusCode == 200:
+		return nil
+	case resp.StatusCode == 404 && retried:
+		log.Printf("[INFO] %s: %s returned 404 after a retry; treating it as already deleted", what, path)
+		return nil
+	}
+	return &StatusError{Op: what, StatusCode: resp.StatusCode, Body: string(body)}
+}
+
 // DeleteProject deletes a single project from a Snyk org via the REST API.
 func DeleteProject(ctx context.Context, client *http.Client, token, orgID, projectID string) error {
-	baseURL := GetSnykAPIBaseURL()
-	apiURL := fmt.Sprintf("%s/rest/orgs/%s/projects/%s?version=2025-09-28",
-		baseURL, url.PathEscape(orgID), url.PathEscape(projectID))
+	apiURL := fmt.Sprintf("%s/orgs/%s/projects/%s?version=%s",
+		RESTBaseURL(), url.PathEscape(orgID), url.PathEscape(projectID), RESTVersion())
 
 	req, err := http.NewRequestWithContext(ctx, "DELETE", apiURL, nil)
 	if err != nil {
 		return fmt.Errorf("create request: %w", err)
 	}
-	req.Header.Set("Authorization", "token "+token)
-	req.Header.Set("Accept", "application/vnd.api+json")
+	setAuth(req, token)
+	req.Header.Set("Accept", RESTAccept())
 
-	resp, body, err := DoWithRetry(ctx, client, req)
+	resp, body, retried, err := doDeleteWithConflictRetry(ctx, client, req)
 	if err != nil {
 		return fmt.Errorf("delete project: %w", err)
 	}
 	// 204 No Content is the expected success response
-	if resp.StatusCode != 204 && resp.StatusCode != 200 {
-		return fmt.Errorf("delete project: status %d, body: %s", resp.StatusCode, string(body))
-	}
-	return nil
+	return checkDeleteResponse("delete project", req.URL.Path, resp, body, retried)
 }
 
 // DeleteTarget deletes a target from a Snyk org via the REST API.
 // This removes the repository-level entry. It will fail if the target
 // still has projects attached.
 func DeleteTarget(ctx context.Context, client *http.Client, token, orgID, targetID string) error {
-	baseURL := GetSnykAPIBaseURL()
-	apiURL := fmt.Sprintf("%s/rest/orgs/%s/targets/%s?version=2025-09-28",
-		baseURL, url.PathEscape(orgID), url.PathEscape(targetID))
+	apiURL := fmt.Sprintf("%s/orgs/%s/targets/%s?version=%s",
+		RESTBaseURL(), url.PathEscape(orgID), url.PathEscape(targetID), RESTVersion())
 
 	req, err := http.NewRequestWithContext(ctx, "DELETE", apiURL, nil)
 	if err != nil {
 		return fmt.Errorf("create request: %w", err)
 	}
-	req.Header.Set("Authorization", "token "+token)
-	req.Header.Set("Accept", "application/vnd.api+json")
+	setAuth(req, token)
+	req.Header.Set("Accept", RESTAccept())
 
-	resp, body, err := DoWithRetry(ctx, client, req)
+	resp, body, retried, err := doDeleteWithConflictRetry(ctx, client, req)
 	if err != nil {
 		return fmt.Errorf("delete target: %w", err)
 	}
-	if resp.StatusCode != 204 && resp.StatusCode != 200 {
-		return fmt.Errorf("delete target: status %d, body: %s", resp.StatusCode, string(body))
+	return checkDeleteResponse("delete target", req.URL.Path, resp, body, retried)
+}
+
+// User is the authenticated Snyk user behind the API token.
+type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// FetchUser returns the user that owns the API token via /v1/user/me.
+// It is a cheap call used to confirm the token and region are correct.
+func FetchUser(ctx context.Context, client *http.Client, token string) (User, error) {
+	apiURL := fmt.Sprintf("%s/user/me", V1BaseURL())
+
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return User{}, fmt.Errorf("create request: %w", err)
+	}
+	setAuth(req, token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, body, err := DoWithRetry(ctx, client, req)
+	if err != nil {
+		return User{}, fmt.Errorf("fetch user: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		return User{}, &StatusError{Op: "fetch user", StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var user User
+	if err := json.Unmarshal(body, &user); err != nil {
+		return User{}, fmt.Errorf("decode user: %w", err)
+	}
+	return user, nil
+}
+
+// CheckGroupAccess confirms the token can list orgs in a group by
+// requesting a single-entry page.
+func CheckGroupAccess(ctx context.Context, client *http.Client, token, groupID string) error {
+	apiURL := fmt.Sprintf("%s/group/%s/orgs?perPage=1&page=1", V1BaseURL(), url.PathEscape(groupID))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	setAuth(req, token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, body, err := DoWithRetry(ctx, client, req)
+	if err != nil {
+		return fmt.Errorf("check group access: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		return &StatusError{Op: "check group access", StatusCode: resp.StatusCode, Body: string(body)}
 	}
 	return nil
 }
 
+// CheckOrgAccess confirms the token can read a single org via the REST API.
+func CheckOrgAccess(ctx context.Context, client *http.Client, token, orgID string) error {
+	apiURL := fmt.Sprintf("%s/orgs/%s?version=%s", RESTBaseURL(), url.PathEscape(orgID), RESTVersion())
+
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	setAuth(req, token)
+	req.Header.Set("Accept", RESTAccept())
+
+	resp, body, err := DoWithRetry(ctx, client, req)
+	if err != nil {
+		return fmt.Errorf("check org access: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		return &StatusError{Op: "check org access", StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return nil
+}
+
+// DefaultMaxPages is the default cap on pages FetchProjects and FetchTargets
+// follow for one org. At 100 items a page it only stops absurdly large orgs
+// and pagination that never ends.
+const DefaultMaxPages = 1000
+
+// maxPages is the per-fetch page cap (--max-pages).
+var maxPages = DefaultMaxPages
+
+// SetMaxPages sets how many pages one FetchProjects or FetchTargets call
+// follows before it stops and returns what it has. n must be positive.
+func SetMaxPages(n int) error {
+	if n < 1 {
+		return fmt.Errorf("max pages must be positive, got %d", n)
+	}
+	maxPages = n
+	return nil
+}
+
+// warnTruncated logs that a fetch of what for orgID stopped at maxPages with
+// more pages left, so its results are incomplete.
+func warnTruncated(ctx context.Context, what, orgID string) {
+	who := requestLabel(ctx)
+	if who == "" {
+		who = "org " + orgID
+	}
+	log.Printf("WARNING: %s: stopped fetching %s after --max-pages=%d pages; results for this org are TRUNCATED", who, what, maxPages)
+}
+
+// nextPageURL returns the URL of the page after currentURL, or "" when there
+// are no more pages. links.next is used when present and allowed. Otherwise,
+// if meta indicates more data (has_more, or a count above the number of items
+// fetched so far), the next page is requested with lastID as the
+// starting_after cursor. The fallback stops if the cursor would not advance.
+func nextPageURL(baseURL, apiHost, currentURL string, links, meta map[string]interface{}, lastID string, fetched int) string {
+	if nextStr, _ := links["next"].(string); nextStr != "" {
+		if !isAllowedNextURL(nextStr, apiHost) {
+			return ""
+		}
+		if strings.HasPrefix(nextStr, "/") {
+			return baseURL + nextStr
+		}
+		return nextStr
+	}
+	if lastID == "" || !metaHasMore(meta, fetched) {
+		return ""
+	}
+	u, err := url.Parse(currentURL)
+	if err != nil {
+		return ""
+	}
+	q := u.Query()
+	if q.Get("starting_after") == lastID {
+		return ""
+	}
+	q.Set("starting_after", lastID)
+	q.Del("ending_before")
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// metaHasMore reports whether a REST response's meta object says more data
+// remains beyond the fetched items.
+func metaHasMore(meta map[string]interface{}, fetched int) bool {
+	if more, ok := meta["has_more"].(bool); ok {
+		return more
+	}
+	if count, ok := meta["count"].(float64); ok {
+		return int(count) > fetched
+	}
+	return false
+}
+
 // isAllowedNextURL validates a pagination URL to prevent SSRF.
 // Allows relative URLs (starting with /) and absolute URLs on the same host.
 func isAllowedNextURL(nextURL, allowedHost string) bool {
@@ -1,6 +1,16 @@
 package internal
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
 
 func TestIsAllowedNextURL(t *testing.T) {
 	tests := []struct {
@@ -46,3 +56,396 @@ func TestIsAllowedNextURL(t *testing.T) {
 		})
 	}
 }
+
+func TestNextPageURL(t *testing.T) {
+	const base = "https://api.snyk.io"
+	const cur = base + "/rest/orgs/o/projects?limit=100&version=2025-09-28"
+	tests := []struct {
+		name   string
+		cur    string
+		links  map[string]interface{}
+		meta   map[string]interface{}
+		lastID string
+		want   string
+	}{
+		{"links.next relative", cur, map[string]interface{}{"next": "/rest/orgs/o/projects?page=2"}, nil, "p1", base + "/rest/orgs/o/projects?page=2"},
+		{"links.next disallowed host", cur, map[string]interface{}{"next": "https://evil.com/x"}, map[string]interface{}{"has_more": true}, "p1", ""},
+		{"no links, no meta", cur, nil, nil, "p1", ""},
+		{"has_more cursor", cur, nil, map[string]interface{}{"has_more": true}, "p1", base + "/rest/orgs/o/projects?limit=100&starting_after=p1&version=2025-09-28"},
+		{"has_more false", cur, nil, map[string]interface{}{"has_more": false}, "p1", ""},
+		{"count above fetched", cur, nil, map[string]interface{}{"count": float64(150)}, "p1", base + "/rest/orgs/o/projects?limit=100&starting_after=p1&version=2025-09-28"},
+		{"count reached", cur, nil, map[string]interface{}{"count": float64(100)}, "p1", ""},
+		{"empty page", cur, nil, map[string]interface{}{"has_more": true}, "", ""},
+		{"cursor not advancing", cur + "&starting_after=p1", nil, map[string]interface{}{"has_more": true}, "p1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextPageURL(base, "api.snyk.io", tt.cur, tt.links, tt.meta, tt.lastID, 100); got != tt.want {
+				t.Errorf("nextPageURL = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchProjects_StartingAfterFallback(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Query().Get("starting_after") == "p1" {
+			w.Write([]byte(`{"data":[{"id":"p2","attributes":{"name":"b"}}],"meta":{"has_more":false}}`))
+			return
+		}
+		w.Write([]byte(`{"data":[{"id":"p1","attributes":{"name":"a"}}],"meta":{"has_more":true}}`))
+	}))
+	defer srv.Close()
+	t.Setenv("SNYK_API", srv.URL)
+
+	projects, err := FetchProjects(context.Background(), srv.Client(), "tok", "org-1")
+	if err != nil {
+		t.Fatalf("FetchProjects: %v", err)
+	}
+	if len(projects) != 2 || projects[1].ID != "p2" {
+		t.Errorf("projects = %+v, want p1 then p2", projects)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("server calls = %d, want 2", got)
+	}
+}
+
+func TestFetchProjects_PipelinedPagesKeepOrder(t *testing.T) {
+	pages := map[string]string{
+		"":   `{"data":[{"id":"p1","attributes":{"name":"a"}},{"id":"p2","attributes":{"name":"b"}}],"links":{"next":"/rest/orgs/org-1/projects?version=2025-09-28&limit=100&starting_after=p2"}}`,
+		"p2": `{"data":[{"id":"p3","attributes":{"name":"c"}}],"links":{"next":"/rest/orgs/org-1/projects?version=2025-09-28&limit=100&starting_after=p3"}}`,
+		"p3": `{"data":[{"id":"p4","attributes":{"name":"d"}}],"links":{}}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(pages[r.URL.Query().Get("starting_after")]))
+	}))
+	defer srv.Close()
+	t.Setenv("SNYK_API", srv.URL)
+
+	projects, err := FetchProjects(context.Background(), srv.Client(), "tok", "org-1")
+	if err != nil {
+		t.Fatalf("FetchProjects: %v", err)
+	}
+	var ids []string
+	for _, p := range projects {
+		ids = append(ids, p.ID)
+	}
+	if strings.Join(ids, ",") != "p1,p2,p3,p4" {
+		t.Errorf("project order = %v, want p1,p2,p3,p4", ids)
+	}
+}
+
+func TestFetchProjects_MaxPages(t *testing.T) {
+	defer SetMaxPages(DefaultMaxPages)
+	if err := SetMaxPages(0); err == nil {
+		t.Error("SetMaxPages(0): want error")
+	}
+	if err := SetMaxPages(2); err != nil {
+		t.Fatal(err)
+	}
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		// Every page points at another, as a pagination loop would.
+		fmt.Fprintf(w, `{"data":[{"id":"p%d","attributes":{"name":"a"}}],"links":{"next":"/rest/orgs/org-1/projects?starting_after=p%d"}}`, n, n)
+	}))
+	defer srv.Close()
+	t.Setenv("SNYK_API", srv.URL)
+
+	projects, err := FetchProjects(context.Background(), srv.Client(), "tok", "org-1")
+	if err != nil {
+		t.Fatalf("FetchProjects: %v", err)
+	}
+	if len(projects) != 2 || calls.Load() != 2 {
+		t.Errorf("projects = %d, calls = %d; want 2 and 2", len(projects), calls.Load())
+	}
+}
+
+func TestFetchProjects_PrefetchErrorIsReturned(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("starting_after") == "" {
+			w.Write([]byte(`{"data":[{"id":"p1","attributes":{"name":"a"}}],"links":{"next":"/rest/orgs/org-1/projects?starting_after=p1"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+	t.Setenv("SNYK_API", srv.URL)
+
+	if _, err := FetchProjects(context.Background(), srv.Client(), "tok", "org-1"); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Errorf("FetchProjects = %v, want status 403 from the second page", err)
+	}
+}
+
+func TestFetchOrgsREST_FollowsLinks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rest/groups/g1/orgs" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("starting_after") == "" {
+			w.Write([]byte(`{"data":[{"id":"o1","attributes":{"name":"One","slug":"one"}}],"links":{"next":"/rest/groups/g1/orgs?version=2025-09-28&limit=100&starting_after=o1"}}`))
+			return
+		}
+		w.Write([]byte(`{"data":[{"id":"o2","attributes":{"name":"Two","slug":"two"}}],"links":{}}`))
+	}))
+	defer srv.Close()
+	t.Setenv("SNYK_API", srv.URL)
+
+	orgs, err := FetchOrgsREST(context.Background(), srv.Client(), "tok", "g1")
+	if err != nil {
+		t.Fatalf("FetchOrgsREST: %v", err)
+	}
+	if len(orgs) != 2 || orgs[0] != (Org{ID: "o1", Name: "One", Slug: "one"}) || orgs[1].ID != "o2" {
+		t.Errorf("orgs = %+v, want o1 and o2", orgs)
+	}
+}
+
+func TestIntegrationActive(t *testing.T) {
+	settings := map[string]string{
+		"ok":       `{"pullRequestTestEnabled":true}`,
+		"enabled":  `{"status":"Active"}`,
+		"disabled": `{"disabled":true}`,
+		"broken":   `{"status":"broken"}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/org/org-1/integrations/"), "/settings")
+		if id == "forbidden" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body, ok := settings[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	t.Setenv("SNYK_API", srv.URL)
+
+	for id, want := range map[string]bool{"ok": true, "enabled": true, "disabled": false, "broken": false, "gone": false} {
+		got, err := IntegrationActive(context.Background(), srv.Client(), "tok", "org-1", id)
+		if err != nil || got != want {
+			t.Errorf("IntegrationActive(%s) = %v, %v; want %v", id, got, err, want)
+		}
+	}
+	if _, err := IntegrationActive(context.Background(), srv.Client(), "tok", "org-1", "forbidden"); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Errorf("IntegrationActive(forbidden) error = %v, want status 403", err)
+	}
+}
+
+func TestFetchGroups(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rest/groups" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"data":[{"id":"g1","attributes":{"name":"Acme"}},{"id":"g2","attributes":{"name":"Labs"}}],"links":{}}`))
+	}))
+	defer srv.Close()
+	t.Setenv("SNYK_API", srv.URL)
+
+	groups, err := FetchGroups(context.Background(), srv.Client(), "tok")
+	if err != nil {
+		t.Fatalf("FetchGroups: %v", err)
+	}
+	if len(groups) != 2 || groups[0] != (Group{ID: "g1", Name: "Acme"}) || groups[1].ID != "g2" {
+		t.Errorf("groups = %+v, want g1 and g2", groups)
+	}
+}
+
+func TestFetchGroups_CustomTokenHeader(t *testing.T) {
+	if err := SetTokenHeader("X-Snyk-Token", "Bearer %s"); err != nil {
+		t.Fatalf("SetTokenHeader: %v", err)
+	}
+	defer SetTokenHeader("", "")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Snyk-Token") != "Bearer tok" || r.Header.Get("Authorization") != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"data":[],"links":{}}`))
+	}))
+	defer srv.Close()
+	t.Setenv("SNYK_API", srv.URL)
+
+	if _, err := FetchGroups(context.Background(), srv.Client(), "tok"); err != nil {
+		t.Errorf("FetchGroups with custom token header: %v", err)
+	}
+}
+
+func TestFetchUser_UnauthorizedIsErrUnauthorized(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	t.Setenv("SNYK_API", srv.URL)
+
+	if _, err := FetchUser(context.Background(), srv.Client(), "bad"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("FetchUser = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestDeleteProject_RetriesOnConflict(t *testing.T) {
+	saveMax, saveBackoff := conflictMaxRetries, conflictBackoff
+	conflictMaxRetries, conflictBackoff = 2, time.Millisecond
+	defer func() { conflictMaxRetries, conflictBackoff = saveMax, saveBackoff }()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	t.Setenv("SNYK_API", srv.URL)
+
+	if err := DeleteProject(context.Background(), srv.Client(), "tok", "org-1", "proj-1"); err != nil {
+		t.Fatalf("DeleteProject: %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("server calls = %d, want 2 (409 then 204)", got)
+	}
+}
+
+func TestDeleteTarget_ConflictRetriesCapped(t *testing.T) {
+	saveMax, saveBackoff := conflictMaxRetries, conflictBackoff
+	conflictMaxRetries, conflictBackoff = 1, time.Millisecond
+	defer func() { conflictMaxRetries, conflictBackoff = saveMax, saveBackoff }()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer srv.Close()
+	t.Setenv("SNYK_API", srv.URL)
+
+	if err := DeleteTarget(context.Background(), srv.Client(), "tok", "org-1", "t-1"); err == nil {
+		t.Fatal("DeleteTarget: want error after persistent 409")
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("server calls = %d, want 2 (initial + 1 retry)", got)
+	}
+}
+
+func TestDeleteProject_TimeoutThen404IsSuccess(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			// The delete "succeeds" but the client gives up before the response.
+			<-release
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	defer close(release)
+	t.Setenv("SNYK_API", srv.URL)
+
+	client := srv.Client()
+	client.Timeout = 100 * time.Millisecond
+	if err := DeleteProject(context.Background(), client, "tok", "org-1", "proj-1"); err != nil {
+		t.Fatalf("DeleteProject: %v, want 404 after a retry treated as deleted", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("server calls = %d, want 2 (timeout then 404)", got)
+	}
+}
+
+func TestDeleteTarget_404WithoutRetryIsError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	t.Setenv("SNYK_API", srv.URL)
+
+	err := DeleteTarget(context.Background(), srv.Client(), "tok", "org-1", "t-1")
+	if err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("DeleteTarget = %v, want a status 404 error on the first attempt", err)
+	}
+}
+
+func TestDeleteProject_GatewayTimeoutThen404IsSuccess(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			// The gateway gave up, but the backend may still have deleted it.
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	t.Setenv("SNYK_API", srv.URL)
+
+	if err := DeleteProject(context.Background(), srv.Client(), "tok", "org-1", "proj-1"); err != nil {
+		t.Fatalf("DeleteProject: %v, want 404 after a 504 treated as deleted", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("server calls = %d, want 2 (504 then 404)", got)
+	}
+}
+
+func TestDeleteProject_RateLimitedThen404IsError(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			// A 429 means the delete was not performed, so the 404 that
+			// follows is a real "not found", not a lost success.
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	t.Setenv("SNYK_API", srv.URL)
+
+	err := DeleteProject(context.Background(), srv.Client(), "tok", "org-1", "proj-1")
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusNotFound {
+		t.Fatalf("DeleteProject = %v, want a 404 StatusError after 429", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("server calls = %d, want 2 (429 then 404)", got)
+	}
+}
+
+func TestProjectIsImporting(t *testing.T) {
+	for status, want := range map[string]bool{
+		"importing": true, "PENDING": true, "in_progress": true,
+		"active": false, "inactive": false, "": false,
+	} {
+		if got := (Project{Status: status}).IsImporting(); got != want {
+			t.Errorf("IsImporting(%q) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestDecodeProjects_RepoURL(t *testing.T) {
+	body := `{"data":[
+		{"id":"p1","attributes":{"name":"owner/repo","origin":"github-enterprise"},"relationships":{"target":{"data":{"id":"t1","attributes":{"url":"https://ghe.example.com/owner/repo"}}}}},
+		{"id":"p2","attributes":{"name":"owner/repo","origin":"github-enterprise","remoteRepoUrl":"https://ghe2.example.com/owner/repo.git"}},
+		{"id":"p3","attributes":{"name":"owner/repo","origin":"github"}}
+	]}`
+	projects, err := decodeProjects([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"https://ghe.example.com/owner/repo", "https://ghe2.example.com/owner/repo.git", ""}
+	for i, p := range projects {
+		if p.RepoURL != want[i] {
+			t.Errorf("%s: RepoURL = %q, want %q", p.ID, p.RepoURL, want[i])
+		}
+	}
+	if projects[0].TargetID != "t1" {
+		t.Errorf("TargetID = %q, want t1", projects[0].TargetID)
+	}
+}
@@ -5,28 +5,296 @@ package internal
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// GetSnykAPIBaseURL returns the Snyk API base URL from environment or default.
-// Priority: SNYK_API > SNYK_API_URL > default (https://api.snyk.io)
+// baseURLOverride, when set via SetSnykAPIBaseURL, takes precedence over the environment.
+var baseURLOverride string
+
+// SetSnykAPIBaseURL overrides the API base URL for the rest of the process
+// (used by the --base-url flag, e.g. to point at a local test server).
+// It accepts http or https URLs; an empty string clears the override.
+func SetSnykAPIBaseURL(u string) error {
+	if u == "" {
+		baseURLOverride = ""
+		return nil
+	}
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return fmt.Errorf("invalid base URL %q: must be an http(s) URL", u)
+	}
+	baseURLOverride = trimAPIPath(u)
+	return nil
+}
+
+// GetSnykAPIBaseURL returns the Snyk API base URL from environment or default,
+// without a trailing /v1 or /rest (see trimAPIPath).
+// Priority: SetSnykAPIBaseURL (--base-url) > SNYK_API > SNYK_API_URL > default (https://api.snyk.io)
 func GetSnykAPIBaseURL() string {
+	if baseURLOverride != "" {
+		return baseURLOverride
+	}
 	if u := os.Getenv("SNYK_API"); u != "" {
-		return strings.TrimSuffix(u, "/")
+		return trimAPIPath(u)
 	}
 	if u := os.Getenv("SNYK_API_URL"); u != "" {
-		return strings.TrimSuffix(u, "/")
+		return trimAPIPath(u)
 	}
 	return "https://api.snyk.io"
 }
 
+// Default path prefixes of the REST and v1 APIs under the base URL.
+const (
+	DefaultRESTPathPrefix = "/rest"
+	DefaultV1PathPrefix   = "/v1"
+)
+
+// restPathPrefix and v1PathPrefix are set via SetAPIPathPrefix, for
+// on-prem and enterprise deployments that serve the APIs elsewhere.
+var (
+	restPathPrefix = DefaultRESTPathPrefix
+	v1PathPrefix   = DefaultV1PathPrefix
+)
+
+// pathPrefixPattern matches an absolute URL path made only of unreserved
+// characters, so a prefix can never carry a host, query, or escape.
+var pathPrefixPattern = regexp.MustCompile(`^(/[A-Za-z0-9._~-]+)+$`)
+
+// SetAPIPathPrefix sets the REST and v1 path prefixes from a spec of
+// comma-separated rest=/path and v1=/path pairs. An API not named keeps its
+// default; an empty spec restores both defaults.
+func SetAPIPathPrefix(spec string) error {
+	rest, v1 := DefaultRESTPathPrefix, DefaultV1PathPrefix
+	if spec != "" {
+		for _, pair := range strings.Split(spec, ",") {
+			key, prefix, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				return fmt.Errorf("invalid path prefix %q: want rest=/path or v1=/path", pair)
+			}
+			prefix = strings.TrimSuffix(prefix, "/")
+			if err := validatePathPrefix(prefix); err != nil {
+				return err
+			}
+			switch key {
+			case "rest":
+				rest = prefix
+			case "v1":
+				v1 = prefix
+			default:
+				return fmt.Errorf("invalid path prefix %q: the API must be rest or v1", pair)
+			}
+		}
+	}
+	restPathPrefix, v1PathPrefix = rest, v1
+	return nil
+}
+
+// validatePathPrefix checks that prefix is a plain absolute path with no
+// "." or ".." segments.
+func validatePathPrefix(prefix string) error {
+	if !pathPrefixPattern.MatchString(prefix) {
+		return fmt.Errorf("invalid path prefix %q: must be an absolute path of letters, digits, and . _ ~ -", prefix)
+	}
+	for _, seg := range strings.Split(prefix, "/") {
+		if seg == "." || seg == ".." {
+			return fmt.Errorf("invalid path prefix %q: must not contain . or .. segments", prefix)
+		}
+	}
+	return nil
+}
+
+// RESTBaseURL returns the base URL of the REST API: the API base URL plus
+// the REST path prefix.
+func RESTBaseURL() string {
+	return GetSnykAPIBaseURL() + restPathPrefix
+}
+
+// V1BaseURL returns the base URL of the v1 API.
+func V1BaseURL() string {
+	return GetSnykAPIBaseURL() + v1PathPrefix
+}
+
+// trimAPIPath strips a trailing default API path from a base URL, so a
+// SNYK_API written for other tools as https://api.snyk.io/v1 (or an
+// enterprise https://host/api/v1) still resolves each API correctly.
+func trimAPIPath(u string) string {
+	u = strings.TrimSuffix(u, "/")
+	for _, p := range []string{DefaultV1PathPrefix, DefaultRESTPathPrefix} {
+		if strings.HasSuffix(u, p) {
+			return strings.TrimSuffix(u, p)
+		}
+	}
+	return u
+}
+
+// DefaultRESTAccept is the Accept header sent on Snyk REST API calls.
+const DefaultRESTAccept = "application/vnd.api+json"
+
+// restAcceptOverride, when set via SetRESTAccept, replaces DefaultRESTAccept.
+var restAcceptOverride string
+
+// SetRESTAccept overrides the Accept header used by REST calls, as an escape
+// hatch if Snyk changes content negotiation. The value must parse as a JSON
+// media type (e.g. "application/vnd.api+json; version=2"); an empty string
+// restores the default.
+func SetRESTAccept(v string) error {
+	if v == "" {
+		restAcceptOverride = ""
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(v)
+	if err != nil {
+		return fmt.Errorf("invalid media type %q: %v", v, err)
+	}
+	if !strings.Contains(mediaType, "/") || !strings.HasSuffix(mediaType, "json") {
+		return fmt.Errorf("invalid media type %q: must be a JSON type such as %s", v, DefaultRESTAccept)
+	}
+	restAcceptOverride = v
+	return nil
+}
+
+// RESTAccept returns the Accept header for REST calls.
+func RESTAccept() string {
+	if restAcceptOverride != "" {
+		return restAcceptOverride
+	}
+	return DefaultRESTAccept
+}
+
+// Default header name and value format used to send the API token.
+const (
+	DefaultTokenHeader = "Authorization"
+	DefaultTokenFormat = "token %s"
+)
+
+// tokenHeader and tokenFormat are set via SetTokenHeader, for gateways in
+// front of the Snyk API that expect the token in a different header.
+var (
+	tokenHeader = DefaultTokenHeader
+	tokenFormat = DefaultTokenFormat
+)
+
+// headerNamePattern matches a valid HTTP header field name (an RFC 9110 token).
+var headerNamePattern = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+// SetTokenHeader sets the header that carries the API token and the format of
+// its value, which must contain exactly one %s (replaced by the token) and no
+// other verbs; "%%" is a literal percent sign. Empty values restore the
+// defaults.
+func SetTokenHeader(name, format string) error {
+	if name == "" {
+		name = DefaultTokenHeader
+	}
+	if format == "" {
+		format = DefaultTokenFormat
+	}
+	if !headerNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	rest := strings.ReplaceAll(format, "%%", "")
+	if strings.Count(rest, "%s") != 1 || strings.Count(rest, "%") != 1 {
+		return fmt.Errorf("token value format %q must contain exactly one %%s and no other verbs", format)
+	}
+	tokenHeader, tokenFormat = name, format
+	return nil
+}
+
+// setAuth sets the token header on req.
+func setAuth(req *http.Request, token string) {
+	req.Header.Set(tokenHeader, fmt.Sprintf(tokenFormat, token))
+}
+
+// DefaultRESTVersion is the REST API version requested by default.
+const DefaultRESTVersion = "2025-09-28"
+
+// restVersion is the REST API version sent as ?version= (--api-version).
+var restVersion = DefaultRESTVersion
+
+// restVersionPattern matches a Snyk REST version: a date, optionally with a
+// stability suffix such as "~beta".
+var restVersionPattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(~[a-z]+)?$`)
+
+// SetRESTVersion sets the REST API version used by all REST calls. An empty
+// string restores DefaultRESTVersion.
+func SetRESTVersion(v string) error {
+	if v == "" {
+		restVersion = DefaultRESTVersion
+		return nil
+	}
+	if !restVersionPattern.MatchString(v) {
+		return fmt.Errorf("invalid API version %q: want YYYY-MM-DD, optionally with a suffix like ~beta", v)
+	}
+	restVersion = v
+	return nil
+}
+
+// RESTVersion returns the REST API version query value.
+func RESTVersion() string {
+	return url.QueryEscape(restVersion)
+}
+
+// deprecationNotice collects Deprecation/Sunset signals seen on REST
+// responses so they can be reported once at the end of a run.
+var deprecationNotice struct {
+	sync.Mutex
+	seen   bool
+	sunset string // first Sunset header value, if any
+	stage  string // snyk-version-lifecycle-stage, if deprecated or sunset
+}
+
+// recordDeprecation notes a response's deprecation headers, if any.
+func recordDeprecation(h http.Header) {
+	dep, sunset := h.Get("Deprecation"), h.Get("Sunset")
+	stage := strings.ToLower(h.Get("Snyk-Version-Lifecycle-Stage"))
+	if dep == "" && sunset == "" && stage != "deprecated" && stage != "sunset" {
+		return
+	}
+	deprecationNotice.Lock()
+	defer deprecationNotice.Unlock()
+	deprecationNotice.seen = true
+	if deprecationNotice.sunset == "" {
+		deprecationNotice.sunset = sunset
+	}
+	if stage == "deprecated" || stage == "sunset" {
+		deprecationNotice.stage = stage
+	}
+}
+
+// DeprecationWarning returns a single warning if any response during the run
+// said the requested API version is deprecated or has a sunset date, or ""
+// otherwise.
+func DeprecationWarning() string {
+	deprecationNotice.Lock()
+	defer deprecationNotice.Unlock()
+	if !deprecationNotice.seen {
+		return ""
+	}
+	msg := fmt.Sprintf("WARNING: The Snyk API reported that REST API version %s is deprecated", restVersion)
+	if deprecationNotice.stage == "sunset" {
+		msg = fmt.Sprintf("WARNING: The Snyk API reported that REST API version %s is past its sunset", restVersion)
+	}
+	if deprecationNotice.sunset != "" {
+		msg += fmt.Sprintf(" (sunset: %s)", deprecationNotice.sunset)
+	}
+	return msg + ". Pass a newer --api-version (or upgrade this tool) before it stops working."
+}
+
 // GetSnykToken returns the Snyk API token from environment variables.
 func GetSnykToken() (string, error) {
 	if t := os.Getenv("SNYK_TOKEN"); t != "" {
@@ -38,11 +306,129 @@ func GetSnykToken() (string, error) {
 	return "", fmt.Errorf("SNYK_TOKEN or SNYK_API_TOKEN environment variable not set")
 }
 
-// NewHTTPClient returns an *http.Client with sensible defaults.
+// followRedirects controls whether clients from NewHTTPClient follow
+// redirects at all (--follow-redirects).
+var followRedirects = true
+
+// SetFollowRedirects enables or disables redirect following for clients
+// created by NewHTTPClient. When disabled, a 3xx response is returned to the
+// caller as-is and reported as an unexpected status.
+func SetFollowRedirects(follow bool) {
+	followRedirects = follow
+}
+
+// ErrUnauthorized is returned (wrapped) when the API rejects the token with 401.
+var ErrUnauthorized = errors.New("authentication failed (401): check your SNYK_TOKEN")
+
+// minTLSVersion is the lowest TLS version clients from NewHTTPClient will
+// negotiate (--min-tls).
+var minTLSVersion uint16 = tls.VersionTLS12
+
+// SetMinTLSVersion sets the minimum TLS version for clients created by
+// NewHTTPClient. It accepts "1.2" or "1.3". Cipher suites are left to Go's
+// defaults, which only offer secure suites for these versions.
+func SetMinTLSVersion(v string) error {
+	switch v {
+	case "1.2":
+		minTLSVersion = tls.VersionTLS12
+	case "1.3":
+		minTLSVersion = tls.VersionTLS13
+	default:
+		return fmt.Errorf("minimum TLS version must be 1.2 or 1.3, got %q", v)
+	}
+	return nil
+}
+
+// ConnPool holds the keep-alive connection pool settings applied to clients
+// from NewHTTPClient.
+type ConnPool struct {
+	MaxIdleConns        int           // idle connections kept across all hosts
+	MaxIdleConnsPerHost int           // idle connections kept per host
+	IdleConnTimeout     time.Duration // how long an idle connection is kept
+}
+
+// DefaultConnPool suits the default concurrency of about 10 calls to a
+// single API host. Go's own per-host default of 2 idle connections would
+// make most calls at that concurrency open a new connection.
+var DefaultConnPool = ConnPool{
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 16,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// connPool is the pool configuration used by NewHTTPClient.
+var connPool = DefaultConnPool
+
+// SetConnPool sets the connection pool settings for clients created by
+// NewHTTPClient. Counts must be at least 1 and the timeout positive.
+func SetConnPool(p ConnPool) error {
+	if p.MaxIdleConns < 1 || p.MaxIdleConnsPerHost < 1 {
+		return fmt.Errorf("idle connection limits must be at least 1, got %d total and %d per host", p.MaxIdleConns, p.MaxIdleConnsPerHost)
+	}
+	if p.IdleConnTimeout <= 0 {
+		return fmt.Errorf("idle connection timeout must be positive, got %v", p.IdleConnTimeout)
+	}
+	connPool = p
+	return nil
+}
+
+// DefaultHTTPTimeout bounds one HTTP attempt, from dialing to reading the
+// last byte of the body. It leaves room for large, slow pages of projects.
+const DefaultHTTPTimeout = 60 * time.Second
+
+// httpTimeout is the per-attempt timeout used by NewHTTPClient.
+var httpTimeout = DefaultHTTPTimeout
+
+// SetHTTPTimeout sets the per-attempt timeout for clients created by
+// NewHTTPClient. A timed-out attempt is retried like any other network
+// error. The timeout must be positive.
+func SetHTTPTimeout(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("HTTP timeout must be positive, got %v", d)
+	}
+	httpTimeout = d
+	return nil
+}
+
+// NewHTTPClient returns an *http.Client with sensible defaults. Redirects are
+// only followed to the host the request was originally sent to (see
+// checkRedirect), so a misconfigured gateway cannot bounce authenticated
+// requests off-host. Connections never negotiate below minTLSVersion, and
+// keep-alive pooling follows connPool. Each attempt is bounded by httpTimeout.
 func NewHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{MinVersion: minTLSVersion}
+	transport.MaxIdleConns = connPool.MaxIdleConns
+	transport.MaxIdleConnsPerHost = connPool.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = connPool.IdleConnTimeout
 	return &http.Client{
-		Timeout: 30 * time.Second,
+		Timeout:       httpTimeout,
+		Transport:     transport,
+		CheckRedirect: checkRedirect,
+	}
+}
+
+// maxRedirects matches net/http's default redirect limit.
+const maxRedirects = 10
+
+// checkRedirect allows a redirect only to the original request's host (or a
+// subdomain of it) over https, using the same rules as pagination links, or
+// to the exact original scheme and host (e.g. a local http test server).
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if !followRedirects {
+		return http.ErrUseLastResponse
+	}
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	orig := via[0].URL
+	if req.URL.Scheme == orig.Scheme && req.URL.Host == orig.Host {
+		return nil
 	}
+	if isAllowedNextURL(req.URL.String(), orig.Hostname()) {
+		return nil
+	}
+	return fmt.Errorf("refusing redirect from %s to %s: host not allowed", orig.Host, req.URL.Host)
 }
 
 // RetryConfig holds retry configuration.
@@ -73,6 +459,28 @@ func isRetryableStatus(code int) bool {
 	}
 }
 
+// dnsRetryBackoff is the wait before the first retry of a request whose host
+// did not resolve, growing by the same amount per attempt. DNS failures in CI
+// networks are usually brief, so this is shorter than the usual backoff.
+var dnsRetryBackoff = 500 * time.Millisecond
+
+// isDNSError reports whether err is a failure to resolve a host name.
+func isDNSError(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// isCertError reports whether err is a failure to verify a server's TLS
+// certificate, as when a corporate proxy intercepts TLS with its own CA.
+func isCertError(err error) bool {
+	var verifyErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	return errors.As(err, &verifyErr) || errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostname) || errors.As(err, &invalid)
+}
+
 // getRetryAfter extracts the Retry-After header value in seconds.
 func getRetryAfter(resp *http.Response) time.Duration {
 	if resp == nil {
@@ -106,15 +514,29 @@ type rateLimiter struct {
 	ticker *time.Ticker
 }
 
-var globalLimiter *rateLimiter
+// rateInterval is the minimum spacing between requests to one host: ~2
+// requests per second.
+const rateInterval = 500 * time.Millisecond
 
-func initRateLimiter() {
-	if globalLimiter == nil {
-		// ~2 requests per second (500ms between requests)
-		globalLimiter = &rateLimiter{
-			ticker: time.NewTicker(500 * time.Millisecond),
-		}
+// hostLimiters holds one rateLimiter per API host (region), so throttling
+// against one region does not slow requests to another.
+var (
+	hostLimitersMu sync.Mutex
+	hostLimiters   = make(map[string]*rateLimiter)
+)
+
+// limiterFor returns the rate limiter for host, creating it on first use.
+// Hosts are matched case-insensitively.
+func limiterFor(host string) *rateLimiter {
+	host = strings.ToLower(host)
+	hostLimitersMu.Lock()
+	defer hostLimitersMu.Unlock()
+	rl, ok := hostLimiters[host]
+	if !ok {
+		rl = &rateLimiter{ticker: time.NewTicker(rateInterval)}
+		hostLimiters[host] = rl
 	}
+	return rl
 }
 
 func (rl *rateLimiter) wait(ctx context.Context) error {
@@ -126,10 +548,90 @@ func (rl *rateLimiter) wait(ctx context.Context) error {
 	}
 }
 
+// retryCount counts retry attempts across all requests in the process.
+var retryCount atomic.Int64
+
+// RetryCount returns the number of retry attempts made so far by DoWithRetry.
+func RetryCount() int64 {
+	return retryCount.Load()
+}
+
+// throttleCount counts 429 responses across all requests in the process.
+var throttleCount atomic.Int64
+
+// ThrottleCount returns the number of 429 responses DoWithRetry has seen.
+func ThrottleCount() int64 {
+	return throttleCount.Load()
+}
+
+// requestLabelKey is the context key for the label set by WithRequestLabel.
+type requestLabelKey struct{}
+
+// WithRequestLabel returns a context whose requests are identified by label
+// (e.g. "org my-org") in DoWithRetry's retry and backoff log lines.
+func WithRequestLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, requestLabelKey{}, label)
+}
+
+// requestLabel returns the label set by WithRequestLabel, or "".
+func requestLabel(ctx context.Context) string {
+	label, _ := ctx.Value(requestLabelKey{}).(string)
+	return label
+}
+
+// retryCounterKey is the context key for the counter set by WithRetryCounter.
+type retryCounterKey struct{}
+
+// WithRetryCounter returns a context whose requests add their retry attempts
+// to n, in addition to the process-wide RetryCount.
+func WithRetryCounter(ctx context.Context, n *atomic.Int64) context.Context {
+	return context.WithValue(ctx, retryCounterKey{}, n)
+}
+
+// longBackoff is the wait above which backoff log lines note that the run is
+// waiting rather than hung.
+const longBackoff = 10 * time.Second
+
+// backoffMessage formats the log line for a retry that is about to wait.
+func backoffMessage(ctx context.Context, reason string, wait time.Duration, attempt, maxAttempts int) string {
+	msg := reason
+	if label := requestLabel(ctx); label != "" {
+		msg += " [" + label + "]"
+	}
+	msg += fmt.Sprintf(", backing off for %v (attempt %d/%d)", wait, attempt, maxAttempts)
+	if wait >= longBackoff {
+		msg += "; waiting, not hung"
+	}
+	return msg
+}
+
+// backoff logs that a request is backing off and sleeps for wait, or returns
+// early if ctx is cancelled.
+func backoff(ctx context.Context, reason string, wait time.Duration, attempt, maxAttempts int) {
+	log.Printf("[INFO] %s", backoffMessage(ctx, reason, wait, attempt, maxAttempts))
+	select {
+	case <-ctx.Done():
+	case <-time.After(wait):
+	}
+}
+
 // DoWithRetry performs an HTTP request with rate limiting and automatic retries.
-// It handles 429 (rate limit) and 5xx (server error) responses with exponential backoff.
+// It handles 429 (rate limit) and 5xx (server error) responses with exponential backoff,
+// and retries transport errors, including DNS failures, which back off for less.
+// TLS certificate errors are returned at once with a hint about the CA.
 func DoWithRetry(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, []byte, error) {
-	initRateLimiter()
+	resp, body, _, err := doWithRetry(ctx, client, req)
+	return resp, body, err
+}
+
+// doWithRetry is DoWithRetry that also reports whether an earlier attempt
+// was lost after the request may have reached the server (a transport error
+// other than DNS, a failed response read, or a timeout or server error such
+// as a gateway's 504), so the server may have acted on a request whose
+// outcome the caller never saw. Retries after a 429, or a 503 with
+// Retry-After, do not count: those say the request was not carried out.
+func doWithRetry(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, []byte, bool, error) {
+	limiter := limiterFor(req.URL.Host)
 	cfg := DefaultRetryConfig()
 
 	// Store original body for retries
@@ -138,7 +640,7 @@ func DoWithRetry(ctx context.Context, client *http.Client, req *http.Request) (*
 		var err error
 		bodyBytes, err = io.ReadAll(req.Body)
 		if err != nil {
-			return nil, nil, fmt.Errorf("read request body: %w", err)
+			return nil, nil, false, fmt.Errorf("read request body: %w", err)
 		}
 		req.Body.Close()
 	}
@@ -146,21 +648,28 @@ func DoWithRetry(ctx context.Context, client *http.Client, req *http.Request) (*
 	var lastErr error
 	var lastResp *http.Response
 	var lastBody []byte
+	var lost bool
 
 	for attempt := 0; attempt <= cfg.MaxRetries; attempt++ {
 		if ctx.Err() != nil {
-			return nil, nil, ctx.Err()
+			return nil, nil, lost, ctx.Err()
+		}
+		if attempt > 0 {
+			retryCount.Add(1)
+			if n, ok := ctx.Value(retryCounterKey{}).(*atomic.Int64); ok {
+				n.Add(1)
+			}
 		}
 
 		// Rate limit
-		if err := globalLimiter.wait(ctx); err != nil {
-			return nil, nil, fmt.Errorf("rate limiter: %w", err)
+		if err := limiter.wait(ctx); err != nil {
+			return nil, nil, lost, fmt.Errorf("rate limiter: %w", err)
 		}
 
 		// Clone request with fresh body
 		reqClone, err := http.NewRequestWithContext(ctx, req.Method, req.URL.String(), nil)
 		if err != nil {
-			return nil, nil, fmt.Errorf("clone request: %w", err)
+			return nil, nil, lost, fmt.Errorf("clone request: %w", err)
 		}
 		for k, v := range req.Header {
 			reqClone.Header[k] = v
@@ -171,11 +680,19 @@ func DoWithRetry(ctx context.Context, client *http.Client, req *http.Request) (*
 		}
 
 		resp, err := client.Do(reqClone)
+		if err != nil && isCertError(err) {
+			// Not retryable: the same certificate is presented every time.
+			return nil, nil, lost, fmt.Errorf("%w (the TLS certificate of %s could not be verified; if a proxy inspects TLS traffic, add its CA certificate to the system trust store, or set SSL_CERT_FILE to a PEM bundle that includes it)", err, req.URL.Hostname())
+		}
 		if err != nil {
 			lastErr = err
-			log.Printf("[DEBUG] Request failed (attempt %d/%d): %v", attempt+1, cfg.MaxRetries+1, err)
+			lost = lost || !isDNSError(err)
 			if attempt < cfg.MaxRetries {
-				time.Sleep(calculateBackoff(attempt, cfg))
+				if isDNSError(err) {
+					backoff(ctx, fmt.Sprintf("DNS lookup failed (%v)", err), dnsRetryBackoff*time.Duration(attempt+1), attempt+1, cfg.MaxRetries+1)
+				} else {
+					backoff(ctx, fmt.Sprintf("Request failed (%v)", err), calculateBackoff(attempt, cfg), attempt+1, cfg.MaxRetries+1)
+				}
 			}
 			continue
 		}
@@ -184,56 +701,66 @@ func DoWithRetry(ctx context.Context, client *http.Client, req *http.Request) (*
 		resp.Body.Close()
 		if err != nil {
 			lastErr = fmt.Errorf("read response: %w", err)
+			lost = true
 			if attempt < cfg.MaxRetries {
-				time.Sleep(calculateBackoff(attempt, cfg))
+				backoff(ctx, fmt.Sprintf("Reading response failed (%v)", err), calculateBackoff(attempt, cfg), attempt+1, cfg.MaxRetries+1)
 			}
 			continue
 		}
 
+		// The host answered, so an earlier network error (e.g. a failed DNS
+		// lookup) no longer describes why the request is failing.
+		lastErr = nil
 		lastResp = resp
 		lastBody = body
+		recordDeprecation(resp.Header)
 
 		// 2xx success
 		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-			return resp, body, nil
+			return resp, body, lost, nil
 		}
 
 		// 401 -- not retryable, fail fast
 		if resp.StatusCode == 401 {
-			return resp, body, fmt.Errorf("authentication failed (401): check your SNYK_TOKEN")
+			return resp, body, lost, ErrUnauthorized
 		}
 
 		// 429 rate limit
 		if resp.StatusCode == 429 {
+			throttleCount.Add(1)
 			retryAfter := getRetryAfter(resp)
 			if retryAfter == 0 {
 				retryAfter = calculateBackoff(attempt, cfg)
 			}
-			log.Printf("[INFO] Rate limited (429), waiting %v (attempt %d/%d)", retryAfter, attempt+1, cfg.MaxRetries+1)
 			if attempt < cfg.MaxRetries {
-				time.Sleep(retryAfter)
+				backoff(ctx, "Rate limited (429)", retryAfter, attempt+1, cfg.MaxRetries+1)
 			}
 			continue
 		}
 
 		// Retryable server errors
 		if isRetryableStatus(resp.StatusCode) {
-			log.Printf("[INFO] Server error (%d), retrying (attempt %d/%d)", resp.StatusCode, attempt+1, cfg.MaxRetries+1)
+			if resp.StatusCode != 503 || resp.Header.Get("Retry-After") == "" {
+				lost = true
+			}
 			if attempt < cfg.MaxRetries {
-				time.Sleep(calculateBackoff(attempt, cfg))
+				backoff(ctx, fmt.Sprintf("Server error (%d)", resp.StatusCode), calculateBackoff(attempt, cfg), attempt+1, cfg.MaxRetries+1)
 			}
 			continue
 		}
 
 		// Non-retryable error -- return as-is for caller to handle
-		return resp, body, nil
+		return resp, body, lost, nil
 	}
 
+	if lastErr != nil && isDNSError(lastErr) {
+		return lastResp, lastBody, lost, fmt.Errorf("max retries exceeded: %w (could not resolve %s; check the network's DNS and any proxy settings such as HTTPS_PROXY)", lastErr, req.URL.Hostname())
+	}
 	if lastErr != nil {
-		return lastResp, lastBody, fmt.Errorf("max retries exceeded: %w", lastErr)
+		return lastResp, lastBody, lost, fmt.Errorf("max retries exceeded: %w", lastErr)
 	}
 	if lastResp != nil {
-		return lastResp, lastBody, fmt.Errorf("max retries exceeded: status %d", lastResp.StatusCode)
+		return lastResp, lastBody, lost, fmt.Errorf("max retries exceeded: status %d", lastResp.StatusCode)
 	}
-	return nil, nil, fmt.Errorf("max retries exceeded")
+	return nil, nil, lost, fmt.Errorf("max retries exceeded")
 }
@@ -1,8 +1,15 @@
 package internal
 
 import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -153,3 +160,430 @@ func TestGetSnykToken(t *testing.T) {
 		t.Errorf("got %q, %v", tok, err)
 	}
 }
+
+func TestSetSnykAPIBaseURL(t *testing.T) {
+	defer SetSnykAPIBaseURL("")
+	t.Setenv("SNYK_API", "https://api.eu.snyk.io")
+
+	if err := SetSnykAPIBaseURL("http://127.0.0.1:8080/"); err != nil {
+		t.Fatalf("SetSnykAPIBaseURL: %v", err)
+	}
+	if u := GetSnykAPIBaseURL(); u != "http://127.0.0.1:8080" {
+		t.Errorf("override: got %q (should win over SNYK_API, trailing slash trimmed)", u)
+	}
+	if err := SetSnykAPIBaseURL("ftp://example.com"); err == nil {
+		t.Error("ftp URL: want error")
+	}
+	if err := SetSnykAPIBaseURL(""); err != nil {
+		t.Fatal(err)
+	}
+	if u := GetSnykAPIBaseURL(); u != "https://api.eu.snyk.io" {
+		t.Errorf("cleared override: got %q, want SNYK_API value", u)
+	}
+}
+
+func TestSetAPIPathPrefix(t *testing.T) {
+	defer SetAPIPathPrefix("")
+	t.Setenv("SNYK_API", "https://snyk.example.com/api/v1")
+
+	if u := V1BaseURL(); u != "https://snyk.example.com/api/v1" {
+		t.Errorf("SNYK_API ending in /v1: V1BaseURL() = %q", u)
+	}
+	if u := RESTBaseURL(); u != "https://snyk.example.com/api/rest" {
+		t.Errorf("SNYK_API ending in /v1: RESTBaseURL() = %q", u)
+	}
+
+	if err := SetAPIPathPrefix("rest=/snyk/rest-api/, v1=/snyk/v1"); err != nil {
+		t.Fatalf("SetAPIPathPrefix: %v", err)
+	}
+	if u := RESTBaseURL(); u != "https://snyk.example.com/api/snyk/rest-api" {
+		t.Errorf("RESTBaseURL() = %q", u)
+	}
+	if u := V1BaseURL(); u != "https://snyk.example.com/api/snyk/v1" {
+		t.Errorf("V1BaseURL() = %q", u)
+	}
+	for _, bad := range []string{"/rest", "rest=rest", "rest=/a/../b", "rest=//evil.example.com", "v1=/v1?x=1", "rest=/a%2f", "v2=/v2", "rest=http://evil.example.com"} {
+		if err := SetAPIPathPrefix(bad); err == nil {
+			t.Errorf("SetAPIPathPrefix(%q): want error", bad)
+		}
+	}
+	if err := SetAPIPathPrefix("v1=/legacy"); err != nil {
+		t.Fatal(err)
+	}
+	if u := RESTBaseURL(); u != "https://snyk.example.com/api/rest" {
+		t.Errorf("rest not named: RESTBaseURL() = %q, want the default prefix", u)
+	}
+}
+
+func TestBackoffMessage(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		wait time.Duration
+		want string
+	}{
+		{"no label", ctx, 2 * time.Second, "Rate limited (429), backing off for 2s (attempt 1/4)"},
+		{"with label", WithRequestLabel(ctx, "org my-org"), 2 * time.Second, "Rate limited (429) [org my-org], backing off for 2s (attempt 1/4)"},
+		{"long wait", WithRequestLabel(ctx, "org my-org"), 30 * time.Second, "Rate limited (429) [org my-org], backing off for 30s (attempt 1/4); waiting, not hung"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := backoffMessage(tt.ctx, "Rate limited (429)", tt.wait, 1, 4); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetRESTAccept(t *testing.T) {
+	defer SetRESTAccept("")
+
+	if got := RESTAccept(); got != DefaultRESTAccept {
+		t.Errorf("default: got %q, want %q", got, DefaultRESTAccept)
+	}
+	if err := SetRESTAccept("application/vnd.api+json; version=2"); err != nil {
+		t.Fatalf("SetRESTAccept: %v", err)
+	}
+	if got := RESTAccept(); got != "application/vnd.api+json; version=2" {
+		t.Errorf("override: got %q", got)
+	}
+	for _, bad := range []string{"text/html", "json", "application/", "application/json;;="} {
+		if err := SetRESTAccept(bad); err == nil {
+			t.Errorf("SetRESTAccept(%q): want error", bad)
+		}
+	}
+	if err := SetRESTAccept(""); err != nil || RESTAccept() != DefaultRESTAccept {
+		t.Errorf("clearing override: err=%v accept=%q", err, RESTAccept())
+	}
+}
+
+func TestSetTokenHeader(t *testing.T) {
+	defer SetTokenHeader("", "")
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.invalid", nil)
+	setAuth(req, "abc")
+	if got := req.Header.Get("Authorization"); got != "token abc" {
+		t.Errorf("default header = %q, want %q", got, "token abc")
+	}
+
+	if err := SetTokenHeader("X-Api-Key", "%s"); err != nil {
+		t.Fatalf("SetTokenHeader: %v", err)
+	}
+	req, _ = http.NewRequest(http.MethodGet, "http://example.invalid", nil)
+	setAuth(req, "abc")
+	if got := req.Header.Get("X-Api-Key"); got != "abc" {
+		t.Errorf("custom header = %q, want %q", got, "abc")
+	}
+	if err := SetTokenHeader("X-Api-Key", "100%% %s"); err != nil {
+		t.Errorf("literal percent: %v", err)
+	}
+
+	for _, bad := range []struct{ name, format string }{
+		{"X Api Key", "%s"},
+		{"X-Api-Key", "token"},
+		{"X-Api-Key", "%s:%s"},
+		{"X-Api-Key", "%d %s"},
+		{"X-Api-Key", "%v"},
+	} {
+		if err := SetTokenHeader(bad.name, bad.format); err == nil {
+			t.Errorf("SetTokenHeader(%q, %q): want error", bad.name, bad.format)
+		}
+	}
+}
+
+func TestSetRESTVersion(t *testing.T) {
+	defer SetRESTVersion("")
+
+	if got := RESTVersion(); got != DefaultRESTVersion {
+		t.Errorf("default: got %q, want %q", got, DefaultRESTVersion)
+	}
+	for _, good := range []string{"2024-10-15", "2025-11-05~beta"} {
+		if err := SetRESTVersion(good); err != nil {
+			t.Errorf("SetRESTVersion(%q): %v", good, err)
+		} else if got := RESTVersion(); got != good {
+			t.Errorf("SetRESTVersion(%q): RESTVersion() = %q", good, got)
+		}
+	}
+	for _, bad := range []string{"2024", "latest", "2024-10-15&limit=1", "2024-10-15~"} {
+		if err := SetRESTVersion(bad); err == nil {
+			t.Errorf("SetRESTVersion(%q): want error", bad)
+		}
+	}
+	if err := SetRESTVersion(""); err != nil || RESTVersion() != DefaultRESTVersion {
+		t.Errorf("clearing override: err=%v version=%q", err, RESTVersion())
+	}
+}
+
+func TestDeprecationWarning(t *testing.T) {
+	reset := func() {
+		deprecationNotice.Lock()
+		deprecationNotice.seen, deprecationNotice.sunset, deprecationNotice.stage = false, "", ""
+		deprecationNotice.Unlock()
+	}
+	reset()
+	defer reset()
+
+	recordDeprecation(http.Header{})
+	if w := DeprecationWarning(); w != "" {
+		t.Fatalf("no headers: got warning %q", w)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "@1767225600")
+		w.Header().Set("Sunset", "Wed, 01 Jul 2026 00:00:00 GMT")
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	for range 2 {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		resp, _, err := DoWithRetry(context.Background(), srv.Client(), req)
+		if err != nil {
+			t.Fatalf("DoWithRetry: %v", err)
+		}
+		resp.Body.Close()
+	}
+	w := DeprecationWarning()
+	if !strings.Contains(w, DefaultRESTVersion) || !strings.Contains(w, "sunset: Wed, 01 Jul 2026") || !strings.Contains(w, "--api-version") {
+		t.Errorf("warning = %q", w)
+	}
+}
+
+func TestLimiterFor_PerHost(t *testing.T) {
+	eu := limiterFor("api.eu.snyk.io")
+	if limiterFor("API.EU.snyk.io") != eu {
+		t.Error("same host (any case) should share a limiter")
+	}
+	if limiterFor("api.us.snyk.io") == eu {
+		t.Error("different hosts should have separate limiters")
+	}
+}
+
+func TestWithRetryCounter(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	var n atomic.Int64
+	ctx := WithRetryCounter(context.Background(), &n)
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if _, _, err := DoWithRetry(ctx, srv.Client(), req); err != nil {
+		t.Fatalf("DoWithRetry: %v", err)
+	}
+	if n.Load() != 1 {
+		t.Errorf("counter = %d, want 1 retry", n.Load())
+	}
+}
+
+func TestDoWithRetry_DNSFailure(t *testing.T) {
+	defer func(d time.Duration) { dnsRetryBackoff = d }(dnsRetryBackoff)
+	dnsRetryBackoff = time.Millisecond
+
+	var n atomic.Int64
+	ctx := WithRetryCounter(context.Background(), &n)
+	// .invalid never resolves (RFC 2606); no proxy, so the lookup is ours.
+	client := &http.Client{Transport: &http.Transport{}}
+	req, _ := http.NewRequest(http.MethodGet, "https://snyk-target-export-test.invalid/rest/self", nil)
+	_, _, err := DoWithRetry(ctx, client, req)
+	if err == nil {
+		t.Fatal("DoWithRetry succeeded for a host that does not resolve")
+	}
+	if !isDNSError(err) {
+		t.Errorf("error %v does not wrap a DNS error", err)
+	}
+	if !strings.Contains(err.Error(), "could not resolve snyk-target-export-test.invalid") || !strings.Contains(err.Error(), "proxy") {
+		t.Errorf("error %q lacks the resolve and proxy hint", err)
+	}
+	if want := int64(DefaultRetryConfig().MaxRetries); n.Load() != want {
+		t.Errorf("retries = %d, want %d", n.Load(), want)
+	}
+}
+
+// roundTripFunc adapts a function to http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestDoWithRetry_DNSFailureThenResponses(t *testing.T) {
+	defer func(d time.Duration) { dnsRetryBackoff = d }(dnsRetryBackoff)
+	dnsRetryBackoff = time.Millisecond
+
+	var calls atomic.Int32
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if calls.Add(1) == 1 {
+			return nil, &net.DNSError{Err: "no such host", Name: r.URL.Hostname(), IsNotFound: true}
+		}
+		return &http.Response{
+			StatusCode: http.StatusTooManyRequests,
+			Header:     http.Header{"Retry-After": []string{"1"}},
+			Body:       io.NopCloser(strings.NewReader("")),
+			Request:    r,
+		}, nil
+	})}
+	req, _ := http.NewRequest(http.MethodGet, "https://api.example.test/rest/self", nil)
+	_, _, err := DoWithRetry(context.Background(), client, req)
+	if err == nil {
+		t.Fatal("DoWithRetry succeeded, want max retries exceeded")
+	}
+	if isDNSError(err) || strings.Contains(err.Error(), "could not resolve") || !strings.Contains(err.Error(), "status 429") {
+		t.Errorf("error %q should report the last attempt's 429, not the first attempt's DNS failure", err)
+	}
+}
+
+func TestDoWithRetry_CertError(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	var n atomic.Int64
+	ctx := WithRetryCounter(context.Background(), &n)
+	// A plain client does not trust the test server's self-signed certificate.
+	client := &http.Client{Transport: &http.Transport{}}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	_, _, err := DoWithRetry(ctx, client, req)
+	if err == nil {
+		t.Fatal("DoWithRetry succeeded with an untrusted certificate")
+	}
+	if !isCertError(err) {
+		t.Errorf("error %v does not wrap a certificate error", err)
+	}
+	if !strings.Contains(err.Error(), "could not be verified") || !strings.Contains(err.Error(), "SSL_CERT_FILE") {
+		t.Errorf("error %q lacks the CA hint", err)
+	}
+	if n.Load() != 0 {
+		t.Errorf("retries = %d, want none", n.Load())
+	}
+}
+
+func TestNewHTTPClient_MinTLSVersion(t *testing.T) {
+	defer SetMinTLSVersion("1.2")
+
+	tlsConfig := func() *tls.Config {
+		t.Helper()
+		tr, ok := NewHTTPClient().Transport.(*http.Transport)
+		if !ok || tr.TLSClientConfig == nil {
+			t.Fatalf("transport = %T without a TLS config", NewHTTPClient().Transport)
+		}
+		return tr.TLSClientConfig
+	}
+	if got := tlsConfig().MinVersion; got != tls.VersionTLS12 {
+		t.Errorf("default MinVersion = %#x, want TLS 1.2", got)
+	}
+	if err := SetMinTLSVersion("1.3"); err != nil {
+		t.Fatalf("SetMinTLSVersion(1.3): %v", err)
+	}
+	if got := tlsConfig().MinVersion; got != tls.VersionTLS13 {
+		t.Errorf("MinVersion = %#x, want TLS 1.3", got)
+	}
+	if err := SetMinTLSVersion("1.1"); err == nil {
+		t.Error("SetMinTLSVersion(1.1): want error")
+	}
+}
+
+func TestNewHTTPClient_ConnPool(t *testing.T) {
+	defer SetConnPool(DefaultConnPool)
+
+	transport := func() *http.Transport {
+		t.Helper()
+		tr, ok := NewHTTPClient().Transport.(*http.Transport)
+		if !ok {
+			t.Fatalf("transport = %T, want *http.Transport", NewHTTPClient().Transport)
+		}
+		return tr
+	}
+	tr := transport()
+	if tr.MaxIdleConns != 100 || tr.MaxIdleConnsPerHost != 16 || tr.IdleConnTimeout != 90*time.Second {
+		t.Errorf("default pool = %d/%d/%v, want 100/16/90s", tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.IdleConnTimeout)
+	}
+
+	if err := SetConnPool(ConnPool{MaxIdleConns: 50, MaxIdleConnsPerHost: 32, IdleConnTimeout: time.Minute}); err != nil {
+		t.Fatalf("SetConnPool: %v", err)
+	}
+	tr = transport()
+	if tr.MaxIdleConns != 50 || tr.MaxIdleConnsPerHost != 32 || tr.IdleConnTimeout != time.Minute {
+		t.Errorf("configured pool = %d/%d/%v, want 50/32/1m", tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.IdleConnTimeout)
+	}
+
+	for _, bad := range []ConnPool{
+		{MaxIdleConns: 0, MaxIdleConnsPerHost: 1, IdleConnTimeout: time.Second},
+		{MaxIdleConns: 1, MaxIdleConnsPerHost: 0, IdleConnTimeout: time.Second},
+		{MaxIdleConns: 1, MaxIdleConnsPerHost: 1},
+	} {
+		if err := SetConnPool(bad); err == nil {
+			t.Errorf("SetConnPool(%+v): want error", bad)
+		}
+	}
+}
+
+func TestNewHTTPClient_Timeout(t *testing.T) {
+	defer SetHTTPTimeout(DefaultHTTPTimeout)
+
+	if got := NewHTTPClient().Timeout; got != 60*time.Second {
+		t.Errorf("default timeout = %v, want 60s", got)
+	}
+	if err := SetHTTPTimeout(2 * time.Minute); err != nil {
+		t.Fatalf("SetHTTPTimeout: %v", err)
+	}
+	if got := NewHTTPClient().Timeout; got != 2*time.Minute {
+		t.Errorf("configured timeout = %v, want 2m", got)
+	}
+	for _, bad := range []time.Duration{0, -time.Second} {
+		if err := SetHTTPTimeout(bad); err == nil {
+			t.Errorf("SetHTTPTimeout(%v): want error", bad)
+		}
+	}
+}
+
+func TestNewHTTPClient_RedirectSafety(t *testing.T) {
+	defer SetFollowRedirects(true)
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer other.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same-host":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		case "/off-host":
+			http.Redirect(w, r, other.URL+"/final", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+	client := NewHTTPClient()
+
+	resp, err := client.Get(srv.URL + "/same-host")
+	if err != nil {
+		t.Fatalf("same-host redirect: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/final" {
+		t.Errorf("same-host redirect: status %d at %s, want 200 at /final", resp.StatusCode, resp.Request.URL.Path)
+	}
+
+	if resp, err := client.Get(srv.URL + "/off-host"); err == nil {
+		resp.Body.Close()
+		t.Error("off-host redirect: want error")
+	}
+
+	SetFollowRedirects(false)
+	resp, err = client.Get(srv.URL + "/same-host")
+	if err != nil {
+		t.Fatalf("redirects disabled: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("redirects disabled: status %d, want 302", resp.StatusCode)
+	}
+}
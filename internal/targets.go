@@ -1,7 +1,11 @@
 package internal
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -12,13 +16,49 @@ type Target struct {
 	Branch     string `json:"branch,omitempty"`
 	ProjectKey string `json:"projectKey,omitempty"`
 	RepoSlug   string `json:"repoSlug,omitempty"`
+	// NullBranch writes an unknown (empty) Branch as "branch": null instead
+	// of omitting it (--explicit-branch). It only applies to owner/name
+	// targets; Bitbucket Server targets have no branch field.
+	NullBranch bool `json:"-"`
+	// Host is the GitHub Enterprise host of a github-enterprise target, when
+	// known. snyk-api-import has no field for it, so it is not written; it
+	// only keeps TargetID distinct for same-named repos on different hosts.
+	Host string `json:"-"`
+}
+
+// MarshalJSON omits an empty branch unless NullBranch is set on an
+// owner/name target, in which case it is written as null.
+func (t Target) MarshalJSON() ([]byte, error) {
+	type plain Target
+	if !t.NullBranch || t.Branch != "" || t.Owner == "" {
+		return json.Marshal(plain(t))
+	}
+	return json.Marshal(struct {
+		plain
+		Branch *string `json:"branch"`
+	}{plain: plain(t)})
+}
+
+// File is a manifest path within a target, as accepted by snyk-api-import.
+type File struct {
+	Path string `json:"path"`
 }
 
 // ImportTarget is a target with its org and integration context.
+// Files, when set, limits the import to the listed manifests.
 type ImportTarget struct {
 	Target        Target `json:"target"`
 	OrgID         string `json:"orgId"`
 	IntegrationID string `json:"integrationId"`
+	Files         []File `json:"files,omitempty"`
+	// ProjectCount is the number of Snyk projects collapsed into this target
+	// (set only with --count-manifests).
+	ProjectCount int `json:"projectCount,omitempty"`
+	// ProjectID and ProjectName identify the Snyk project the target was
+	// derived from, for audits (set only with --with-provenance). When several
+	// projects collapse into one target, the first one seen is recorded.
+	ProjectID   string `json:"projectId,omitempty"`
+	ProjectName string `json:"projectName,omitempty"`
 }
 
 // SCM origin values that the refresh tool supports.
@@ -40,6 +80,16 @@ func IsSCMOrigin(origin string) bool {
 	return scmOrigins[origin]
 }
 
+// SCMOrigins returns the supported SCM project origins, sorted.
+func SCMOrigins() []string {
+	origins := make([]string, 0, len(scmOrigins))
+	for o := range scmOrigins {
+		origins = append(origins, o)
+	}
+	sort.Strings(origins)
+	return origins
+}
+
 // OriginToIntegrationKey maps a project origin to the integration key
 // used by ListIntegrations. Most are 1:1. The Snyk API uses
 // "bitbucket-connect-app" as the key for the Bitbucket Cloud App
@@ -52,25 +102,143 @@ func OriginToIntegrationKey(origin string) string {
 	return origin
 }
 
+// equivalentIntegrationKeys maps integration keys that can import the same
+// repositories to the canonical key of their family: the Bitbucket Cloud App
+// supersedes the Basic-Auth Bitbucket Cloud integration, and the GitHub Cloud
+// App supersedes the original GitHub integration.
+var equivalentIntegrationKeys = map[string]string{
+	"bitbucket-cloud": "bitbucket-connect-app",
+	"github":          "github-cloud-app",
+}
+
+// CanonicalIntegrationKey returns the canonical integration key of key's
+// equivalence family, or key itself when it has no known equivalent.
+func CanonicalIntegrationKey(key string) string {
+	if c, ok := equivalentIntegrationKeys[key]; ok {
+		return c
+	}
+	return key
+}
+
+// splitProjectName splits a project name of the form
+// "owner/repo(branch):path/to/manifest" into the repo path ("owner/repo") and
+// the manifest path. The "(branch)" annotation and ":manifest" suffix are both
+// optional. The annotation is located before looking for the manifest colon,
+// because branch names may themselves contain ':' or '/'.
+func splitProjectName(name string) (repoPath, manifest string) {
+	end := strings.IndexAny(name, "(:")
+	if end < 0 {
+		return strings.TrimSpace(name), ""
+	}
+	repoPath = strings.TrimSpace(name[:end])
+	rest := name[end:]
+	if strings.HasPrefix(rest, "(") {
+		closing := strings.Index(rest, ")")
+		if closing < 0 {
+			return repoPath, ""
+		}
+		rest = rest[closing+1:]
+	}
+	if i := strings.Index(rest, ":"); i >= 0 {
+		manifest = strings.TrimSpace(rest[i+1:])
+	}
+	return repoPath, manifest
+}
+
+// nameTemplate, when set (--project-name-template), replaces the built-in
+// per-origin parsing of project names in ProjectToTarget.
+var nameTemplate *regexp.Regexp
+
+// SetProjectNameTemplate makes ProjectToTarget parse project names with expr,
+// a regular expression with the named groups owner (or projectKey) and repo
+// (or repoSlug), and optionally branch. An empty expr restores the built-in
+// parsing.
+func SetProjectNameTemplate(expr string) error {
+	if expr == "" {
+		nameTemplate = nil
+		return nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("invalid regular expression: %w", err)
+	}
+	groups := make(map[string]bool)
+	for _, g := range re.SubexpNames()[1:] {
+		switch g {
+		case "":
+		case "owner", "projectKey", "repo", "repoSlug", "branch":
+			groups[g] = true
+		default:
+			return fmt.Errorf("unknown named group %q (want owner, repo, branch, projectKey, or repoSlug)", g)
+		}
+	}
+	if !(groups["owner"] || groups["projectKey"]) || !(groups["repo"] || groups["repoSlug"]) {
+		return fmt.Errorf("needs the named groups owner (or projectKey) and repo (or repoSlug)")
+	}
+	nameTemplate = re
+	return nil
+}
+
+// templateToTarget parses name with nameTemplate. owner and projectKey are
+// the same group, as are repo and repoSlug; the origin decides which target
+// fields they fill. branch, when known, takes precedence over a branch
+// captured from the name.
+func templateToTarget(name, origin, branch string) (Target, bool) {
+	m := nameTemplate.FindStringSubmatch(name)
+	if m == nil {
+		return Target{}, false
+	}
+	var owner, repo, captured string
+	for i, g := range nameTemplate.SubexpNames() {
+		switch {
+		case m[i] == "":
+		case g == "owner" || g == "projectKey":
+			owner = m[i]
+		case g == "repo" || g == "repoSlug":
+			repo = m[i]
+		case g == "branch":
+			captured = m[i]
+		}
+	}
+	if owner == "" || repo == "" {
+		return Target{}, false
+	}
+	if origin == "bitbucket-server" {
+		return Target{ProjectKey: owner, RepoSlug: repo}, true
+	}
+	if branch == "" {
+		branch = captured
+	}
+	return Target{Owner: owner, Name: repo, Branch: branch}, true
+}
+
 // ProjectToTarget converts a Snyk project into an import Target.
 // Returns (target, true) on success, or (Target{}, false) if the
 // origin is unsupported (e.g. GitLab).
 func ProjectToTarget(name, origin, branch string) (Target, bool) {
+	if nameTemplate != nil {
+		if !IsSCMOrigin(origin) {
+			return Target{}, false
+		}
+		return templateToTarget(name, origin, branch)
+	}
 	switch origin {
 	case "github", "github-cloud-app", "github-enterprise",
 		"bitbucket-cloud", "bitbucket-connect-app", "bitbucket-cloud-app",
 		"azure-repos":
-		// Name format: "owner/repo:path/to/manifest"
-		base := strings.SplitN(name, ":", 2)[0]
+		// Name format: "owner/repo(branch):path/to/manifest", where the
+		// "(branch)" and ":manifest" parts are optional
+		base, _ := splitProjectName(name)
+		if origin == "azure-repos" {
+			base = stripAzureGitSegment(base)
+		}
 		parts := strings.SplitN(base, "/", 2)
-		if len(parts) < 2 {
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
 			return Target{}, false
 		}
-		// Remove any trailing parenthetical like "(branch)"
-		repoName := strings.SplitN(parts[1], "(", 2)[0]
 		t := Target{
 			Owner: parts[0],
-			Name:  repoName,
+			Name:  parts[1],
 		}
 		if branch != "" {
 			t.Branch = branch
@@ -78,16 +246,15 @@ func ProjectToTarget(name, origin, branch string) (Target, bool) {
 		return t, true
 
 	case "bitbucket-server":
-		// Name format: "projectKey/repoSlug:path"
-		base := strings.SplitN(name, ":", 2)[0]
+		// Name format: "projectKey/repoSlug(branch):path"
+		base, _ := splitProjectName(name)
 		parts := strings.SplitN(base, "/", 2)
-		if len(parts) < 2 {
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
 			return Target{}, false
 		}
-		repoSlug := strings.SplitN(parts[1], "(", 2)[0]
 		return Target{
 			ProjectKey: parts[0],
-			RepoSlug:   repoSlug,
+			RepoSlug:   parts[1],
 		}, true
 
 	default:
@@ -96,6 +263,41 @@ func ProjectToTarget(name, origin, branch string) (Target, bool) {
 	}
 }
 
+// stripAzureGitSegment turns an Azure Repos remote-URL style path
+// "org/project/_git/repo" into the "project/repo" form Snyk normally uses,
+// keeping the segment just before "_git" as the owner. Paths without an
+// interior "_git" segment are returned unchanged.
+func stripAzureGitSegment(base string) string {
+	segs := strings.Split(base, "/")
+	for i, s := range segs {
+		if s == "_git" && i > 0 && i < len(segs)-1 {
+			return segs[i-1] + "/" + strings.Join(segs[i+1:], "/")
+		}
+	}
+	return base
+}
+
+// EnterpriseHost returns the host of a github-enterprise project's repo URL,
+// lower-cased, or "" for other origins and when the URL has no host.
+func EnterpriseHost(origin, repoURL string) string {
+	if origin != "github-enterprise" || repoURL == "" {
+		return ""
+	}
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// ManifestPath returns the manifest path portion of a project name
+// ("owner/repo(branch):path/to/manifest" -> "path/to/manifest"), or "" when
+// the name has no path.
+func ManifestPath(name string) string {
+	_, manifest := splitProjectName(name)
+	return manifest
+}
+
 // TargetID generates a deduplication key for a target, matching the
 // TypeScript generateTargetId logic.
 func TargetID(orgID, integrationID string, t Target) string {
@@ -116,5 +318,10 @@ func TargetID(orgID, integrationID string, t Target) string {
 	if t.Branch != "" {
 		parts = append(parts, t.Branch)
 	}
+	// Host is not in generateTargetId; it goes last so IDs without one are
+	// unchanged.
+	if t.Host != "" {
+		parts = append(parts, strings.ToLower(t.Host))
+	}
 	return fmt.Sprintf("%s:%s:%s", orgID, integrationID, strings.Join(parts, ":"))
 }
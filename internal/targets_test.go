@@ -1,6 +1,9 @@
 package internal
 
-import "testing"
+import (
+	"encoding/json"
+	"testing"
+)
 
 func TestIsSCMOrigin(t *testing.T) {
 	supported := []string{
@@ -60,6 +63,28 @@ func TestOriginToIntegrationKey(t *testing.T) {
 	}
 }
 
+func TestTargetMarshalJSON_Branch(t *testing.T) {
+	tests := []struct {
+		target Target
+		want   string
+	}{
+		{Target{Owner: "o", Name: "r", Branch: "main"}, `{"name":"r","owner":"o","branch":"main"}`},
+		{Target{Owner: "o", Name: "r"}, `{"name":"r","owner":"o"}`},
+		{Target{Owner: "o", Name: "r", Branch: "main", NullBranch: true}, `{"name":"r","owner":"o","branch":"main"}`},
+		{Target{Owner: "o", Name: "r", NullBranch: true}, `{"name":"r","owner":"o","branch":null}`},
+		{Target{ProjectKey: "PROJ", RepoSlug: "slug", NullBranch: true}, `{"projectKey":"PROJ","repoSlug":"slug"}`},
+	}
+	for _, tt := range tests {
+		data, err := json.Marshal(tt.target)
+		if err != nil {
+			t.Fatalf("Marshal(%+v): %v", tt.target, err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("Marshal(%+v) = %s, want %s", tt.target, data, tt.want)
+		}
+	}
+}
+
 func TestProjectToTarget_GitHub(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -89,6 +114,30 @@ func TestProjectToTarget_GitHub(t *testing.T) {
 			want:   Target{Owner: "owner", Name: "repo"},
 			wantOK: true,
 		},
+		{
+			// Parenthetical branch without a manifest (Code-only projects)
+			name:   "owner/repo(main)",
+			origin: "github",
+			branch: "main",
+			want:   Target{Owner: "owner", Name: "repo", Branch: "main"},
+			wantOK: true,
+		},
+		{
+			// Branch containing a slash must not be taken as part of the repo
+			name:   "owner/repo(feature/x)",
+			origin: "github",
+			branch: "feature/x",
+			want:   Target{Owner: "owner", Name: "repo", Branch: "feature/x"},
+			wantOK: true,
+		},
+		{
+			// Branch containing a colon must not be taken as the manifest separator
+			name:   "owner/repo(release:1.2):package.json",
+			origin: "github",
+			branch: "",
+			want:   Target{Owner: "owner", Name: "repo"},
+			wantOK: true,
+		},
 		{
 			// No slash in name -> invalid
 			name:   "noslash",
@@ -192,6 +241,31 @@ func TestProjectToTarget_AzureRepos(t *testing.T) {
 	}
 }
 
+func TestProjectToTarget_AzureReposGitURL(t *testing.T) {
+	tests := []struct {
+		name string
+		want Target
+	}{
+		{"myorg/myproject/_git/myrepo:src/package.json", Target{Owner: "myproject", Name: "myrepo", Branch: "main"}},
+		{"myproject/_git/myrepo(main):go.mod", Target{Owner: "myproject", Name: "myrepo", Branch: "main"}},
+		// Two- and three-part names without _git are unchanged.
+		{"myproject/myrepo:go.mod", Target{Owner: "myproject", Name: "myrepo", Branch: "main"}},
+		{"myorg/myproject/myrepo:go.mod", Target{Owner: "myorg", Name: "myproject/myrepo", Branch: "main"}},
+		// A trailing _git has no repo after it and is kept literally.
+		{"myproject/_git", Target{Owner: "myproject", Name: "_git", Branch: "main"}},
+	}
+	for _, tt := range tests {
+		got, ok := ProjectToTarget(tt.name, "azure-repos", "main")
+		if !ok || got != tt.want {
+			t.Errorf("ProjectToTarget(%q) = %+v, %v; want %+v", tt.name, got, ok, tt.want)
+		}
+	}
+	// Only azure-repos names are rewritten.
+	if got, _ := ProjectToTarget("owner/proj/_git/repo", "github", ""); got.Name != "proj/_git/repo" {
+		t.Errorf("github name rewritten: %+v", got)
+	}
+}
+
 func TestTargetID(t *testing.T) {
 	// GitHub-style target
 	tid := TargetID("org-1", "int-1", Target{Name: "repo", Owner: "owner", Branch: "main"})
@@ -218,4 +292,79 @@ func TestTargetID(t *testing.T) {
 	if tidX == tidY {
 		t.Error("TargetIDs should differ for different integrations")
 	}
+
+	// Same GitHub Enterprise repo on different hosts should differ
+	ghe := func(repoURL string) Target {
+		return Target{Name: "repo", Owner: "owner", Branch: "main", Host: EnterpriseHost("github-enterprise", repoURL)}
+	}
+	tidG1 := TargetID("org-1", "int-1", ghe("https://ghe.one.example.com/owner/repo"))
+	tidG2 := TargetID("org-1", "int-1", ghe("https://GHE.two.example.com:8443/owner/repo"))
+	if tidG1 == tidG2 {
+		t.Error("TargetIDs should differ for different GitHub Enterprise hosts")
+	}
+	if tidG2 != "org-1:int-1:repo:owner:main:ghe.two.example.com" {
+		t.Errorf("got %q", tidG2)
+	}
+	if h := EnterpriseHost("github", "https://github.com/owner/repo"); h != "" {
+		t.Errorf("EnterpriseHost for a non-enterprise origin = %q, want empty", h)
+	}
+}
+
+func TestManifestPath(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"owner/repo:package.json", "package.json"},
+		{"owner/repo:src/go.mod", "src/go.mod"},
+		{"owner/repo(branch):Dockerfile", "Dockerfile"},
+		{"owner/repo", ""},
+		{"owner/repo:", ""},
+		{"owner/repo(feature/x)", ""},
+		{"owner/repo(feature/x):api/go.mod", "api/go.mod"},
+		{"owner/repo(release:1.2):package.json", "package.json"},
+	}
+	for _, tt := range tests {
+		if got := ManifestPath(tt.name); got != tt.want {
+			t.Errorf("ManifestPath(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProjectToTarget_NameTemplate(t *testing.T) {
+	defer SetProjectNameTemplate("")
+	if err := SetProjectNameTemplate(`^(?P<owner>[^_]+)__(?P<repo>[^@]+)(@(?P<branch>.+))?$`); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, origin, branch string
+		want                 Target
+		ok                   bool
+	}{
+		{"acme__api@develop", "github", "", Target{Owner: "acme", Name: "api", Branch: "develop"}, true},
+		{"acme__api@develop", "github", "main", Target{Owner: "acme", Name: "api", Branch: "main"}, true},
+		{"acme__api", "azure-repos", "", Target{Owner: "acme", Name: "api"}, true},
+		{"PROJ__slug", "bitbucket-server", "main", Target{ProjectKey: "PROJ", RepoSlug: "slug"}, true},
+		{"acme/api", "github", "", Target{}, false},  // does not match the template
+		{"acme__api", "gitlab", "", Target{}, false}, // unsupported origin
+	}
+	for _, tt := range tests {
+		got, ok := ProjectToTarget(tt.name, tt.origin, tt.branch)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("ProjectToTarget(%q, %q, %q) = %+v, %v; want %+v, %v", tt.name, tt.origin, tt.branch, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	for _, expr := range []string{`(?P<owner>.+`, `(?P<owner>.+)/(?P<name>.+)`, `(?P<owner>.+)/.+`, `(?P<branch>.+)`} {
+		if err := SetProjectNameTemplate(expr); err == nil {
+			t.Errorf("SetProjectNameTemplate(%q) succeeded, want an error", expr)
+		}
+	}
+	if err := SetProjectNameTemplate(`(?P<projectKey>[^/]+)/(?P<repoSlug>.+)`); err != nil {
+		t.Errorf("projectKey/repoSlug template rejected: %v", err)
+	}
+	SetProjectNameTemplate("")
+	if got, _ := ProjectToTarget("acme/api", "github", ""); got != (Target{Owner: "acme", Name: "api"}) {
+		t.Errorf("after clearing the template, ProjectToTarget = %+v, want built-in parsing", got)
+	}
 }
@@ -0,0 +1,166 @@
+// jsonstream.go writes the refresh output incrementally (--stream-json), so
+// runs with hundreds of thousands of targets do not hold them all in memory.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/snyk-playground/snyk-target-export/internal"
+)
+
+// jsonStreamWriter writes a v2 RefreshOutput as a single JSON document while
+// the run is in progress: each org's targets are appended to the targets
+// array as the org finishes, and the metadata maps follow the array when the
+// run ends. The document goes to a temp file beside the output and is renamed
+// into place by finish, so the output path never holds a truncated file.
+type jsonStreamWriter struct {
+	path    string
+	tmp     *os.File
+	w       *bufio.Writer
+	compact bool
+	count   int // targets written so far
+}
+
+// newJSONStreamWriter creates the temp file for path and writes the opening
+// of the document. path must have been produced by sanitizeOutputPath.
+func newJSONStreamWriter(path string, compact bool) (*jsonStreamWriter, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	s := &jsonStreamWriter{path: path, tmp: tmp, w: bufio.NewWriter(tmp), compact: compact}
+	if err := tmp.Chmod(0600); err != nil {
+		s.abort()
+		return nil, err
+	}
+	open := "{\n  \"targets\": ["
+	if compact {
+		open = `{"targets":[`
+	}
+	if _, err := s.w.WriteString(open); err != nil {
+		s.abort()
+		return nil, err
+	}
+	return s, nil
+}
+
+// writeTargets appends targets to the targets array.
+func (s *jsonStreamWriter) writeTargets(targets []internal.ImportTarget) error {
+	for _, t := range targets {
+		var data []byte
+		var err error
+		if s.compact {
+			data, err = json.Marshal(t)
+		} else {
+			data, err = json.MarshalIndent(t, "    ", "  ")
+		}
+		if err != nil {
+			return fmt.Errorf("marshaling JSON: %w", err)
+		}
+		sep := ","
+		if s.count == 0 {
+			sep = ""
+		}
+		if !s.compact {
+			sep += "\n    "
+		}
+		if _, err := s.w.WriteString(sep); err != nil {
+			return err
+		}
+		if _, err := s.w.Write(data); err != nil {
+			return err
+		}
+		s.count++
+	}
+	return nil
+}
+
+// finish closes the targets array, writes the rest of out (its Targets are
+// ignored, having been written already), and renames the document into
+// place.
+func (s *jsonStreamWriter) finish(out RefreshOutput) error {
+	err := s.writeTail(out)
+	if err == nil {
+		err = s.w.Flush()
+	}
+	if err == nil {
+		err = s.tmp.Sync()
+	}
+	if err != nil {
+		s.abort()
+		return fmt.Errorf("writing output file: %w", err)
+	}
+	if err := s.tmp.Close(); err != nil {
+		os.Remove(s.tmp.Name())
+		return fmt.Errorf("writing output file: %w", err)
+	}
+	if err := os.Rename(s.tmp.Name(), s.path); err != nil {
+		os.Remove(s.tmp.Name())
+		return fmt.Errorf("writing output file: %w", err)
+	}
+	return nil
+}
+
+// writeTail writes the end of the targets array, then out's other fields,
+// leaving out groupId and partialOrgs when empty as RefreshOutput does.
+func (s *jsonStreamWriter) writeTail(out RefreshOutput) error {
+	type field struct {
+		name  string
+		value interface{}
+	}
+	var fields []field
+	if out.GroupID != "" {
+		fields = append(fields, field{"groupId", out.GroupID})
+	}
+	fields = append(fields, field{"orgs", out.Orgs}, field{"integrations", out.Integrations})
+	if len(out.PartialOrgs) > 0 {
+		fields = append(fields, field{"partialOrgs", out.PartialOrgs})
+	}
+
+	tail := "]"
+	if !s.compact && s.count > 0 {
+		tail = "\n  ]"
+	}
+	if _, err := s.w.WriteString(tail); err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if s.compact {
+			data, err := json.Marshal(f.value)
+			if err != nil {
+				return fmt.Errorf("marshaling JSON: %w", err)
+			}
+			if _, err := fmt.Fprintf(s.w, ",%q:%s", f.name, data); err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := json.MarshalIndent(f.value, "  ", "  ")
+		if err != nil {
+			return fmt.Errorf("marshaling JSON: %w", err)
+		}
+		if _, err := fmt.Fprintf(s.w, ",\n  %q: %s", f.name, data); err != nil {
+			return err
+		}
+	}
+	end := "}"
+	if !s.compact {
+		end = "\n}"
+	}
+	_, err := s.w.WriteString(end)
+	return err
+}
+
+// abort discards the partly written document. It is safe to call on a nil
+// writer and after finish.
+func (s *jsonStreamWriter) abort() {
+	if s == nil {
+		return
+	}
+	s.tmp.Close()
+	os.Remove(s.tmp.Name())
+}
@@ -0,0 +1,341 @@
+// limiter.go provides a shared concurrency limiter that bounds every outbound
+// Snyk API call made by a subcommand, so --concurrency is honored end to end.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/snyk-playground/snyk-target-export/internal"
+)
+
+// limiter is a counting semaphore shared by all API calls in a subcommand.
+// It counts how often acquire had to wait, as a measure of saturation.
+//
+// An adaptive limiter (see newAdaptiveLimiter) keeps some of its slots parked
+// so that only limit of them are usable, and adjust moves limit between 1 and
+// cap(sem).
+type limiter struct {
+	sem      chan struct{}
+	acquired atomic.Int64
+	blocked  atomic.Int64
+
+	adaptive bool
+	parked   int // slots held by adjust; only touched by adjust and its caller
+	limit    atomic.Int64
+	lowest   atomic.Int64
+}
+
+// newLimiter returns a limiter allowing at most n concurrent holders.
+// Values below 1 are treated as 1.
+func newLimiter(n int) *limiter {
+	if n < 1 {
+		n = 1
+	}
+	return &limiter{sem: make(chan struct{}, n)}
+}
+
+// newAdaptiveLimiter returns a limiter whose usable slots start at start and
+// can be moved between 1 and ceiling by adjust. start is clamped to that range.
+func newAdaptiveLimiter(start, ceiling int) *limiter {
+	l := newLimiter(ceiling)
+	start = max(1, min(start, cap(l.sem)))
+	l.adaptive = true
+	l.parked = cap(l.sem) - start
+	for range l.parked {
+		l.sem <- struct{}{}
+	}
+	l.limit.Store(int64(start))
+	l.lowest.Store(int64(start))
+	return l
+}
+
+// newRunLimiter returns the limiter for a subcommand's API calls: a fixed
+// limiter of size concurrency, or, when maxConcurrency is positive, an
+// adaptive one that starts at concurrency and is capped at maxConcurrency.
+// Call adapt on the result to start tuning.
+func newRunLimiter(concurrency, maxConcurrency int) *limiter {
+	if maxConcurrency > 0 {
+		return newAdaptiveLimiter(concurrency, maxConcurrency)
+	}
+	return newLimiter(concurrency)
+}
+
+// currentLimit returns the number of usable slots: the adaptive limit, or the
+// limiter's size for a fixed limiter.
+func (l *limiter) currentLimit() int {
+	if l.adaptive {
+		return int(l.limit.Load())
+	}
+	return cap(l.sem)
+}
+
+// adaptInterval is how often an adaptive limiter checks for throttling.
+const adaptInterval = 5 * time.Second
+
+// adjust applies one step of additive-increase/multiplicative-decrease to an
+// adaptive limiter: if throttled, the limit is halved (to no less than 1);
+// otherwise it grows by one, up to cap(sem). Shrinking waits for in-flight
+// calls to release the slots being parked, or for ctx to be done.
+func (l *limiter) adjust(ctx context.Context, throttled bool) {
+	cur := int(l.limit.Load())
+	next := cur + 1
+	if throttled {
+		next = max(1, cur/2)
+	}
+	next = min(next, cap(l.sem))
+	for ; cur < next; cur++ {
+		<-l.sem
+		l.parked--
+	}
+	for ; cur > next; cur-- {
+		select {
+		case l.sem <- struct{}{}:
+			l.parked++
+		case <-ctx.Done():
+			return
+		}
+	}
+	l.limit.Store(int64(cur))
+	if int64(cur) < l.lowest.Load() {
+		l.lowest.Store(int64(cur))
+	}
+}
+
+// adapt runs adjust every interval until ctx is done, treating any new 429
+// since the previous check as throttling. It is a no-op for a non-adaptive
+// limiter.
+func (l *limiter) adapt(ctx context.Context, interval time.Duration) {
+	if !l.adaptive {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last := internal.ThrottleCount()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			n := internal.ThrottleCount()
+			before := l.limit.Load()
+			l.adjust(ctx, n > last)
+			if after := l.limit.Load(); after != before {
+				log.Printf("Adaptive concurrency: %d -> %d (%d new 429 response(s))", before, after, n-last)
+			}
+			last = n
+		}
+	}()
+}
+
+// acquire blocks until a slot is free or ctx is done.
+func (l *limiter) acquire(ctx context.Context) error {
+	select {
+	case l.sem <- struct{}{}:
+		l.acquired.Add(1)
+		return nil
+	default:
+	}
+	l.blocked.Add(1)
+	select {
+	case l.sem <- struct{}{}:
+		l.acquired.Add(1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// saturation returns the fraction of acquires that had to wait for a slot and
+// the number of acquires it is based on.
+func (l *limiter) saturation() (ratio float64, samples int64) {
+	samples = l.acquired.Load()
+	if samples == 0 {
+		return 0, 0
+	}
+	return float64(l.blocked.Load()) / float64(samples), samples
+}
+
+// Thresholds for saturationWarning: enough calls to be meaningful, most of
+// them queued, and a retry rate that suggests 429 storms.
+const (
+	saturationMinSamples = 20
+	saturationWarnRatio  = 0.5
+	saturationMinRetries = 5
+)
+
+// saturationWarning returns a hint to lower --concurrency when the limiter was
+// mostly saturated and retries were frequent (at least saturationMinRetries
+// and a tenth of all calls), or "" otherwise.
+func (l *limiter) saturationWarning(retries int64) string {
+	ratio, samples := l.saturation()
+	if samples < saturationMinSamples || ratio < saturationWarnRatio {
+		return ""
+	}
+	if retries < saturationMinRetries || retries*10 < samples {
+		return ""
+	}
+	return fmt.Sprintf("WARNING: %.0f%% of %d API call(s) waited for a --concurrency slot and %d retr(ies) occurred. "+
+		"The API is likely rate limiting; lowering --concurrency (currently %d) may improve throughput.",
+		ratio*100, samples, retries, cap(l.sem))
+}
+
+// logSaturation logs the limiter's saturation and, if warranted, the
+// saturationWarning for the run.
+func (l *limiter) logSaturation() {
+	ratio, samples := l.saturation()
+	log.Printf("Concurrency saturation: %.0f%% of %d API call(s) waited for a slot", ratio*100, samples)
+	if l.adaptive {
+		// Throttling already lowered the limit; a hint to lower it is moot.
+		log.Printf("Adaptive concurrency: finished at %d (lowest %d, ceiling --max-concurrency=%d)",
+			l.limit.Load(), l.lowest.Load(), cap(l.sem))
+		return
+	}
+	if msg := l.saturationWarning(internal.RetryCount()); msg != "" {
+		log.Print(msg)
+	}
+}
+
+// release frees a slot previously obtained with acquire.
+func (l *limiter) release() {
+	<-l.sem
+}
+
+// ramp starts the limiter with a single free slot and releases the remaining
+// slots evenly over d, so a cold rate-limit bucket is not hit by a burst of
+// concurrent requests. It must be called before any acquire. A d of zero (or a
+// limiter of size 1) is a no-op. When the ramp completes it logs how many
+// retries happened during warmup so the setting can be tuned.
+func (l *limiter) ramp(ctx context.Context, d time.Duration) {
+	held := cap(l.sem) - len(l.sem) - 1
+	if d <= 0 || held == 0 {
+		return
+	}
+	for i := 0; i < held; i++ {
+		l.sem <- struct{}{}
+	}
+	start := internal.RetryCount()
+	step := d / time.Duration(held)
+	go func() {
+		ticker := time.NewTicker(step)
+		defer ticker.Stop()
+		for i := 0; i < held; i++ {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+			}
+			l.release()
+		}
+		log.Printf("Concurrency ramp finished after %v: %d retr(ies) during warmup", d, internal.RetryCount()-start)
+	}()
+}
+
+// limitedAPI wraps a SnykAPI so each call holds a limiter slot for its duration.
+// Deletes use deleteLim so they can be throttled separately from reads.
+type limitedAPI struct {
+	api       SnykAPI
+	lim       *limiter
+	deleteLim *limiter
+}
+
+// withLimiter returns a SnykAPI whose reads are bounded by lim and whose
+// deletes are bounded by deleteLim. A nil deleteLim means deletes share lim.
+func withLimiter(api SnykAPI, lim, deleteLim *limiter) SnykAPI {
+	if deleteLim == nil {
+		deleteLim = lim
+	}
+	return &limitedAPI{api: api, lim: lim, deleteLim: deleteLim}
+}
+
+func (l *limitedAPI) FetchOrgs(ctx context.Context, groupID string) ([]internal.Org, error) {
+	if err := l.lim.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.lim.release()
+	return l.api.FetchOrgs(ctx, groupID)
+}
+
+func (l *limitedAPI) ListIntegrations(ctx context.Context, orgID string) (map[string]string, error) {
+	if err := l.lim.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.lim.release()
+	return l.api.ListIntegrations(ctx, orgID)
+}
+
+func (l *limitedAPI) FetchProjects(ctx context.Context, orgID string) ([]internal.Project, error) {
+	if err := l.lim.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.lim.release()
+	return l.api.FetchProjects(ctx, orgID)
+}
+
+func (l *limitedAPI) FetchTargets(ctx context.Context, orgID string) ([]internal.APITarget, error) {
+	if err := l.lim.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.lim.release()
+	return l.api.FetchTargets(ctx, orgID)
+}
+
+func (l *limitedAPI) DeleteProject(ctx context.Context, orgID, projectID string) error {
+	if err := l.deleteLim.acquire(ctx); err != nil {
+		return err
+	}
+	defer l.deleteLim.release()
+	return l.api.DeleteProject(ctx, orgID, projectID)
+}
+
+func (l *limitedAPI) DeleteTarget(ctx context.Context, orgID, targetID string) error {
+	if err := l.deleteLim.acquire(ctx); err != nil {
+		return err
+	}
+	defer l.deleteLim.release()
+	return l.api.DeleteTarget(ctx, orgID, targetID)
+}
+
+func (l *limitedAPI) FetchUser(ctx context.Context) (internal.User, error) {
+	if err := l.lim.acquire(ctx); err != nil {
+		return internal.User{}, err
+	}
+	defer l.lim.release()
+	return l.api.FetchUser(ctx)
+}
+
+func (l *limitedAPI) FetchGroups(ctx context.Context) ([]internal.Group, error) {
+	if err := l.lim.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.lim.release()
+	return l.api.FetchGroups(ctx)
+}
+
+func (l *limitedAPI) CheckGroupAccess(ctx context.Context, groupID string) error {
+	if err := l.lim.acquire(ctx); err != nil {
+		return err
+	}
+	defer l.lim.release()
+	return l.api.CheckGroupAccess(ctx, groupID)
+}
+
+func (l *limitedAPI) CheckOrgAccess(ctx context.Context, orgID string) error {
+	if err := l.lim.acquire(ctx); err != nil {
+		return err
+	}
+	defer l.lim.release()
+	return l.api.CheckOrgAccess(ctx, orgID)
+}
+
+func (l *limitedAPI) IntegrationActive(ctx context.Context, orgID, integrationID string) (bool, error) {
+	if err := l.lim.acquire(ctx); err != nil {
+		return false, err
+	}
+	defer l.lim.release()
+	return l.api.IntegrationActive(ctx, orgID, integrationID)
+}
@@ -7,12 +7,16 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"math/rand/v2"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/snyk-playground/snyk-target-export/internal"
 )
@@ -40,6 +44,169 @@ func validateGroupOrOrg(groupID, orgID string) error {
 	return nil
 }
 
+// flagEnvVars maps flag names to the environment variables that supply their
+// defaults. Precedence: explicit flag > environment variable > built-in default.
+var flagEnvVars = map[string]string{
+	"groupId":         "SNYK_GROUP_ID",
+	"orgId":           "SNYK_ORG_ID",
+	"concurrency":     "SNYK_CONCURRENCY",
+	"output":          "SNYK_OUTPUT",
+	"integrationType": "SNYK_INTEGRATION_TYPE",
+}
+
+// applyEnvDefaults sets each flag in fs that was not given on the command line
+// from its environment variable in flagEnvVars, if that variable is non-empty.
+// Must be called after fs.Parse. Flags not defined in fs are ignored.
+// --groupId and --orgId are mutually exclusive, so giving either on the command
+// line suppresses the environment default for both.
+func applyEnvDefaults(fs *flag.FlagSet) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	if explicit["groupId"] || explicit["orgId"] {
+		explicit["groupId"], explicit["orgId"] = true, true
+	}
+	for name, env := range flagEnvVars {
+		if explicit[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		v := os.Getenv(env)
+		if v == "" {
+			continue
+		}
+		if err := fs.Set(name, v); err != nil {
+			return fmt.Errorf("invalid %s=%q for --%s: %w", env, v, name, err)
+		}
+	}
+	return nil
+}
+
+// validateMaxConcurrency checks --max-concurrency: 0 disables adaptive
+// concurrency; otherwise it must be at least --concurrency, the starting point.
+func validateMaxConcurrency(maxConcurrency, concurrency int) error {
+	if maxConcurrency != 0 && maxConcurrency < concurrency {
+		return fmt.Errorf("--max-concurrency must be 0 (fixed concurrency) or at least --concurrency (%d), got %d", concurrency, maxConcurrency)
+	}
+	return nil
+}
+
+// validateConcurrency ensures a concurrency flag value is at least 1.
+func validateConcurrency(flagName string, n int) error {
+	if n < 1 {
+		return fmt.Errorf("--%s must be at least 1, got %d", flagName, n)
+	}
+	return nil
+}
+
+// stringList is a repeatable flag.Value that also accepts comma-separated values,
+// so "--x=a,b" and "--x=a --x=b" are equivalent.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			*s = append(*s, part)
+		}
+	}
+	return nil
+}
+
+// set returns the values as a lookup map, or nil when the list is empty.
+func (s stringList) set() map[string]bool {
+	if len(s) == 0 {
+		return nil
+	}
+	out := make(map[string]bool, len(s))
+	for _, v := range s {
+		out[v] = true
+	}
+	return out
+}
+
+// apiFlags holds the API connection flags shared by every subcommand that
+// talks to the Snyk API.
+type apiFlags struct {
+	baseURL         *string
+	apiPathPrefix   *string
+	followRedirects *bool
+	minTLS          *string
+	apiVersion      *string
+	tokenHeader     *string
+	tokenFormat     *string
+	maxIdle         *int
+	maxIdlePerHost  *int
+	idleTimeout     *time.Duration
+	httpTimeout     *time.Duration
+	maxPages        *int
+}
+
+// addAPIFlags defines the shared API connection flags on fs.
+func addAPIFlags(fs *flag.FlagSet) *apiFlags {
+	return &apiFlags{
+		baseURL:         fs.String("base-url", "", "Override the Snyk API base URL (takes precedence over SNYK_API)"),
+		apiPathPrefix:   fs.String("api-path-prefix", "", "Path prefixes of the APIs under the base URL for on-prem/enterprise deployments, as rest=/path,v1=/path (default rest=/rest,v1=/v1)"),
+		followRedirects: fs.Bool("follow-redirects", true, "Follow HTTP redirects that stay on the API host (false returns the redirect as an error)"),
+		minTLS:          fs.String("min-tls", "1.2", "Minimum TLS version for API connections: 1.2 or 1.3"),
+		apiVersion:      fs.String("api-version", internal.DefaultRESTVersion, "Snyk REST API version to request (YYYY-MM-DD, optionally with ~beta)"),
+		tokenHeader:     fs.String("token-header", internal.DefaultTokenHeader, "HTTP header that carries the API token (for gateways that rename it)"),
+		tokenFormat:     fs.String("token-value-format", internal.DefaultTokenFormat, "Format of the token header value; must contain exactly one %s"),
+		maxIdle:         fs.Int("max-idle-conns", internal.DefaultConnPool.MaxIdleConns, "Maximum idle keep-alive connections across all hosts"),
+		maxIdlePerHost:  fs.Int("max-idle-conns-per-host", internal.DefaultConnPool.MaxIdleConnsPerHost, "Maximum idle keep-alive connections to the API host (raise with --concurrency above ~16)"),
+		idleTimeout:     fs.Duration("idle-conn-timeout", internal.DefaultConnPool.IdleConnTimeout, "How long an idle keep-alive connection is kept open"),
+		maxPages:        fs.Int("max-pages", internal.DefaultMaxPages, "Stop following pagination after this many pages per org when listing projects or targets, logging that the org's results are truncated"),
+		httpTimeout:     fs.Duration("http-timeout", internal.DefaultHTTPTimeout, "Give up on one HTTP attempt (connect through reading the response) after this long; the request is then retried"),
+	}
+}
+
+// configureAPI applies the API settings shared by all subcommands: the
+// --base-url override and --api-path-prefix, --follow-redirects, --min-tls,
+// --api-version, the token header, connection pool, --http-timeout and
+// --max-pages, and the SNYK_API_ACCEPT header override.
+func configureAPI(f *apiFlags) error {
+	internal.SetFollowRedirects(*f.followRedirects)
+	if err := internal.SetRESTVersion(*f.apiVersion); err != nil {
+		return fmt.Errorf("--api-version: %w", err)
+	}
+	if err := internal.SetMinTLSVersion(*f.minTLS); err != nil {
+		return fmt.Errorf("--min-tls: %w", err)
+	}
+	pool := internal.ConnPool{MaxIdleConns: *f.maxIdle, MaxIdleConnsPerHost: *f.maxIdlePerHost, IdleConnTimeout: *f.idleTimeout}
+	if err := internal.SetConnPool(pool); err != nil {
+		return fmt.Errorf("--max-idle-conns/--max-idle-conns-per-host/--idle-conn-timeout: %w", err)
+	}
+	if err := internal.SetHTTPTimeout(*f.httpTimeout); err != nil {
+		return fmt.Errorf("--http-timeout: %w", err)
+	}
+	if err := internal.SetMaxPages(*f.maxPages); err != nil {
+		return fmt.Errorf("--max-pages: %w", err)
+	}
+	if err := internal.SetTokenHeader(*f.tokenHeader, *f.tokenFormat); err != nil {
+		return fmt.Errorf("--token-header/--token-value-format: %w", err)
+	}
+	if err := internal.SetSnykAPIBaseURL(*f.baseURL); err != nil {
+		return err
+	}
+	if err := internal.SetAPIPathPrefix(*f.apiPathPrefix); err != nil {
+		return fmt.Errorf("--api-path-prefix: %w", err)
+	}
+	if err := internal.SetRESTAccept(os.Getenv("SNYK_API_ACCEPT")); err != nil {
+		return fmt.Errorf("SNYK_API_ACCEPT: %w", err)
+	}
+	return nil
+}
+
+// warnDeprecatedAPIVersion prints the API's deprecation notice for the
+// requested --api-version, if any response carried one. Commands defer it
+// right after configureAPI so it appears once, after the run's own output.
+func warnDeprecatedAPIVersion() {
+	if w := internal.DeprecationWarning(); w != "" {
+		fmt.Fprintf(os.Stderr, "\n%s\n", w)
+	}
+}
+
 // orgLabel returns a human-readable label for an org (name + slug or just ID).
 func orgLabel(o internal.Org) string {
 	if o.Name != "" {
@@ -57,15 +224,28 @@ type SnykAPI interface {
 	FetchTargets(ctx context.Context, orgID string) ([]internal.APITarget, error)
 	DeleteProject(ctx context.Context, orgID, projectID string) error
 	DeleteTarget(ctx context.Context, orgID, targetID string) error
+	FetchUser(ctx context.Context) (internal.User, error)
+	FetchGroups(ctx context.Context) ([]internal.Group, error)
+	CheckGroupAccess(ctx context.Context, groupID string) error
+	CheckOrgAccess(ctx context.Context, orgID string) error
+	IntegrationActive(ctx context.Context, orgID, integrationID string) (bool, error)
 }
 
 // snykAPIClient is the real Snyk API implementation using the internal package.
 type snykAPIClient struct {
-	client *http.Client
-	token  string
+	client       *http.Client
+	token        string
+	fastOrgFetch bool // try the REST group orgs endpoint before v1
 }
 
 func (c *snykAPIClient) FetchOrgs(ctx context.Context, groupID string) ([]internal.Org, error) {
+	if c.fastOrgFetch {
+		orgs, err := internal.FetchOrgsREST(ctx, c.client, c.token, groupID)
+		if err == nil {
+			return orgs, nil
+		}
+		log.Printf("WARNING: REST org fetch failed (%v); falling back to the v1 API", err)
+	}
 	return internal.FetchOrgs(ctx, c.client, c.token, groupID)
 }
 
@@ -89,26 +269,130 @@ func (c *snykAPIClient) DeleteTarget(ctx context.Context, orgID, targetID string
 	return internal.DeleteTarget(ctx, c.client, c.token, orgID, targetID)
 }
 
+func (c *snykAPIClient) FetchUser(ctx context.Context) (internal.User, error) {
+	return internal.FetchUser(ctx, c.client, c.token)
+}
+
+func (c *snykAPIClient) FetchGroups(ctx context.Context) ([]internal.Group, error) {
+	return internal.FetchGroups(ctx, c.client, c.token)
+}
+
+func (c *snykAPIClient) CheckGroupAccess(ctx context.Context, groupID string) error {
+	return internal.CheckGroupAccess(ctx, c.client, c.token, groupID)
+}
+
+func (c *snykAPIClient) CheckOrgAccess(ctx context.Context, orgID string) error {
+	return internal.CheckOrgAccess(ctx, c.client, c.token, orgID)
+}
+
+func (c *snykAPIClient) IntegrationActive(ctx context.Context, orgID, integrationID string) (bool, error) {
+	return internal.IntegrationActive(ctx, c.client, c.token, orgID, integrationID)
+}
+
 // newSnykAPI returns a real SnykAPI implementation for production use.
-func newSnykAPI(client *http.Client, token string) SnykAPI {
-	return &snykAPIClient{client: client, token: token}
+// fastOrgFetch lists group orgs via the REST API, falling back to v1 on error.
+func newSnykAPI(client *http.Client, token string, fastOrgFetch bool) SnykAPI {
+	return &snykAPIClient{client: client, token: token, fastOrgFetch: fastOrgFetch}
 }
 
 // resolveOrgs returns the list of orgs to process: either all orgs in the group or a single-org slice.
-func resolveOrgs(ctx context.Context, api SnykAPI, groupID, orgID string) ([]internal.Org, error) {
+// When orgName is set (requires groupID), only the group org matching it by name or slug is returned.
+func resolveOrgs(ctx context.Context, api SnykAPI, groupID, orgID, orgName string) ([]internal.Org, error) {
+	if orgName != "" && groupID == "" {
+		return nil, fmt.Errorf("--org-name requires --groupId")
+	}
 	if groupID != "" {
 		log.Printf("Fetching organizations for group %s...", groupID)
-		return api.FetchOrgs(ctx, groupID)
+		orgs, err := api.FetchOrgs(ctx, groupID)
+		if err != nil {
+			return nil, err
+		}
+		if len(orgs) == 0 {
+			return nil, fmt.Errorf("no organizations found for group %s; check the group ID and that the token can read the group", groupID)
+		}
+		if orgName == "" {
+			return orgs, nil
+		}
+		org, err := findOrgByName(orgs, orgName)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Resolved --org-name %q to org %s", orgName, org.ID)
+		return []internal.Org{org}, nil
+	}
+	// A mistyped --orgId, or one the token cannot read, would otherwise fail
+	// later as an org error and report "0 targets". Only a definite answer
+	// (400, 401, 403, or 404) stops here; any other error is left to the
+	// org's own fetch to report or retry.
+	if err := api.CheckOrgAccess(ctx, orgID); isOrgRejected(err) {
+		return nil, fmt.Errorf("organization %s not found or not readable (%w); check the org ID and token permissions", orgID, err)
+	} else if err != nil {
+		log.Printf("WARNING: Could not check access to org %s (%v); continuing", orgID, err)
 	}
 	return []internal.Org{{ID: orgID}}, nil
 }
 
+// isOrgRejected reports whether err is the API definitely refusing an org
+// ID: 400 (malformed), 401 or 403 (no access), or 404 (no such org).
+func isOrgRejected(err error) bool {
+	var se *internal.StatusError
+	if errors.As(err, &se) {
+		switch se.StatusCode {
+		case 400, 401, 403, 404:
+			return true
+		}
+		return false
+	}
+	return errors.Is(err, internal.ErrUnauthorized)
+}
+
+// findOrgByName returns the single org whose Name or Slug matches name
+// case-insensitively. It errors when nothing matches or more than one org does.
+func findOrgByName(orgs []internal.Org, name string) (internal.Org, error) {
+	var matches []internal.Org
+	for _, o := range orgs {
+		if strings.EqualFold(o.Name, name) || strings.EqualFold(o.Slug, name) {
+			matches = append(matches, o)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return internal.Org{}, fmt.Errorf("no org named %q in group", name)
+	case 1:
+		return matches[0], nil
+	default:
+		labels := make([]string, len(matches))
+		for i, o := range matches {
+			labels[i] = fmt.Sprintf("%s [%s]", orgLabel(o), o.ID)
+		}
+		return internal.Org{}, fmt.Errorf("org name %q is ambiguous: %s", name, strings.Join(labels, ", "))
+	}
+}
+
 func main() {
 	if len(os.Args) > 1 {
 		switch os.Args[1] {
 		case "dedup":
 			runDedup(os.Args[2:])
 			return
+		case "preflight":
+			runPreflight(os.Args[2:])
+			return
+		case "diff":
+			runDiff(os.Args[2:])
+			return
+		case "whoami":
+			runWhoami(os.Args[2:])
+			return
+		case "orgs":
+			runOrgs(os.Args[2:])
+			return
+		case "reconcile":
+			runReconcile(os.Args[2:])
+			return
+		case "convert":
+			runConvert(os.Args[2:])
+			return
 		case "--version", "-version":
 			printVersion()
 			return
@@ -117,6 +401,71 @@ func main() {
 	runRefresh(os.Args[1:])
 }
 
+// readOrgFile reads org IDs from path, one per line. Blank lines and lines
+// starting with # are ignored, so a --retry-failed-file can be passed back in
+// as-is.
+func readOrgFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read org file: %w", err)
+	}
+	var ids []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids = append(ids, line)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("org file %s lists no org IDs", path)
+	}
+	return ids, nil
+}
+
+// filterOrgsByID keeps the orgs whose ID is in ids, in their original order,
+// and returns the IDs that matched no org.
+func filterOrgsByID(orgs []internal.Org, ids []string) (kept []internal.Org, missing []string) {
+	want := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+	found := make(map[string]bool, len(ids))
+	for _, o := range orgs {
+		if want[o.ID] {
+			kept = append(kept, o)
+			found[o.ID] = true
+		}
+	}
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return kept, missing
+}
+
+// resumeFromOrg drops the orgs listed before the org with ID id, keeping that
+// org and the rest in their original order (--resume-from-org). It fails if
+// no org has that ID.
+func resumeFromOrg(orgs []internal.Org, id string) ([]internal.Org, error) {
+	for i, o := range orgs {
+		if o.ID == id {
+			return orgs[i:], nil
+		}
+	}
+	return nil, fmt.Errorf("org %s is not in the org list", id)
+}
+
+// shuffleOrgs returns a copy of orgs in random order (--shuffle-orgs), so a
+// cluster of very large orgs at the front of the group listing does not hold
+// every concurrency slot while small orgs wait behind it.
+func shuffleOrgs(orgs []internal.Org, r *rand.Rand) []internal.Org {
+	shuffled := append([]internal.Org(nil), orgs...)
+	r.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	return shuffled
+}
+
 // sanitizeOutputPath validates and resolves the output file path to prevent
 // path traversal attacks. It ensures the resolved path stays within the
 // current working directory or is an absolute path without traversal.
@@ -4,13 +4,23 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/snyk-playground/snyk-target-export/internal"
 )
@@ -88,6 +98,7 @@ func loadMockProjectsFromTestdata(t *testing.T) []internal.Project {
 		name, _ := attrs["name"].(string)
 		origin, _ := attrs["origin"].(string)
 		created, _ := attrs["created"].(string)
+		status, _ := attrs["status"].(string)
 		targetRef, _ := attrs["targetReference"].(string)
 		if targetRef == "" {
 			targetRef, _ = attrs["target_reference"].(string)
@@ -112,6 +123,7 @@ func loadMockProjectsFromTestdata(t *testing.T) []internal.Project {
 			TargetReference: targetRef,
 			Created:         created,
 			TargetID:        targetID,
+			Status:          status,
 		})
 	}
 	return projects
@@ -214,6 +226,80 @@ func TestOrgLabel(t *testing.T) {
 	}
 }
 
+// TestApplyEnvDefaults checks flag > env > default precedence.
+func TestApplyEnvDefaults(t *testing.T) {
+	t.Setenv("SNYK_GROUP_ID", "env-group")
+	t.Setenv("SNYK_CONCURRENCY", "9")
+	t.Setenv("SNYK_OUTPUT", "")
+	t.Setenv("SNYK_INTEGRATION_TYPE", "github,azure-repos")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	groupID := fs.String("groupId", "", "")
+	concurrency := fs.Int("concurrency", 5, "")
+	output := fs.String("output", "export-targets.json", "")
+	var types stringList
+	fs.Var(&types, "integrationType", "")
+	if err := fs.Parse([]string{"--concurrency=3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyEnvDefaults(fs); err != nil {
+		t.Fatalf("applyEnvDefaults: %v", err)
+	}
+	if *groupID != "env-group" {
+		t.Errorf("groupId = %q, want env value", *groupID)
+	}
+	if *concurrency != 3 {
+		t.Errorf("concurrency = %d, want explicit flag 3 over env", *concurrency)
+	}
+	if *output != "export-targets.json" {
+		t.Errorf("output = %q, want default when env empty", *output)
+	}
+	if len(types) != 2 {
+		t.Errorf("integrationType = %v, want 2 values from env", types)
+	}
+
+	// An explicit --orgId suppresses SNYK_GROUP_ID so the pair stays exclusive.
+	fs3 := flag.NewFlagSet("test", flag.ContinueOnError)
+	groupID3 := fs3.String("groupId", "", "")
+	fs3.String("orgId", "", "")
+	_ = fs3.Parse([]string{"--orgId=o-1"})
+	if err := applyEnvDefaults(fs3); err != nil {
+		t.Fatal(err)
+	}
+	if *groupID3 != "" {
+		t.Errorf("groupId = %q, want empty when --orgId is explicit", *groupID3)
+	}
+
+	t.Setenv("SNYK_CONCURRENCY", "lots")
+	fs2 := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs2.Int("concurrency", 5, "")
+	_ = fs2.Parse(nil)
+	if err := applyEnvDefaults(fs2); err == nil {
+		t.Error("invalid SNYK_CONCURRENCY: want error")
+	}
+}
+
+// TestStringList checks that the repeatable flag value accepts both repeated
+// and comma-separated forms and ignores empty entries.
+func TestStringList(t *testing.T) {
+	var s stringList
+	for _, v := range []string{"github,bitbucket-cloud", " azure-repos ", ","} {
+		if err := s.Set(v); err != nil {
+			t.Fatalf("Set(%q): %v", v, err)
+		}
+	}
+	if got := s.String(); got != "github,bitbucket-cloud,azure-repos" {
+		t.Errorf("String() = %q", got)
+	}
+	set := s.set()
+	if len(set) != 3 || !set["azure-repos"] {
+		t.Errorf("set() = %v", set)
+	}
+	if (stringList{}).set() != nil {
+		t.Error("empty list set() should be nil")
+	}
+}
+
 // --- Refresh: projectsToImportTargets ---
 
 // TestProjectsToImportTargets checks that projects are filtered (SCM, integration type),
@@ -226,12 +312,12 @@ func TestProjectsToImportTargets(t *testing.T) {
 		projects := []internal.Project{
 			{Name: "owner/repo", Origin: "gitlab", Branch: "main"},
 		}
-		targets, gitlabCount := projectsToImportTargets(org, projects, integrations, "")
+		targets, counts := projectsToImportTargets(org, projects, integrations, refreshOptions{})
 		if len(targets) != 0 {
 			t.Errorf("got %d targets, want 0 (gitlab should be skipped)", len(targets))
 		}
-		if gitlabCount != 1 {
-			t.Errorf("gitlabCount = %d, want 1", gitlabCount)
+		if counts.gitlab != 1 {
+			t.Errorf("counts.gitlab = %d, want 1", counts.gitlab)
 		}
 	})
 
@@ -239,9 +325,9 @@ func TestProjectsToImportTargets(t *testing.T) {
 		projects := []internal.Project{
 			{Name: "owner/repo:package.json", Origin: "github", Branch: "main"},
 		}
-		targets, gitlabCount := projectsToImportTargets(org, projects, integrations, "")
-		if gitlabCount != 0 {
-			t.Errorf("gitlabCount = %d, want 0", gitlabCount)
+		targets, counts := projectsToImportTargets(org, projects, integrations, refreshOptions{})
+		if counts.gitlab != 0 {
+			t.Errorf("counts.gitlab = %d, want 0", counts.gitlab)
 		}
 		if len(targets) != 1 {
 			t.Fatalf("got %d targets, want 1", len(targets))
@@ -260,7 +346,7 @@ func TestProjectsToImportTargets(t *testing.T) {
 			{Name: "a/b", Origin: "github", Branch: "main"},
 			{Name: "c/d", Origin: "bitbucket-cloud", Branch: "main"},
 		}
-		targets, _ := projectsToImportTargets(org, projects, integrations, "github")
+		targets, _ := projectsToImportTargets(org, projects, integrations, refreshOptions{integrationTypes: map[string]bool{"github": true}})
 		if len(targets) != 1 {
 			t.Errorf("filter integrationType=github: got %d targets, want 1", len(targets))
 		}
@@ -269,18 +355,433 @@ func TestProjectsToImportTargets(t *testing.T) {
 		}
 	})
 
+	t.Run("emitFiles collapses manifests into one target", func(t *testing.T) {
+		projects := []internal.Project{
+			{Name: "owner/repo:package.json", Origin: "github", Branch: "main"},
+			{Name: "owner/repo:api/go.mod", Origin: "github", Branch: "main"},
+			{Name: "owner/repo:package.json", Origin: "github", Branch: "main"},
+		}
+		targets, _ := projectsToImportTargets(org, projects, integrations, refreshOptions{emitFiles: true})
+		if len(targets) != 1 {
+			t.Fatalf("got %d targets, want 1", len(targets))
+		}
+		files := targets[0].Files
+		if len(files) != 2 || files[0].Path != "package.json" || files[1].Path != "api/go.mod" {
+			t.Errorf("files = %+v, want [package.json api/go.mod]", files)
+		}
+	})
+
+	t.Run("emitFiles omits files when a project covers the whole repo", func(t *testing.T) {
+		projects := []internal.Project{
+			{Name: "owner/repo:package.json", Origin: "github", Branch: "main"},
+			{Name: "owner/repo", Origin: "github", Branch: "main"},
+			{Name: "owner/repo:go.mod", Origin: "github", Branch: "main"},
+		}
+		targets, _ := projectsToImportTargets(org, projects, integrations, refreshOptions{emitFiles: true})
+		if len(targets) != 1 || targets[0].Files != nil {
+			t.Errorf("targets = %+v, want one target without files", targets)
+		}
+	})
+
+	t.Run("countManifests records projects per target", func(t *testing.T) {
+		projects := []internal.Project{
+			{Name: "owner/repo:package.json", Origin: "github", Branch: "main"},
+			{Name: "owner/repo:api/go.mod", Origin: "github", Branch: "main"},
+			{Name: "owner/repo:web/package.json", Origin: "github", Branch: "main"},
+			{Name: "owner/small", Origin: "github", Branch: "main"},
+		}
+		targets, _ := projectsToImportTargets(org, projects, integrations, refreshOptions{countManifests: true})
+		if len(targets) != 2 || targets[0].ProjectCount != 3 || targets[1].ProjectCount != 1 {
+			t.Errorf("targets = %+v, want projectCount 3 and 1", targets)
+		}
+		targets, _ = projectsToImportTargets(org, projects, integrations, refreshOptions{})
+		if targets[0].ProjectCount != 0 {
+			t.Errorf("projectCount = %d without countManifests, want 0", targets[0].ProjectCount)
+		}
+	})
+
+	t.Run("withProvenance records the first source project", func(t *testing.T) {
+		projects := []internal.Project{
+			{ID: "p1", Name: "owner/repo:package.json", Origin: "github", Branch: "main"},
+			{ID: "p2", Name: "owner/repo:go.mod", Origin: "github", Branch: "main"},
+		}
+		targets, _ := projectsToImportTargets(org, projects, integrations, refreshOptions{withProvenance: true})
+		if len(targets) != 1 || targets[0].ProjectID != "p1" || targets[0].ProjectName != "owner/repo:package.json" {
+			t.Errorf("targets = %+v, want provenance from p1", targets)
+		}
+		targets, _ = projectsToImportTargets(org, projects, integrations, refreshOptions{})
+		data, _ := json.Marshal(targets[0])
+		if strings.Contains(string(data), "projectId") || strings.Contains(string(data), "projectName") {
+			t.Errorf("provenance written without withProvenance: %s", data)
+		}
+	})
+
+	t.Run("unparseable names are counted", func(t *testing.T) {
+		projects := []internal.Project{
+			{ID: "p1", Name: "", Origin: "github"},
+			{ID: "p2", Name: "no-slash", Origin: "github"},
+			{ID: "p3", Name: "owner/repo", Origin: "github"},
+		}
+		targets, counts := projectsToImportTargets(org, projects, integrations, refreshOptions{})
+		if len(targets) != 1 {
+			t.Errorf("targets = %+v, want 1", targets)
+		}
+		if len(counts.unparseable) != 2 || counts.unparseable[1] != (unparseableProject{OrgID: org.ID, ProjectID: "p2", Name: "no-slash", Origin: "github"}) {
+			t.Errorf("unparseable = %+v, want p1 and p2", counts.unparseable)
+		}
+	})
+
+	t.Run("onlyOrigins keeps matching origins and counts the rest", func(t *testing.T) {
+		ints := map[string]string{"github": "int-github", "azure-repos": "int-az", "bitbucket-connect-app": "int-bb"}
+		projects := []internal.Project{
+			{Name: "owner/a", Origin: "azure-repos"},
+			{Name: "owner/b", Origin: "github"},
+			{Name: "owner/c", Origin: "cli"},
+			{Name: "owner/d", Origin: "bitbucket-connect-app"},
+		}
+		opts := refreshOptions{onlyOrigins: originSet(stringList{"azure-repos", "bitbucket-cloud-app"})}
+		targets, counts := projectsToImportTargets(org, projects, ints, opts)
+		if len(targets) != 2 || targets[0].IntegrationID != "int-az" || targets[1].IntegrationID != "int-bb" {
+			t.Errorf("targets = %+v, want azure-repos and bitbucket (alias) only", targets)
+		}
+		if counts.otherOrigin != 2 || counts.nonSCM != 0 {
+			t.Errorf("otherOrigin = %d, nonSCM = %d, want 2 and 0", counts.otherOrigin, counts.nonSCM)
+		}
+		if originSet(nil) != nil {
+			t.Error("originSet(nil) should be nil so every origin is kept")
+		}
+	})
+
+	t.Run("recordMapping maps every converted project", func(t *testing.T) {
+		projects := []internal.Project{
+			{ID: "p1", Name: "owner/repo:package.json", Origin: "github", Branch: "main"},
+			{ID: "p2", Name: "owner/repo:go.mod", Origin: "github", Branch: "main"},
+			{ID: "p3", Name: "owner/repo", Origin: "gitlab"},
+		}
+		targets, counts := projectsToImportTargets(org, projects, integrations, refreshOptions{recordMapping: true})
+		if len(targets) != 1 || len(counts.mapping) != 2 {
+			t.Fatalf("targets = %d, mapping = %v, want 1 target and 2 mapped projects", len(targets), counts.mapping)
+		}
+		want := projectMapping{OrgID: org.ID, IntegrationID: "int-github", Target: targets[0].Target}
+		if counts.mapping["p1"] != want || counts.mapping["p2"] != want {
+			t.Errorf("mapping = %+v, want p1 and p2 -> %+v", counts.mapping, want)
+		}
+		_, counts = projectsToImportTargets(org, projects, integrations, refreshOptions{})
+		if counts.mapping != nil {
+			t.Errorf("mapping recorded without recordMapping: %v", counts.mapping)
+		}
+	})
+
+	t.Run("collapseEquivalent keeps the canonical integration's target", func(t *testing.T) {
+		integrations := map[string]string{"bitbucket-cloud": "int-bb", "bitbucket-connect-app": "int-bbapp", "github": "int-gh"}
+		projects := []internal.Project{
+			{ID: "p1", Name: "team/repo:package.json", Origin: "bitbucket-cloud", Branch: "main"},
+			{ID: "p2", Name: "Team/Repo:go.mod", Origin: "bitbucket-cloud-app", Branch: "main"},
+			{ID: "p3", Name: "team/other", Origin: "bitbucket-cloud", Branch: "main"},
+			{ID: "p4", Name: "team/repo", Origin: "bitbucket-cloud", Branch: "dev"},
+			{ID: "p5", Name: "a/b", Origin: "github", Branch: "main"},
+		}
+		opts := refreshOptions{collapseEquivalent: true, countManifests: true, recordMapping: true}
+		targets, counts := projectsToImportTargets(org, projects, integrations, opts)
+		if len(targets) != 4 || counts.collapsed != 1 {
+			t.Fatalf("targets = %+v, collapsed = %d, want 4 targets and 1 collapsed", targets, counts.collapsed)
+		}
+		if targets[0].Target.Name != "Repo" || targets[0].IntegrationID != "int-bbapp" || targets[0].ProjectCount != 2 {
+			t.Errorf("kept target = %+v, want the bitbucket-connect-app target with both projects counted", targets[0])
+		}
+		if counts.byOrigin["bitbucket-cloud"] != 2 || counts.byOrigin["bitbucket-connect-app"] != 1 {
+			t.Errorf("byOrigin = %v", counts.byOrigin)
+		}
+		if m := counts.mapping["p1"]; m.IntegrationID != "int-bbapp" {
+			t.Errorf("mapping[p1] = %+v, want it moved to int-bbapp", m)
+		}
+
+		targets, counts = projectsToImportTargets(org, projects, integrations, refreshOptions{})
+		if len(targets) != 5 || counts.collapsed != 0 {
+			t.Errorf("without collapseEquivalent: %d targets, collapsed %d, want 5 and 0", len(targets), counts.collapsed)
+		}
+	})
+
+	t.Run("validate drops malformed integration IDs", func(t *testing.T) {
+		integrations := map[string]string{
+			"github":                "3f1c2a4e-9b7d-4c1e-8a2f-0d5e6b7c8a91",
+			"bitbucket-connect-app": "[object Object]",
+		}
+		projects := []internal.Project{
+			{ID: "p1", Name: "o/a", Origin: "github", Branch: "main"},
+			{ID: "p2", Name: "o/b", Origin: "bitbucket-connect-app", Branch: "main"},
+		}
+		opts := refreshOptions{validate: true, recordMapping: true}
+		targets, counts := projectsToImportTargets(org, projects, integrations, opts)
+		if len(targets) != 1 || targets[0].Target.Name != "a" || counts.invalidID != 1 {
+			t.Fatalf("targets = %+v, invalidID = %d, want only o/a kept", targets, counts.invalidID)
+		}
+		if counts.byOrigin["bitbucket-connect-app"] != 0 || counts.byOrigin["github"] != 1 {
+			t.Errorf("byOrigin = %v", counts.byOrigin)
+		}
+		if _, ok := counts.mapping["p2"]; ok || len(counts.mapping) != 1 {
+			t.Errorf("mapping = %v, want the dropped project removed", counts.mapping)
+		}
+
+		targets, _ = projectsToImportTargets(org, projects, integrations, refreshOptions{})
+		if len(targets) != 2 {
+			t.Errorf("without validate: %d targets, want 2", len(targets))
+		}
+	})
+
+	t.Run("branch filters", func(t *testing.T) {
+		projects := []internal.Project{
+			{ID: "p1", Name: "o/a", Origin: "github", Branch: "main"},
+			{ID: "p2", Name: "o/b", Origin: "github", Branch: "feature/x"},
+			{ID: "p3", Name: "o/c", Origin: "github", TargetReference: "master"},
+			{ID: "p4", Name: "o/d", Origin: "github"},
+		}
+		names := func(targets []internal.ImportTarget) string {
+			var n []string
+			for _, tg := range targets {
+				n = append(n, tg.Target.Name)
+			}
+			return strings.Join(n, ",")
+		}
+		for _, tc := range []struct {
+			name  string
+			opts  refreshOptions
+			want  string
+			count int
+		}{
+			{"none", refreshOptions{}, "a,b,c,d", 0},
+			{"branch", refreshOptions{branches: map[string]bool{"main": true, "master": true}}, "a,c,d", 1},
+			{"exclude", refreshOptions{excludeBranches: map[string]bool{"feature/x": true}}, "a,c,d", 1},
+			{"require", refreshOptions{branches: map[string]bool{"main": true}, requireBranch: true}, "a", 3},
+		} {
+			targets, counts := projectsToImportTargets(org, projects, integrations, tc.opts)
+			if got := names(targets); got != tc.want || counts.branch != tc.count {
+				t.Errorf("%s: targets %s, branch count %d; want %s, %d", tc.name, got, counts.branch, tc.want, tc.count)
+			}
+		}
+	})
+
+	t.Run("files not emitted by default", func(t *testing.T) {
+		projects := []internal.Project{
+			{Name: "owner/repo:package.json", Origin: "github", Branch: "main"},
+		}
+		targets, _ := projectsToImportTargets(org, projects, integrations, refreshOptions{})
+		if len(targets) != 1 || targets[0].Files != nil {
+			t.Errorf("targets = %+v, want one target without files", targets)
+		}
+	})
+
+	t.Run("multiple integration types", func(t *testing.T) {
+		integrations := map[string]string{"github": "int-github", "bitbucket-cloud": "int-bb", "bitbucket-connect-app": "int-bbapp"}
+		projects := []internal.Project{
+			{Name: "a/b", Origin: "github", Branch: "main"},
+			{Name: "c/d", Origin: "bitbucket-cloud", Branch: "main"},
+			{Name: "e/f", Origin: "bitbucket-cloud-app", Branch: "main"},
+		}
+		opts := refreshOptions{integrationTypes: map[string]bool{"github": true, "bitbucket-connect-app": true}}
+		targets, _ := projectsToImportTargets(org, projects, integrations, opts)
+		if len(targets) != 2 {
+			t.Fatalf("got %d targets, want 2 (github and bitbucket-cloud-app via mapped key)", len(targets))
+		}
+		if targets[0].Target.Owner != "a" || targets[1].Target.Owner != "e" {
+			t.Errorf("targets = %+v", targets)
+		}
+	})
+
+	t.Run("per-origin and skip counts", func(t *testing.T) {
+		integrations := map[string]string{"github": "int-github", "bitbucket-connect-app": "int-bbapp"}
+		projects := []internal.Project{
+			{Name: "a/b:package.json", Origin: "github", Branch: "main"},
+			{Name: "a/b:go.mod", Origin: "github", Branch: "main"},
+			{Name: "a/c", Origin: "github", Branch: "main"},
+			{Name: "w/r", Origin: "bitbucket-cloud-app", Branch: "main"},
+			{Name: "x/y", Origin: "azure-repos", Branch: "main"},
+			{Name: "img:latest", Origin: "docker-hub"},
+			{Name: "g/h", Origin: "gitlab"},
+		}
+		_, counts := projectsToImportTargets(org, projects, integrations, refreshOptions{})
+		if counts.byOrigin["github"] != 2 || counts.byOrigin["bitbucket-connect-app"] != 1 {
+			t.Errorf("byOrigin = %v, want github:2 bitbucket-connect-app:1", counts.byOrigin)
+		}
+		if counts.gitlab != 1 || counts.nonSCM != 1 || counts.noIntegration != 1 {
+			t.Errorf("skips = %+v, want 1 each", counts)
+		}
+	})
+
+	t.Run("skipImporting excludes in-progress projects", func(t *testing.T) {
+		projects := []internal.Project{
+			{Name: "owner/repo", Origin: "github", Branch: "main", Status: "active"},
+			{Name: "owner/new", Origin: "github", Branch: "main", Status: "importing"},
+		}
+		targets, counts := projectsToImportTargets(org, projects, integrations, refreshOptions{skipImporting: true})
+		if len(targets) != 1 || counts.importing != 1 {
+			t.Errorf("targets=%d importing=%d, want 1 and 1", len(targets), counts.importing)
+		}
+		targets, _ = projectsToImportTargets(org, projects, integrations, refreshOptions{})
+		if len(targets) != 2 {
+			t.Errorf("without skipImporting: got %d targets, want 2", len(targets))
+		}
+	})
+
+	t.Run("skipBroken excludes inactive projects", func(t *testing.T) {
+		projects := []internal.Project{
+			{Name: "owner/repo", Origin: "github", Branch: "main", Status: "active"},
+			{Name: "owner/gone", Origin: "github", Branch: "main", Status: "Inactive"},
+		}
+		targets, counts := projectsToImportTargets(org, projects, integrations, refreshOptions{skipBroken: true})
+		if len(targets) != 1 || counts.broken != 1 {
+			t.Errorf("targets=%d broken=%d, want 1 and 1", len(targets), counts.broken)
+		}
+		targets, counts = projectsToImportTargets(org, projects, integrations, refreshOptions{})
+		if len(targets) != 2 || counts.broken != 0 {
+			t.Errorf("without skipBroken: targets=%d broken=%d, want 2 and 0", len(targets), counts.broken)
+		}
+	})
+
+	t.Run("rewriteOwner remaps owner and merges targets", func(t *testing.T) {
+		projects := []internal.Project{
+			{Name: "Old-Org/repo:package.json", Origin: "github", Branch: "main"},
+			{Name: "new-org/repo:go.mod", Origin: "github", Branch: "main"},
+			{Name: "old-org/other", Origin: "github", Branch: "main"},
+			{Name: "someone/else", Origin: "github", Branch: "main"},
+		}
+		opts := refreshOptions{ownerRewrites: map[string]string{"old-org": "new-org"}}
+		targets, counts := projectsToImportTargets(org, projects, integrations, opts)
+		if len(targets) != 3 {
+			t.Fatalf("got %d targets, want 3 (renamed repo merges with new-org/repo)", len(targets))
+		}
+		if targets[0].Target.Owner != "new-org" || targets[1].Target.Owner != "new-org" || targets[2].Target.Owner != "someone" {
+			t.Errorf("owners = %s, %s, %s", targets[0].Target.Owner, targets[1].Target.Owner, targets[2].Target.Owner)
+		}
+		if counts.ownerRewrites != 2 {
+			t.Errorf("ownerRewrites = %d, want 2", counts.ownerRewrites)
+		}
+	})
+
+	t.Run("exclude by project ID and target ID", func(t *testing.T) {
+		projects := []internal.Project{
+			{ID: "p1", Name: "owner/bad-project", Origin: "github", Branch: "main"},
+			{ID: "p2", Name: "owner/bad-target:package.json", Origin: "github", Branch: "main"},
+			{ID: "p3", Name: "owner/bad-target:go.mod", Origin: "github", Branch: "main"},
+			{ID: "p4", Name: "owner/good", Origin: "github", Branch: "main"},
+		}
+		opts := refreshOptions{
+			excludeProjectIDs: map[string]bool{"p1": true},
+			excludeTargetIDs:  map[string]bool{"org-1:int-github:bad-target:owner:main": true},
+		}
+		targets, counts := projectsToImportTargets(org, projects, integrations, opts)
+		if len(targets) != 1 || targets[0].Target.Name != "good" {
+			t.Errorf("targets = %+v, want only owner/good", targets)
+		}
+		if counts.excluded != 3 {
+			t.Errorf("counts.excluded = %d, want 3", counts.excluded)
+		}
+	})
+
+	t.Run("legacy before keeps only older projects", func(t *testing.T) {
+		cutoff := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+		projects := []internal.Project{
+			{ID: "p1", Name: "owner/old", Origin: "github", Branch: "main", Created: "2021-03-04T05:06:07.000Z"},
+			{ID: "p2", Name: "owner/new", Origin: "github", Branch: "main", Created: "2024-01-01T00:00:00Z"},
+			{ID: "p3", Name: "owner/edge", Origin: "github", Branch: "main", Created: "2023-06-01T00:00:00Z"},
+			{ID: "p4", Name: "owner/undated", Origin: "github", Branch: "main"},
+		}
+		targets, counts := projectsToImportTargets(org, projects, integrations, refreshOptions{legacyBefore: cutoff})
+		if len(targets) != 1 || targets[0].Target.Name != "old" {
+			t.Errorf("targets = %+v, want only owner/old", targets)
+		}
+		if counts.legacy != 1 || counts.notLegacy != 3 {
+			t.Errorf("legacy=%d notLegacy=%d, want 1 and 3", counts.legacy, counts.notLegacy)
+		}
+	})
+
+	t.Run("include file keeps only listed targets", func(t *testing.T) {
+		projects := []internal.Project{
+			{ID: "p1", Name: "owner/by-id", Origin: "github", Branch: "main"},
+			{ID: "p2", Name: "owner/by-id", Origin: "github", Branch: "dev"},
+			{ID: "p3", Name: "Owner/By-Repo:go.mod", Origin: "github", Branch: "dev"},
+			{ID: "p4", Name: "owner/other", Origin: "github", Branch: "main"},
+		}
+		opts := refreshOptions{include: &targetAllowlist{
+			ids:   map[string]bool{"org-1:int-github:by-id:owner:main": true},
+			repos: map[string]bool{"owner/by-repo": true},
+		}}
+		targets, counts := projectsToImportTargets(org, projects, integrations, opts)
+		var got []string
+		for _, tgt := range targets {
+			got = append(got, tgt.Target.Name+"@"+tgt.Target.Branch)
+		}
+		if strings.Join(got, ",") != "by-id@main,By-Repo@dev" {
+			t.Errorf("targets = %v, want [by-id@main By-Repo@dev]", got)
+		}
+		if counts.notIncluded != 2 {
+			t.Errorf("counts.notIncluded = %d, want 2", counts.notIncluded)
+		}
+	})
+
+	t.Run("explain logs the integration lookup", func(t *testing.T) {
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		defer log.SetOutput(os.Stderr)
+		integrations := map[string]string{"bitbucket-connect-app": "int-bbapp"}
+		projects := []internal.Project{
+			{ID: "p1", Name: "w/r", Origin: "bitbucket-cloud-app", Branch: "main"},
+			{ID: "p2", Name: "a/b", Origin: "github", Branch: "main"},
+		}
+		projectsToImportTargets(org, projects, integrations, refreshOptions{explain: true})
+		got := buf.String()
+		if !strings.Contains(got, "project p1 (w/r) origin=bitbucket-cloud-app key=bitbucket-connect-app integration=int-bbapp -> target org-1:int-bbapp:") {
+			t.Errorf("emitted target not explained:\n%s", got)
+		}
+		if !strings.Contains(got, "project p2 (a/b) origin=github key=github: no integration with that key, skipped") {
+			t.Errorf("skipped project not explained:\n%s", got)
+		}
+	})
+
 	t.Run("no integration for origin skipped", func(t *testing.T) {
 		integrations := map[string]string{"github": "int-github"}
 		projects := []internal.Project{
 			{Name: "owner/repo", Origin: "bitbucket-cloud", Branch: "main"},
 		}
-		targets, _ := projectsToImportTargets(org, projects, integrations, "")
+		targets, _ := projectsToImportTargets(org, projects, integrations, refreshOptions{})
 		if len(targets) != 0 {
 			t.Errorf("project with no matching integration should be skipped: got %d targets", len(targets))
 		}
 	})
 }
 
+func TestParseOwnerRewrites(t *testing.T) {
+	got, err := parseOwnerRewrites([]string{"Old-Org=new-org", " a = b "})
+	if err != nil {
+		t.Fatalf("parseOwnerRewrites: %v", err)
+	}
+	if len(got) != 2 || got["old-org"] != "new-org" || got["a"] != "b" {
+		t.Errorf("rewrites = %v", got)
+	}
+	for _, bad := range [][]string{{"no-equals"}, {"=new"}, {"old="}, {"a=b", "A=c"}} {
+		if _, err := parseOwnerRewrites(bad); err == nil {
+			t.Errorf("parseOwnerRewrites(%q): want error", bad)
+		}
+	}
+	if got, err := parseOwnerRewrites(nil); got != nil || err != nil {
+		t.Errorf("parseOwnerRewrites(nil) = %v, %v; want nil, nil", got, err)
+	}
+}
+
+func TestRefreshCountsAddAndFormat(t *testing.T) {
+	var total refreshCounts
+	total.add(refreshCounts{byOrigin: map[string]int{"github": 2, "azure-repos": 1}, gitlab: 1})
+	total.add(refreshCounts{byOrigin: map[string]int{"github": 3, "bitbucket-connect-app": 4}, nonSCM: 2, importing: 3})
+	if total.gitlab != 1 || total.nonSCM != 2 || total.importing != 3 {
+		t.Errorf("skips = %+v", total)
+	}
+	got := formatOriginCounts(total.byOrigin)
+	want := "github: 5, bitbucket-connect-app: 4, azure-repos: 1"
+	if got != want {
+		t.Errorf("formatOriginCounts = %q, want %q", got, want)
+	}
+}
+
 // --- Mock SnykAPI for unit testing (no real API) ---
 
 // mockSnykAPI implements SnykAPI with canned responses. Set Err fields to simulate API errors.
@@ -296,6 +797,16 @@ type mockSnykAPI struct {
 	TargetsErr       error
 	DeleteProjectErr error
 	DeleteTargetErr  error
+	User             internal.User
+	UserErr          error
+	Groups           []internal.Group
+	GroupsErr        error
+	GroupAccessErr   error
+	OrgAccessErr     error
+	// InactiveIntegrations lists integration IDs IntegrationActive reports
+	// as inactive.
+	InactiveIntegrations map[string]bool
+	IntegrationStatusErr error
 }
 
 func (m *mockSnykAPI) FetchOrgs(ctx context.Context, groupID string) ([]internal.Org, error) {
@@ -334,6 +845,35 @@ func (m *mockSnykAPI) DeleteTarget(ctx context.Context, orgID, targetID string)
 	return m.DeleteTargetErr
 }
 
+func (m *mockSnykAPI) FetchUser(ctx context.Context) (internal.User, error) {
+	if m.UserErr != nil {
+		return internal.User{}, m.UserErr
+	}
+	return m.User, nil
+}
+
+func (m *mockSnykAPI) FetchGroups(ctx context.Context) ([]internal.Group, error) {
+	if m.GroupsErr != nil {
+		return nil, m.GroupsErr
+	}
+	return m.Groups, nil
+}
+
+func (m *mockSnykAPI) CheckGroupAccess(ctx context.Context, groupID string) error {
+	return m.GroupAccessErr
+}
+
+func (m *mockSnykAPI) CheckOrgAccess(ctx context.Context, orgID string) error {
+	return m.OrgAccessErr
+}
+
+func (m *mockSnykAPI) IntegrationActive(ctx context.Context, orgID, integrationID string) (bool, error) {
+	if m.IntegrationStatusErr != nil {
+		return false, m.IntegrationStatusErr
+	}
+	return !m.InactiveIntegrations[integrationID], nil
+}
+
 // --- resolveOrgs ---
 
 func TestResolveOrgs_GroupID(t *testing.T) {
@@ -344,7 +884,7 @@ func TestResolveOrgs_GroupID(t *testing.T) {
 			{ID: "org-2", Name: "Org Two", Slug: "org-two"},
 		},
 	}
-	orgs, err := resolveOrgs(ctx, mock, "group-123", "")
+	orgs, err := resolveOrgs(ctx, mock, "group-123", "", "")
 	if err != nil {
 		t.Fatalf("resolveOrgs: %v", err)
 	}
@@ -359,7 +899,7 @@ func TestResolveOrgs_GroupID(t *testing.T) {
 func TestResolveOrgs_OrgIDOnly(t *testing.T) {
 	ctx := context.Background()
 	mock := &mockSnykAPI{Orgs: []internal.Org{{ID: "unused"}}}
-	orgs, err := resolveOrgs(ctx, mock, "", "my-org-id")
+	orgs, err := resolveOrgs(ctx, mock, "", "my-org-id", "")
 	if err != nil {
 		t.Fatalf("resolveOrgs: %v", err)
 	}
@@ -368,15 +908,76 @@ func TestResolveOrgs_OrgIDOnly(t *testing.T) {
 	}
 }
 
+func TestResolveOrgs_NoOrgs(t *testing.T) {
+	ctx := context.Background()
+	_, err := resolveOrgs(ctx, &mockSnykAPI{}, "group-123", "", "")
+	if err == nil || !strings.Contains(err.Error(), "no organizations found for group group-123") {
+		t.Errorf("empty group: err = %v, want no organizations found", err)
+	}
+
+	for _, denied := range []error{
+		&internal.StatusError{Op: "check org access", StatusCode: 403},
+		&internal.StatusError{Op: "check org access", StatusCode: 404},
+		&internal.StatusError{Op: "check org access", StatusCode: 400},
+		fmt.Errorf("check org access: %w", internal.ErrUnauthorized),
+	} {
+		mock := &mockSnykAPI{OrgAccessErr: denied}
+		if _, err := resolveOrgs(ctx, mock, "", "bad-org", ""); err == nil || !strings.Contains(err.Error(), "bad-org") {
+			t.Errorf("unreadable --orgId (%v): err = %v, want error naming the org", denied, err)
+		}
+	}
+	// Anything short of a definite answer is left to the org's own fetch.
+	for _, other := range []error{
+		&internal.StatusError{Op: "check org access", StatusCode: 500},
+		errors.New("check org access: max retries exceeded: status 503"),
+	} {
+		mock := &mockSnykAPI{OrgAccessErr: other}
+		if orgs, err := resolveOrgs(ctx, mock, "", "org-1", ""); err != nil || len(orgs) != 1 || orgs[0].ID != "org-1" {
+			t.Errorf("check error %v: orgs = %+v, err = %v; want org-1 and no error", other, orgs, err)
+		}
+	}
+}
+
 func TestResolveOrgs_GroupID_APIError(t *testing.T) {
 	ctx := context.Background()
 	mock := &mockSnykAPI{OrgsErr: fmt.Errorf("api down")}
-	_, err := resolveOrgs(ctx, mock, "group-123", "")
+	_, err := resolveOrgs(ctx, mock, "group-123", "", "")
 	if err == nil {
 		t.Fatal("resolveOrgs: want error")
 	}
 }
 
+func TestResolveOrgs_OrgName(t *testing.T) {
+	ctx := context.Background()
+	mock := &mockSnykAPI{
+		Orgs: []internal.Org{
+			{ID: "org-1", Name: "Platform Team", Slug: "platform-team"},
+			{ID: "org-2", Name: "Other", Slug: "other"},
+			{ID: "org-3", Name: "Dup", Slug: "dup-a"},
+			{ID: "org-4", Name: "dup", Slug: "dup-b"},
+		},
+	}
+	orgs, err := resolveOrgs(ctx, mock, "group-123", "", "platform team")
+	if err != nil {
+		t.Fatalf("resolveOrgs by name: %v", err)
+	}
+	if len(orgs) != 1 || orgs[0].ID != "org-1" || orgs[0].Name != "Platform Team" {
+		t.Errorf("orgs = %+v, want org-1 with metadata", orgs)
+	}
+	if orgs, err := resolveOrgs(ctx, mock, "group-123", "", "OTHER"); err != nil || orgs[0].ID != "org-2" {
+		t.Errorf("resolve by slug: orgs=%+v err=%v", orgs, err)
+	}
+	if _, err := resolveOrgs(ctx, mock, "group-123", "", "dup"); err == nil {
+		t.Error("ambiguous name: want error")
+	}
+	if _, err := resolveOrgs(ctx, mock, "group-123", "", "missing"); err == nil {
+		t.Error("no match: want error")
+	}
+	if _, err := resolveOrgs(ctx, mock, "", "org-1", "Other"); err == nil {
+		t.Error("--org-name without --groupId: want error")
+	}
+}
+
 // TestResolveOrgs_WithTestdataOrgs uses testdata/mock_orgs_response.json so mock
 // data matches real API shape. Skips if testdata is not present.
 func TestResolveOrgs_WithTestdataOrgs(t *testing.T) {
@@ -386,7 +987,7 @@ func TestResolveOrgs_WithTestdataOrgs(t *testing.T) {
 	}
 	ctx := context.Background()
 	mock := &mockSnykAPI{Orgs: orgs}
-	got, err := resolveOrgs(ctx, mock, "group-123", "")
+	got, err := resolveOrgs(ctx, mock, "group-123", "", "")
 	if err != nil {
 		t.Fatalf("resolveOrgs: %v", err)
 	}
@@ -411,7 +1012,7 @@ func TestProcessOrgForRefresh(t *testing.T) {
 			{Name: "owner/repo:package.json", Origin: "github", Branch: "main"},
 		},
 	}
-	res := processOrgForRefresh(ctx, mock, org, "")
+	res := processOrgForRefresh(ctx, mock, org, refreshOptions{})
 	if res.err != nil {
 		t.Fatalf("processOrgForRefresh: %v", res.err)
 	}
@@ -424,81 +1025,264 @@ func TestProcessOrgForRefresh(t *testing.T) {
 	if len(res.targets) != 1 {
 		t.Errorf("targets: got %d, want 1", len(res.targets))
 	}
-	if res.gitlabCount != 0 {
-		t.Errorf("gitlabCount = %d, want 0", res.gitlabCount)
+	if res.counts.gitlab != 0 {
+		t.Errorf("counts.gitlab = %d, want 0", res.counts.gitlab)
 	}
 }
 
 func TestProcessOrgForRefresh_ListIntegrationsError(t *testing.T) {
 	ctx := context.Background()
 	mock := &mockSnykAPI{IntegrationsErr: fmt.Errorf("auth failed")}
-	res := processOrgForRefresh(ctx, mock, internal.Org{ID: "org-1"}, "")
+	res := processOrgForRefresh(ctx, mock, internal.Org{ID: "org-1"}, refreshOptions{})
 	if res.err == nil {
 		t.Fatal("want error from ListIntegrations")
 	}
 }
 
-func TestProcessOrgForRefresh_FetchProjectsError(t *testing.T) {
+func TestProcessOrgForRefresh_IntegrationsFallback(t *testing.T) {
 	ctx := context.Background()
 	mock := &mockSnykAPI{
-		Integrations: map[string]string{},
-		ProjectsErr:  fmt.Errorf("rate limited"),
+		IntegrationsErr: fmt.Errorf("flaky"),
+		Projects:        []internal.Project{{ID: "p1", Name: "owner/repo", Origin: "github", Branch: "main"}},
+	}
+	fallback := map[string]map[string]string{"org-1": {"github": "int-from-file"}}
+	for _, skipNoInt := range []bool{false, true} {
+		opts := refreshOptions{integrationsFallback: fallback, skipOrgsWithoutIntegrations: skipNoInt}
+		res := processOrgForRefresh(ctx, mock, internal.Org{ID: "org-1"}, opts)
+		if res.err != nil {
+			t.Fatalf("skipOrgsWithoutIntegrations=%v: unexpected error %v", skipNoInt, res.err)
+		}
+		if len(res.targets) != 1 || res.targets[0].IntegrationID != "int-from-file" {
+			t.Errorf("skipOrgsWithoutIntegrations=%v: targets = %+v, want one using int-from-file", skipNoInt, res.targets)
+		}
 	}
-	res := processOrgForRefresh(ctx, mock, internal.Org{ID: "org-1"}, "")
+
+	res := processOrgForRefresh(ctx, mock, internal.Org{ID: "org-2"}, refreshOptions{integrationsFallback: fallback})
 	if res.err == nil {
-		t.Fatal("want error from FetchProjects")
+		t.Error("org without a fallback entry: want error")
 	}
 }
 
-// TestProcessOrgForRefresh_WithTestdataIntegrations uses testdata integrations
-// so mock data matches real API shape. Skips if testdata is not present.
-func TestProcessOrgForRefresh_WithTestdataIntegrations(t *testing.T) {
-	integrations := loadMockIntegrationsFromTestdata(t)
-	if integrations == nil {
-		return
-	}
+func TestProcessOrgForRefresh_FilterIntegrationActive(t *testing.T) {
 	ctx := context.Background()
-	org := internal.Org{ID: "org-1", Name: "Test", Slug: "test"}
 	mock := &mockSnykAPI{
-		Integrations: integrations,
+		Integrations:         map[string]string{"github": "int-github", "bitbucket-cloud": "int-bb"},
+		InactiveIntegrations: map[string]bool{"int-bb": true},
 		Projects: []internal.Project{
-			{Name: "owner/repo:package.json", Origin: "github", Branch: "main"},
+			{Name: "owner/repo", Origin: "github", Branch: "main"},
+			{Name: "ws/repo", Origin: "bitbucket-cloud", Branch: "main"},
 		},
 	}
-	res := processOrgForRefresh(ctx, mock, org, "")
+	res := processOrgForRefresh(ctx, mock, internal.Org{ID: "org-1"}, refreshOptions{filterIntegrationActive: true})
 	if res.err != nil {
 		t.Fatalf("processOrgForRefresh: %v", res.err)
 	}
-	if len(res.targets) != 1 {
-		t.Fatalf("len(targets) = %d, want 1", len(res.targets))
+	if len(res.targets) != 1 || res.targets[0].IntegrationID != "int-github" || res.counts.noIntegration != 1 {
+		t.Errorf("targets = %+v, noIntegration = %d; want only the github target", res.targets, res.counts.noIntegration)
 	}
-	// Integration ID should come from testdata (github -> uuid from mock_integrations_response.json)
-	wantID := "b0000004-0004-4000-8000-000000000004"
-	if res.targets[0].IntegrationID != wantID {
-		t.Errorf("IntegrationID = %q, want %q (from mock_integrations_response.json)", res.targets[0].IntegrationID, wantID)
+	if strings.Join(res.inactiveIntegrations, ",") != "bitbucket-cloud" {
+		t.Errorf("inactiveIntegrations = %v, want [bitbucket-cloud]", res.inactiveIntegrations)
+	}
+
+	mock.IntegrationStatusErr = fmt.Errorf("boom")
+	if res := processOrgForRefresh(ctx, mock, internal.Org{ID: "org-1"}, refreshOptions{filterIntegrationActive: true}); res.err == nil {
+		t.Error("status check error: want the org to fail")
+	}
+	if res := processOrgForRefresh(ctx, mock, internal.Org{ID: "org-1"}, refreshOptions{}); res.err != nil || len(res.targets) != 2 {
+		t.Errorf("without the filter: err = %v, targets = %d; want no status calls and 2 targets", res.err, len(res.targets))
 	}
 }
 
-// TestProcessOrgForRefresh_WithTestdataProjects uses testdata projects and
-// integrations so mock data matches real API shape. Skips if testdata is not present.
-func TestProcessOrgForRefresh_WithTestdataProjects(t *testing.T) {
-	integrations := loadMockIntegrationsFromTestdata(t)
-	projects := loadMockProjectsFromTestdata(t)
-	if integrations == nil || projects == nil {
-		return
+func TestLoadIntegrationsFile(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.json")
+	os.WriteFile(good, []byte(`{"org-1": {"github": "int-1", "azure-repos": "int-2"}}`), 0600)
+	byOrg, err := loadIntegrationsFile(good)
+	if err != nil {
+		t.Fatalf("loadIntegrationsFile: %v", err)
 	}
-	ctx := context.Background()
-	org := internal.Org{ID: "a0000001-0001-4000-8000-000000000001", Name: "Example Org", Slug: "example-org"}
-	mock := &mockSnykAPI{
-		Integrations: integrations,
-		Projects:     projects,
+	if byOrg["org-1"]["azure-repos"] != "int-2" {
+		t.Errorf("byOrg = %v", byOrg)
 	}
-	res := processOrgForRefresh(ctx, mock, org, "")
-	if res.err != nil {
-		t.Fatalf("processOrgForRefresh: %v", res.err)
+	bad := filepath.Join(dir, "bad.json")
+	os.WriteFile(bad, []byte(`{"org-1": {"github": ""}}`), 0600)
+	if _, err := loadIntegrationsFile(bad); err == nil {
+		t.Error("empty integration ID: want error")
 	}
-	// Mock has github-enterprise, github, bitbucket-connect-app projects; some become import targets
-	if len(res.targets) == 0 && len(projects) > 0 {
+}
+
+func TestProcessOrgForRefresh_FetchProjectsError(t *testing.T) {
+	ctx := context.Background()
+	mock := &mockSnykAPI{
+		Integrations: map[string]string{},
+		ProjectsErr:  fmt.Errorf("rate limited"),
+	}
+	res := processOrgForRefresh(ctx, mock, internal.Org{ID: "org-1"}, refreshOptions{})
+	if res.err == nil {
+		t.Fatal("want error from FetchProjects")
+	}
+}
+
+// projectCallCounter wraps mockSnykAPI and counts FetchProjects calls.
+type projectCallCounter struct {
+	*mockSnykAPI
+	projectCalls int
+}
+
+func (c *projectCallCounter) FetchProjects(ctx context.Context, orgID string) ([]internal.Project, error) {
+	c.projectCalls++
+	return c.mockSnykAPI.FetchProjects(ctx, orgID)
+}
+
+func TestProcessOrgForRefresh_SkipOrgsWithoutIntegrations(t *testing.T) {
+	ctx := context.Background()
+	opts := refreshOptions{skipOrgsWithoutIntegrations: true}
+	projects := []internal.Project{{Name: "owner/repo", Origin: "github", Branch: "main"}}
+
+	noSCM := &projectCallCounter{mockSnykAPI: &mockSnykAPI{
+		Integrations: map[string]string{"docker-hub": "int-docker", "gitlab": "int-gl"},
+		Projects:     projects,
+	}}
+	res := processOrgForRefresh(ctx, noSCM, internal.Org{ID: "org-1"}, opts)
+	if res.err != nil || !res.skippedNoIntegrations {
+		t.Errorf("org without SCM integrations: err=%v skipped=%v, want skipped", res.err, res.skippedNoIntegrations)
+	}
+	if noSCM.projectCalls != 0 {
+		t.Errorf("FetchProjects called %d time(s), want 0", noSCM.projectCalls)
+	}
+
+	withSCM := &projectCallCounter{mockSnykAPI: &mockSnykAPI{
+		Integrations: map[string]string{"github": "int-github"},
+		Projects:     projects,
+	}}
+	res = processOrgForRefresh(ctx, withSCM, internal.Org{ID: "org-1"}, opts)
+	if res.err != nil || res.skippedNoIntegrations || len(res.targets) != 1 {
+		t.Errorf("org with SCM integration: err=%v skipped=%v targets=%d", res.err, res.skippedNoIntegrations, len(res.targets))
+	}
+	if withSCM.projectCalls != 1 {
+		t.Errorf("FetchProjects called %d time(s), want 1", withSCM.projectCalls)
+	}
+}
+
+func TestProcessOrgForRefresh_SequentialInner(t *testing.T) {
+	ctx := context.Background()
+	opts := refreshOptions{sequentialInner: true}
+	projects := []internal.Project{{Name: "owner/repo", Origin: "github", Branch: "main"}}
+
+	// Unlike --skip-orgs-without-integrations, orgs without SCM integrations
+	// still have their projects fetched.
+	api := &projectCallCounter{mockSnykAPI: &mockSnykAPI{
+		Integrations: map[string]string{"docker-hub": "int-docker"},
+		Projects:     projects,
+	}}
+	res := processOrgForRefresh(ctx, api, internal.Org{ID: "org-1"}, opts)
+	if res.err != nil || res.skippedNoIntegrations || api.projectCalls != 1 {
+		t.Errorf("err=%v skipped=%v projectCalls=%d, want projects fetched", res.err, res.skippedNoIntegrations, api.projectCalls)
+	}
+
+	failing := &projectCallCounter{mockSnykAPI: &mockSnykAPI{
+		IntegrationsErr: fmt.Errorf("boom"),
+		Projects:        projects,
+	}}
+	res = processOrgForRefresh(ctx, failing, internal.Org{ID: "org-1"}, opts)
+	if res.err == nil {
+		t.Error("want error when listing integrations fails")
+	}
+	if failing.projectCalls != 0 {
+		t.Errorf("FetchProjects called %d time(s) after integrations failed, want 0", failing.projectCalls)
+	}
+}
+
+func TestProcessOrgForRefresh_SkipStatus(t *testing.T) {
+	ctx := context.Background()
+	forbidden := &internal.StatusError{Op: "fetch projects", StatusCode: 403, Body: "forbidden"}
+	mock := &mockSnykAPI{
+		Integrations: map[string]string{"github": "int-github"},
+		ProjectsErr:  forbidden,
+	}
+
+	res := processOrgForRefresh(ctx, mock, internal.Org{ID: "org-1"}, refreshOptions{})
+	if res.err == nil || res.skippedStatus != 0 {
+		t.Errorf("without --skip-status: err=%v skippedStatus=%d, want a hard error", res.err, res.skippedStatus)
+	}
+
+	opts := refreshOptions{skipStatuses: map[int]bool{403: true}}
+	res = processOrgForRefresh(ctx, mock, internal.Org{ID: "org-1"}, opts)
+	if res.err != nil || res.skippedStatus != 403 || res.skipReason == nil {
+		t.Errorf("with --skip-status=403: err=%v skippedStatus=%d, want skipped", res.err, res.skippedStatus)
+	}
+
+	mock.ProjectsErr = &internal.StatusError{Op: "fetch projects", StatusCode: 500}
+	res = processOrgForRefresh(ctx, mock, internal.Org{ID: "org-1"}, opts)
+	if res.err == nil {
+		t.Error("status not in --skip-status should still fail the org")
+	}
+}
+
+func TestParseSkipStatuses(t *testing.T) {
+	set, err := parseSkipStatuses([]string{"403", "404"})
+	if err != nil || !set[403] || !set[404] || len(set) != 2 {
+		t.Errorf("parseSkipStatuses = %v, %v", set, err)
+	}
+	if set, err := parseSkipStatuses(nil); set != nil || err != nil {
+		t.Errorf("empty = %v, %v, want nil, nil", set, err)
+	}
+	for _, bad := range []string{"200", "forbidden", "600"} {
+		if _, err := parseSkipStatuses([]string{bad}); err == nil {
+			t.Errorf("parseSkipStatuses(%q): want error", bad)
+		}
+	}
+}
+
+// TestProcessOrgForRefresh_WithTestdataIntegrations uses testdata integrations
+// so mock data matches real API shape. Skips if testdata is not present.
+func TestProcessOrgForRefresh_WithTestdataIntegrations(t *testing.T) {
+	integrations := loadMockIntegrationsFromTestdata(t)
+	if integrations == nil {
+		return
+	}
+	ctx := context.Background()
+	org := internal.Org{ID: "org-1", Name: "Test", Slug: "test"}
+	mock := &mockSnykAPI{
+		Integrations: integrations,
+		Projects: []internal.Project{
+			{Name: "owner/repo:package.json", Origin: "github", Branch: "main"},
+		},
+	}
+	res := processOrgForRefresh(ctx, mock, org, refreshOptions{})
+	if res.err != nil {
+		t.Fatalf("processOrgForRefresh: %v", res.err)
+	}
+	if len(res.targets) != 1 {
+		t.Fatalf("len(targets) = %d, want 1", len(res.targets))
+	}
+	// Integration ID should come from testdata (github -> uuid from mock_integrations_response.json)
+	wantID := "b0000004-0004-4000-8000-000000000004"
+	if res.targets[0].IntegrationID != wantID {
+		t.Errorf("IntegrationID = %q, want %q (from mock_integrations_response.json)", res.targets[0].IntegrationID, wantID)
+	}
+}
+
+// TestProcessOrgForRefresh_WithTestdataProjects uses testdata projects and
+// integrations so mock data matches real API shape. Skips if testdata is not present.
+func TestProcessOrgForRefresh_WithTestdataProjects(t *testing.T) {
+	integrations := loadMockIntegrationsFromTestdata(t)
+	projects := loadMockProjectsFromTestdata(t)
+	if integrations == nil || projects == nil {
+		return
+	}
+	ctx := context.Background()
+	org := internal.Org{ID: "a0000001-0001-4000-8000-000000000001", Name: "Example Org", Slug: "example-org"}
+	mock := &mockSnykAPI{
+		Integrations: integrations,
+		Projects:     projects,
+	}
+	res := processOrgForRefresh(ctx, mock, org, refreshOptions{})
+	if res.err != nil {
+		t.Fatalf("processOrgForRefresh: %v", res.err)
+	}
+	// Mock has github-enterprise, github, bitbucket-connect-app projects; some become import targets
+	if len(res.targets) == 0 && len(projects) > 0 {
 		t.Logf("processOrgForRefresh returned 0 targets from %d projects (some origins may be filtered)", len(projects))
 	}
 	// Sanity: we got a result with org meta and no error
@@ -543,7 +1327,7 @@ func TestWriteRefreshOutput(t *testing.T) {
 			{Target: internal.Target{Owner: "u", Name: "r"}, OrgID: "org-1", IntegrationID: "int-1"},
 		},
 	}
-	written, err := writeRefreshOutput(out, path)
+	written, err := writeRefreshOutput(out, path, false)
 	if err != nil {
 		t.Fatalf("writeRefreshOutput: %v", err)
 	}
@@ -563,6 +1347,209 @@ func TestWriteRefreshOutput(t *testing.T) {
 	}
 }
 
+func TestGroupOutputByOrg(t *testing.T) {
+	out := RefreshOutput{
+		GroupID: "group-1",
+		Orgs: map[string]OrgMeta{
+			"org-1": {Name: "One", Slug: "one"},
+			"org-2": {Name: "Two", Slug: "two"},
+		},
+		Integrations: map[string]string{"int-1": "github"},
+		Targets: []internal.ImportTarget{
+			{Target: internal.Target{Owner: "o", Name: "a"}, OrgID: "org-1", IntegrationID: "int-1"},
+			{Target: internal.Target{Owner: "o", Name: "b"}, OrgID: "org-1", IntegrationID: "int-1"},
+			{Target: internal.Target{Owner: "o", Name: "c"}, OrgID: "org-3", IntegrationID: "int-1"},
+		},
+	}
+	g := groupOutputByOrg(out)
+	if g.GroupID != "group-1" || g.Integrations["int-1"] != "github" {
+		t.Errorf("group/integrations not carried over: %+v", g)
+	}
+	if got := g.Orgs["org-1"]; got.Meta.Name != "One" || len(got.Targets) != 2 {
+		t.Errorf("org-1 = %+v, want meta One with 2 targets", got)
+	}
+	if got := g.Orgs["org-2"]; got.Targets == nil || len(got.Targets) != 0 {
+		t.Errorf("org-2 = %+v, want empty (non-nil) targets", got)
+	}
+	if got := g.Orgs["org-3"]; len(got.Targets) != 1 {
+		t.Errorf("org-3 without meta = %+v, want 1 target", got)
+	}
+}
+
+func TestWriteRefreshOutput_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export-targets.json")
+	out := RefreshOutput{
+		Orgs:         map[string]OrgMeta{},
+		Integrations: map[string]string{},
+		Targets:      []internal.ImportTarget{{Target: internal.Target{Owner: "u", Name: "r"}, OrgID: "org-1", IntegrationID: "int-1"}},
+	}
+	if _, err := writeRefreshOutput(out, path, true); err != nil {
+		t.Fatalf("writeRefreshOutput: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if bytes.ContainsRune(data, '\n') {
+		t.Errorf("compact output contains newlines: %s", data)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("file mode = %o, want 0600", perm)
+	}
+}
+
+// TestWriteFileAtomic checks that an existing file is replaced in full, keeps
+// 0600 permissions, and that no temp files are left behind.
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "export-targets.json")
+	if err := os.WriteFile(path, []byte("old content that is longer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte("new")); err != nil {
+		t.Fatalf("writeFileAtomic: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Errorf("content = %q, want %q", data, "new")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("file mode = %o, want 0600", perm)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("dir has %d entries, want 1 (temp file left behind?)", len(entries))
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "out.json"), []byte("x")); err == nil {
+		t.Error("write into missing directory: want error")
+	}
+}
+
+func TestWriteFileAtomicGzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export-targets.json.gz")
+	want := []byte(`{"targets":[]}`)
+	if err := writeFileAtomicGzip(path, want); err != nil {
+		t.Fatalf("writeFileAtomicGzip: %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("not gzip: %v", err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("decompressed = %q, want %q", got, want)
+	}
+}
+
+func TestExpandOutputPath(t *testing.T) {
+	vars := outputVars{group: "g-1", start: time.Date(2026, 3, 4, 5, 6, 7, 0, time.FixedZone("X", 3600)), count: 42}
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"export-targets.json", "export-targets.json", false},
+		{"refresh-{group}-{date}.json", "refresh-g-1-2026-03-04.json", false},
+		{"out/{date}T{time}-{count}.json", "out/2026-03-04T040607-42.json", false},
+		{"refresh-{org}.json", "", true},
+		{"refresh-{}.json", "", true},
+	}
+	for _, tt := range tests {
+		got, err := expandOutputPath(tt.path, vars)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("expandOutputPath(%q) = %q, %v; want %q (error %v)", tt.path, got, err, tt.want, tt.wantErr)
+		}
+	}
+	if _, err := expandOutputPath("{group}.json", outputVars{}); err == nil {
+		t.Error("{group} without a group ID: want error")
+	}
+	if _, err := expandOutputPath("{group}.json", outputVars{group: "../x"}); err == nil {
+		t.Error("{group} with a path separator: want error")
+	}
+}
+
+func TestGzipOutputPath(t *testing.T) {
+	for in, want := range map[string]string{
+		"export-targets.json": "export-targets.json.gz",
+		"out/targets.json.gz": "out/targets.json.gz",
+		"OUT.JSON.GZ":         "OUT.JSON.GZ",
+		"refresh-github.json": "refresh-github.json.gz",
+		"targets":             "targets.gz",
+	} {
+		if got := gzipOutputPath(in); got != want {
+			t.Errorf("gzipOutputPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestToOutputSchema(t *testing.T) {
+	out := RefreshOutput{
+		GroupID:      "group-1",
+		Orgs:         map[string]OrgMeta{"org-1": {Name: "O"}},
+		Integrations: map[string]string{"int-1": "github"},
+		Targets: []internal.ImportTarget{{
+			Target:        internal.Target{Owner: "o", Name: "r", Branch: "main"},
+			OrgID:         "org-1",
+			IntegrationID: "int-1",
+			Files:         []internal.File{{Path: "package.json"}},
+		}},
+	}
+
+	v2, err := toOutputSchema(out, "v2")
+	if err != nil {
+		t.Fatalf("v2: %v", err)
+	}
+	if _, ok := v2.(RefreshOutput); !ok {
+		t.Errorf("v2 payload type = %T, want RefreshOutput", v2)
+	}
+
+	v1, err := toOutputSchema(out, "v1")
+	if err != nil {
+		t.Fatalf("v1: %v", err)
+	}
+	data, err := json.Marshal(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 1 || decoded["targets"] == nil {
+		t.Errorf("v1 top-level keys = %v, want only targets", decoded)
+	}
+	if strings.Contains(string(data), "files") {
+		t.Errorf("v1 output should not include files: %s", data)
+	}
+
+	if _, err := toOutputSchema(out, "v3"); err == nil {
+		t.Error("unknown schema: want error")
+	}
+}
+
 // TestWriteRefreshOutput_InvalidPath verifies that path traversal is rejected.
 // The caller of writeRefreshOutput must pass a path from sanitizeOutputPath;
 // sanitizeOutputPath is what rejects paths like "../evil.json".
@@ -573,6 +1560,87 @@ func TestWriteRefreshOutput_InvalidPath(t *testing.T) {
 	}
 }
 
+func TestJSONStreamWriter(t *testing.T) {
+	tgt := func(org, name string) internal.ImportTarget {
+		return internal.ImportTarget{OrgID: org, IntegrationID: "int-1", Target: internal.Target{Owner: "acme", Name: name, Branch: "main"}}
+	}
+	want := RefreshOutput{
+		GroupID:      "g1",
+		Orgs:         map[string]OrgMeta{"o1": {Name: "One"}, "o2": {Name: "Two"}},
+		Integrations: map[string]string{"int-1": "github"},
+		Targets:      []internal.ImportTarget{tgt("o1", "a"), tgt("o1", "b"), tgt("o2", "c")},
+		PartialOrgs:  []string{"o3"},
+	}
+	for _, compact := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "out.json")
+		s, err := newJSONStreamWriter(path, compact)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.writeTargets(want.Targets[:2]); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.writeTargets(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.writeTargets(want.Targets[2:]); err != nil {
+			t.Fatal(err)
+		}
+		meta := want
+		meta.Targets = nil
+		if err := s.finish(meta); err != nil {
+			t.Fatal(err)
+		}
+		if s.count != 3 {
+			t.Errorf("compact=%v: count = %d, want 3", compact, s.count)
+		}
+		got, err := loadRefreshOutput(path)
+		if err != nil {
+			t.Fatalf("compact=%v: loadRefreshOutput: %v", compact, err)
+		}
+		if d := diffRefreshOutputs(want, got); !d.empty() || got.GroupID != "g1" || fmt.Sprint(got.PartialOrgs) != "[o3]" {
+			t.Errorf("compact=%v: streamed output = %+v, want %+v", compact, got, want)
+		}
+		entries, _ := os.ReadDir(filepath.Dir(path))
+		if len(entries) != 1 {
+			t.Errorf("compact=%v: %d file(s) left in the output directory, want only the output", compact, len(entries))
+		}
+	}
+
+	t.Run("no targets", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.json")
+		s, err := newJSONStreamWriter(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.finish(RefreshOutput{Orgs: map[string]OrgMeta{}, Integrations: map[string]string{}}); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "{\n  \"targets\": [],\n  \"orgs\": {},\n  \"integrations\": {}\n}"; string(data) != want {
+			t.Errorf("output = %q, want %q", data, want)
+		}
+	})
+
+	t.Run("abort leaves nothing behind", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := newJSONStreamWriter(filepath.Join(dir, "out.json"), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.writeTargets(want.Targets); err != nil {
+			t.Fatal(err)
+		}
+		s.abort()
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("abort left %d file(s), want none", len(entries))
+		}
+	})
+}
+
 // --- mergeRefreshResult ---
 
 func TestMergeRefreshResult(t *testing.T) {
@@ -647,7 +1715,7 @@ func TestReportAndDeleteDuplicates_DryRun(t *testing.T) {
 			},
 		},
 	}
-	affected, totalDup, deleted, failed := reportAndDeleteDuplicates(ctx, mock, false, orgsWithDuplicates)
+	affected, totalDup, deleted, failed := reportAndDeleteDuplicates(ctx, mock, false, newDeleteCap(0), orgsWithDuplicates)
 	if len(affected) != 1 || !affected["org-1"] {
 		t.Errorf("orgsAffected = %v", affected)
 	}
@@ -676,12 +1744,91 @@ func TestReportAndDeleteDuplicates_DoDelete(t *testing.T) {
 			},
 		},
 	}
-	_, totalDup, deleted, failed := reportAndDeleteDuplicates(ctx, mock, true, orgsWithDuplicates)
+	_, totalDup, deleted, failed := reportAndDeleteDuplicates(ctx, mock, true, newDeleteCap(0), orgsWithDuplicates)
 	if totalDup != 1 || deleted != 1 || failed != 0 {
 		t.Errorf("totalDuplicates=%d deleted=%d failed=%d", totalDup, deleted, failed)
 	}
 }
 
+func TestReportAndDeleteDuplicates_MaxDeletesPerOrg(t *testing.T) {
+	ctx := context.Background()
+	mock := &mockSnykAPI{}
+	dupesIn := func(orgID string, n int) dedupCollectedResult {
+		projects := []internal.Project{{ID: orgID + "-keep", Name: "repo", Created: "2020-01-01"}}
+		for i := 0; i < n; i++ {
+			projects = append(projects, internal.Project{ID: fmt.Sprintf("%s-dup%d", orgID, i), Name: "repo", Created: "2020-01-02"})
+		}
+		return dedupCollectedResult{orgID: orgID, orgLabel: orgID, groups: []duplicateGroup{{key: "repo", projects: projects}}}
+	}
+	dc := newDeleteCap(2)
+	_, totalDup, deleted, failed := reportAndDeleteDuplicates(ctx, mock, true, dc, []dedupCollectedResult{dupesIn("org-a", 3), dupesIn("org-b", 1)})
+	if totalDup != 4 || deleted != 3 || failed != 0 {
+		t.Errorf("totalDuplicates=%d deleted=%d failed=%d, want 4, 3 (2 capped in org-a + 1 in org-b), 0", totalDup, deleted, failed)
+	}
+	capped, skipped := dc.cappedOrgs()
+	if len(capped) != 1 || capped[0] != "org-a" || skipped != 1 {
+		t.Errorf("cappedOrgs = %v, %d; want [org-a], 1", capped, skipped)
+	}
+}
+
+func TestWriteUndoFile(t *testing.T) {
+	orgResults := []dedupCollectedResult{{
+		orgID: "o1", orgLabel: "one",
+		groups: []duplicateGroup{{projects: []internal.Project{
+			{ID: "keep", Name: "a/b", Origin: "github"},
+			{ID: "d1", Name: "a/b", Origin: "github", Branch: "main", Created: "2024-02-01T00:00:00Z", TargetID: "t1"},
+			{ID: "d2", Name: "a/b", Origin: "github", Branch: "main", Created: "2024-03-01T00:00:00Z", TargetID: "t2"},
+		}}},
+	}}
+	dups := duplicatesInReportOrder(orgResults, nil)
+	path := filepath.Join(t.TempDir(), "undo.json")
+	n, err := writeUndoFile(path, 1, dups)
+	if err != nil || n != 1 {
+		t.Fatalf("writeUndoFile = %d, %v; want 1 record under the cap", n, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]string
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("decode undo file: %v", err)
+	}
+	want := map[string]string{"orgId": "o1", "id": "d1", "name": "a/b", "origin": "github", "branch": "main", "created": "2024-02-01T00:00:00Z", "targetId": "t1"}
+	if len(got) != 1 || fmt.Sprint(got[0]) != fmt.Sprint(want) {
+		t.Errorf("undo records = %v, want [%v]", got, want)
+	}
+}
+
+func TestPlannedDeletes(t *testing.T) {
+	dups := []string{"o1", "o1", "o1", "o2", "o1", "o2"}
+	tests := []struct {
+		max  int
+		want int
+	}{
+		{0, 6},
+		{1, 2},
+		{2, 4},
+		{10, 6},
+	}
+	for _, tt := range tests {
+		if got := plannedDeletes(tt.max, dups); got != tt.want {
+			t.Errorf("plannedDeletes(%d) = %d, want %d", tt.max, got, tt.want)
+		}
+		// Must agree with what deleteCap will actually allow.
+		dc := newDeleteCap(tt.max)
+		allowed := 0
+		for _, orgID := range dups {
+			if dc.allow(orgID, orgID) {
+				allowed++
+			}
+		}
+		if allowed != tt.want {
+			t.Errorf("deleteCap(%d) allowed %d, plannedDeletes said %d", tt.max, allowed, tt.want)
+		}
+	}
+}
+
 func TestReportAndDeleteDuplicatesGroupWide_DryRun(t *testing.T) {
 	ctx := context.Background()
 	mock := &mockSnykAPI{}
@@ -694,7 +1841,7 @@ func TestReportAndDeleteDuplicatesGroupWide_DryRun(t *testing.T) {
 			},
 		},
 	}
-	affected, totalDup, deleted, failed := reportAndDeleteDuplicatesGroupWide(ctx, mock, false, groups)
+	affected, totalDup, deleted, failed := reportAndDeleteDuplicatesGroupWide(ctx, mock, false, newDeleteCap(0), groups)
 	if len(affected) != 1 || !affected["org-2"] {
 		t.Errorf("orgsAffected (dupes in org-2) = %v", affected)
 	}
@@ -718,7 +1865,7 @@ func TestReportAndDeleteDuplicatesGroupWide_DoDelete(t *testing.T) {
 			},
 		},
 	}
-	affected, totalDup, deleted, failed := reportAndDeleteDuplicatesGroupWide(ctx, mock, true, groups)
+	affected, totalDup, deleted, failed := reportAndDeleteDuplicatesGroupWide(ctx, mock, true, newDeleteCap(0), groups)
 	if !affected["org-2"] {
 		t.Errorf("org-2 should be in affected")
 	}
@@ -741,7 +1888,7 @@ func TestCleanupEmptyTargets_DryRun(t *testing.T) {
 		},
 	}
 	affected := map[string]bool{"org-1": true}
-	deleted, failed := cleanupEmptyTargets(ctx, mock, false, affected)
+	deleted, failed := cleanupEmptyTargets(ctx, mock, false, false, 2, affected)
 	if deleted != 1 || failed != 0 {
 		t.Errorf("dry run: deleted=%d failed=%d", deleted, failed)
 	}
@@ -758,7 +1905,7 @@ func TestCleanupEmptyTargets_DoDelete(t *testing.T) {
 		Projects: []internal.Project{{TargetID: "t1"}},
 	}
 	affected := map[string]bool{"org-1": true}
-	deleted, failed := cleanupEmptyTargets(ctx, mock, true, affected)
+	deleted, failed := cleanupEmptyTargets(ctx, mock, true, false, 2, affected)
 	if failed != 0 {
 		t.Errorf("failed = %d", failed)
 	}
@@ -767,10 +1914,117 @@ func TestCleanupEmptyTargets_DoDelete(t *testing.T) {
 	}
 }
 
-// TestCleanupEmptyTargets_WithTestdata runs cleanupEmptyTargets in dry-run using
-// targets and projects loaded from testdata, ensuring the mock data shape matches
-// what the real API returns and that the logic works with real-shaped data.
-func TestCleanupEmptyTargets_WithTestdata(t *testing.T) {
+func TestCleanupOrgEmptyTargets_OrgHeader(t *testing.T) {
+	ctx := context.Background()
+	mock := &mockSnykAPI{
+		Targets: []internal.APITarget{
+			{ID: "t1", DisplayName: "owner/repo", IntegrationType: "github"},
+			{ID: "t2", DisplayName: "owner/repo", IntegrationType: "github"},
+		},
+		Projects: []internal.Project{{TargetID: "t1"}},
+	}
+	var out bytes.Buffer
+	var warnings []string
+	cleanupOrgEmptyTargets(ctx, mock, false, false, "org-1", &out, &warnings)
+	if want := "Org org-1:\n  target t2 (owner/repo, github): empty, would be deleted\n"; out.String() != want {
+		t.Errorf("report = %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	mock.Targets = mock.Targets[:1]
+	cleanupOrgEmptyTargets(ctx, mock, false, false, "org-1", &out, &warnings)
+	if out.Len() != 0 {
+		t.Errorf("org with nothing to clean up: report = %q, want no header", out.String())
+	}
+}
+
+func TestCleanupEmptyTargets_ManyOrgs(t *testing.T) {
+	ctx := context.Background()
+	mock := &mockSnykAPI{
+		Targets: []internal.APITarget{
+			{ID: "t1", DisplayName: "owner/repo", IntegrationType: "github"},
+			{ID: "t2", DisplayName: "owner/repo", IntegrationType: "github"},
+		},
+		Projects:        []internal.Project{{TargetID: "t1"}},
+		DeleteTargetErr: fmt.Errorf("boom"),
+	}
+	affected := map[string]bool{"org-1": true, "org-2": true, "org-3": true, "org-4": true, "org-5": true}
+	if deleted, failed := cleanupEmptyTargets(ctx, mock, false, false, 2, affected); deleted != 5 || failed != 0 {
+		t.Errorf("dry run: deleted=%d failed=%d, want 5 and 0", deleted, failed)
+	}
+	if deleted, failed := cleanupEmptyTargets(ctx, mock, true, false, 2, affected); deleted != 0 || failed != 5 {
+		t.Errorf("delete errors: deleted=%d failed=%d, want 0 and 5", deleted, failed)
+	}
+}
+
+func TestWriteTargetCleanupSummary(t *testing.T) {
+	tests := []struct {
+		doDelete        bool
+		deleted, failed int
+		want            string
+	}{
+		{false, 0, 0, "No empty duplicate targets found.\n"},
+		{true, 0, 0, "No empty duplicate targets found.\n"},
+		{true, 3, 1, "Summary: 3 empty target(s) cleaned up, 1 failed.\n"},
+		{false, 2, 0, "Summary: 2 empty duplicate target(s) would be removed.\nRun with --delete to remove them.\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		writeTargetCleanupSummary(&buf, tt.doDelete, tt.deleted, tt.failed)
+		if buf.String() != tt.want {
+			t.Errorf("writeTargetCleanupSummary(%v, %d, %d) = %q, want %q", tt.doDelete, tt.deleted, tt.failed, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestTargetMatchKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		normalized bool
+		want       string
+	}{
+		{"Owner/Repo", false, "Owner/Repo"},
+		{"Owner/Repo (main)", false, "Owner/Repo (main)"},
+		{"Owner/Repo", true, "owner/repo"},
+		{"Owner/Repo (main)", true, "owner/repo"},
+		{"owner/repo(feature/x)", true, "owner/repo"},
+		{" owner/repo (main) ", true, "owner/repo"},
+		{"owner/repo (v2) (main)", true, "owner/repo (v2)"},
+	}
+	for _, tt := range tests {
+		if got := targetMatchKey(tt.name, tt.normalized); got != tt.want {
+			t.Errorf("targetMatchKey(%q, %v) = %q, want %q", tt.name, tt.normalized, got, tt.want)
+		}
+	}
+}
+
+func TestCleanupEmptyTargets_Normalized(t *testing.T) {
+	ctx := context.Background()
+	mock := &mockSnykAPI{
+		Targets: []internal.APITarget{
+			{ID: "t1", DisplayName: "owner/repo", IntegrationType: "github"},
+			{ID: "t2", DisplayName: "Owner/Repo (main)", IntegrationType: "github-cloud-app"},
+		},
+		Projects: []internal.Project{{TargetID: "t1"}},
+	}
+	affected := map[string]bool{"org-1": true}
+	if deleted, _ := cleanupEmptyTargets(ctx, mock, false, false, 2, affected); deleted != 0 {
+		t.Errorf("exact match: deleted = %d, want 0 (names differ)", deleted)
+	}
+	if deleted, _ := cleanupEmptyTargets(ctx, mock, false, true, 2, affected); deleted != 1 {
+		t.Errorf("normalized: deleted = %d, want 1 (empty t2)", deleted)
+	}
+
+	groups := findDuplicateTargets(mock.Targets, mock.Projects, true)
+	if len(groups) != 1 || !groups[0].empty["t2"] || groups[0].empty["t1"] {
+		t.Errorf("normalized groups = %+v, want one group with t2 empty", groups)
+	}
+}
+
+// TestCleanupEmptyTargets_WithTestdata runs cleanupEmptyTargets in dry-run using
+// targets and projects loaded from testdata, ensuring the mock data shape matches
+// what the real API returns and that the logic works with real-shaped data.
+func TestCleanupEmptyTargets_WithTestdata(t *testing.T) {
 	targets := loadMockTargetsFromTestdata(t)
 	projects := loadMockProjectsFromTestdata(t)
 	if targets == nil || projects == nil {
@@ -780,7 +2034,7 @@ func TestCleanupEmptyTargets_WithTestdata(t *testing.T) {
 	mock := &mockSnykAPI{Targets: targets, Projects: projects}
 	// One org so FetchTargets runs once; mock returns same targets for any org.
 	affected := map[string]bool{"a0000001-0001-4000-8000-000000000001": true}
-	deleted, failed := cleanupEmptyTargets(ctx, mock, false, affected)
+	deleted, failed := cleanupEmptyTargets(ctx, mock, false, false, 2, affected)
 	if failed != 0 {
 		t.Errorf("cleanupEmptyTargets with testdata: failed=%d", failed)
 	}
@@ -789,6 +2043,55 @@ func TestCleanupEmptyTargets_WithTestdata(t *testing.T) {
 	t.Logf("cleanupEmptyTargets with testdata: deleted=%d", deleted)
 }
 
+// --- Dedup: duplicate target report ---
+
+func TestFindDuplicateTargets(t *testing.T) {
+	targets := []internal.APITarget{
+		{ID: "t2", DisplayName: "owner/repo", IntegrationType: "github", CreatedAt: "2024-02-01"},
+		{ID: "t1", DisplayName: "owner/repo", IntegrationType: "github-cloud-app", CreatedAt: "2024-01-01"},
+		{ID: "t3", DisplayName: "owner/other", IntegrationType: "github", CreatedAt: "2024-01-01"},
+	}
+	projects := []internal.Project{{TargetID: "t1"}, {TargetID: "t2"}, {TargetID: "t3"}}
+	groups := findDuplicateTargets(targets, projects, false)
+	if len(groups) != 1 {
+		t.Fatalf("got %d groups, want 1", len(groups))
+	}
+	g := groups[0]
+	if g.displayName != "owner/repo" || g.targets[0].ID != "t1" || g.targets[1].ID != "t2" {
+		t.Errorf("group = %+v, want owner/repo with t1 then t2", g)
+	}
+	if len(g.empty) != 0 {
+		t.Errorf("both targets have projects; empty = %v", g.empty)
+	}
+
+	groups = findDuplicateTargets(targets, []internal.Project{{TargetID: "t1"}}, false)
+	if !groups[0].empty["t2"] || groups[0].empty["t1"] {
+		t.Errorf("empty = %v, want only t2", groups[0].empty)
+	}
+}
+
+func TestReportDuplicateTargets(t *testing.T) {
+	ctx := context.Background()
+	mock := &mockSnykAPI{
+		Targets: []internal.APITarget{
+			{ID: "t1", DisplayName: "owner/repo"},
+			{ID: "t2", DisplayName: "owner/repo"},
+		},
+		Projects: []internal.Project{{TargetID: "t1"}, {TargetID: "t2"}},
+	}
+	orgs := []internal.Org{{ID: "org-1"}, {ID: "org-2"}}
+	groups, failed := reportDuplicateTargets(ctx, mock, orgs, false)
+	if groups != 2 || failed != 0 {
+		t.Errorf("groups=%d failed=%d, want 2 and 0", groups, failed)
+	}
+
+	mock.TargetsErr = fmt.Errorf("boom")
+	groups, failed = reportDuplicateTargets(ctx, mock, orgs, false)
+	if groups != 0 || failed != 2 {
+		t.Errorf("with error: groups=%d failed=%d, want 0 and 2", groups, failed)
+	}
+}
+
 // --- Dedup: findDuplicateGroups ---
 
 // TestFindDuplicateGroups ensures projects are grouped by name, only groups with
@@ -799,7 +2102,7 @@ func TestFindDuplicateGroups(t *testing.T) {
 			{Name: "a", Created: "2020-01-01"},
 			{Name: "b", Created: "2020-01-02"},
 		}
-		groups := findDuplicateGroups(projects, false)
+		groups := findDuplicateGroups(projects, nameDedupKey(false))
 		if len(groups) != 0 {
 			t.Errorf("got %d groups, want 0", len(groups))
 		}
@@ -810,7 +2113,7 @@ func TestFindDuplicateGroups(t *testing.T) {
 			{Name: "same", Created: "2020-01-02"},
 			{Name: "same", Created: "2020-01-01"},
 		}
-		groups := findDuplicateGroups(projects, false)
+		groups := findDuplicateGroups(projects, nameDedupKey(false))
 		if len(groups) != 1 {
 			t.Fatalf("got %d groups, want 1", len(groups))
 		}
@@ -830,7 +2133,7 @@ func TestFindDuplicateGroups(t *testing.T) {
 			{Name: "repo-b", Created: "2020-02-01"},
 			{Name: "repo-b", Created: "2020-02-02"},
 		}
-		groups := findDuplicateGroups(projects, false)
+		groups := findDuplicateGroups(projects, nameDedupKey(false))
 		if len(groups) != 2 {
 			t.Errorf("got %d groups, want 2", len(groups))
 		}
@@ -841,7 +2144,7 @@ func TestFindDuplicateGroups(t *testing.T) {
 			{Name: "owner/repo", Origin: "github", Created: "2020-01-01"},
 			{Name: "owner/repo", Origin: "gitlab", Created: "2020-01-02"},
 		}
-		groups := findDuplicateGroups(projects, true)
+		groups := findDuplicateGroups(projects, nameDedupKey(true))
 		if len(groups) != 0 {
 			t.Errorf("considerOrigin=true: same name from github and gitlab should not be duplicates; got %d groups", len(groups))
 		}
@@ -852,19 +2155,170 @@ func TestFindDuplicateGroups(t *testing.T) {
 			{Name: "owner/repo", Origin: "github", Created: "2020-01-02"},
 			{Name: "owner/repo", Origin: "github", Created: "2020-01-01"},
 		}
-		groups := findDuplicateGroups(projects, true)
+		groups := findDuplicateGroups(projects, nameDedupKey(true))
 		if len(groups) != 1 || len(groups[0].projects) != 2 {
 			t.Errorf("considerOrigin=true: same name and origin should be one group of 2; got %d groups", len(groups))
 		}
 	})
 }
 
+// TestFindDuplicateGroups_Canonical checks that canonical mode collapses the same
+// repo+branch+manifest across origins while keeping other branches and manifests.
+func TestFindDuplicateGroups_Canonical(t *testing.T) {
+	projects := []internal.Project{
+		{ID: "a", Name: "ws/repo:package.json", Origin: "bitbucket-cloud", Branch: "main", Created: "2020-01-01"},
+		{ID: "b", Name: "ws/repo:package.json", Origin: "bitbucket-connect-app", Branch: "main", Created: "2020-01-02"},
+		{ID: "c", Name: "ws/repo:package.json", Origin: "bitbucket-connect-app", Branch: "develop", Created: "2020-01-03"},
+		{ID: "d", Name: "ws/repo:api/go.mod", Origin: "bitbucket-connect-app", Branch: "main", Created: "2020-01-04"},
+		{ID: "e", Name: "img:latest", Origin: "docker-hub", Created: "2020-01-05"},
+	}
+	groups := findDuplicateGroups(projects, canonicalDedupKey)
+	if len(groups) != 1 {
+		t.Fatalf("got %d groups, want 1", len(groups))
+	}
+	if len(groups[0].projects) != 2 || groups[0].projects[0].ID != "a" || groups[0].projects[1].ID != "b" {
+		t.Errorf("group = %+v, want a (keep) then b", groups[0].projects)
+	}
+}
+
+// TestFindDuplicateGroups_NormalizedNames checks that --normalize-names groups
+// case and manifest variants of one repo on one branch, keeping other branches
+// and repos apart.
+func TestFindDuplicateGroups_NormalizedNames(t *testing.T) {
+	projects := []internal.Project{
+		{ID: "a", Name: "PROJ/Repo:pom.xml", Origin: "github", Branch: "main", Created: "2020-01-01"},
+		{ID: "b", Name: "proj/repo:build.gradle", Origin: "github-enterprise", Branch: "main", Created: "2020-01-02"},
+		{ID: "c", Name: "proj/repo:pom.xml", Origin: "github", Branch: "develop", Created: "2020-01-03"},
+		{ID: "d", Name: "proj/other:pom.xml", Origin: "github", Branch: "main", Created: "2020-01-04"},
+	}
+	groups := findDuplicateGroups(projects, normalizedNameKey(false))
+	if len(groups) != 1 || len(groups[0].projects) != 2 || groups[0].projects[0].ID != "a" || groups[0].projects[1].ID != "b" {
+		t.Fatalf("groups = %+v, want one group: a (keep) then b", groups)
+	}
+	got := matchedNames(groups[0].key, []string{"PROJ/Repo:pom.xml", "proj/repo:build.gradle"})
+	if want := "PROJ/Repo:pom.xml, proj/repo:build.gradle  (matched as proj/repo | main)"; got != want {
+		t.Errorf("matchedNames = %q, want %q", got, want)
+	}
+	if got := matchedNames("x", []string{"same", "same"}); got != "" {
+		t.Errorf("identical names: matchedNames = %q, want empty", got)
+	}
+
+	if groups := findDuplicateGroups(projects, normalizedNameKey(true)); len(groups) != 0 {
+		t.Errorf("considerOrigin: got %d groups, want 0 (origins differ)", len(groups))
+	}
+}
+
+func TestDedupKeyForMode(t *testing.T) {
+	for _, mode := range []string{"name", "canonical"} {
+		if _, err := dedupKeyForMode(mode, false); err != nil {
+			t.Errorf("mode %q: %v", mode, err)
+		}
+	}
+	if _, err := dedupKeyForMode("fuzzy", false); err == nil {
+		t.Error("unknown mode: want error")
+	}
+	if _, err := dedupKeyForMode("canonical", true); err == nil || !strings.Contains(err.Error(), "--strict-dedup") {
+		t.Errorf("canonical with considerOrigin: err = %v, want an error naming the conflicting flags", err)
+	}
+}
+
+// TestStrictDedupOrigins checks the key --strict-dedup selects: the same repo
+// imported from two origins stays as two projects, while the default name key
+// merges them into one duplicate group.
+func TestStrictDedupOrigins(t *testing.T) {
+	projects := []internal.Project{
+		{ID: "a", Name: "acme/app:package.json", Origin: "github", Created: "2020-01-01"},
+		{ID: "b", Name: "acme/app:package.json", Origin: "gitlab", Created: "2020-01-02"},
+	}
+	for _, tt := range []struct {
+		strict bool
+		want   int
+	}{{true, 0}, {false, 1}} {
+		keyFn, err := dedupKeyForMode("name", tt.strict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		groups := findDuplicateGroups(projects, keyFn)
+		if len(groups) != tt.want {
+			t.Fatalf("strict=%v: got %d groups, want %d", tt.strict, len(groups), tt.want)
+		}
+		if tt.want == 1 && (len(groups[0].projects) != 2 || groups[0].projects[0].ID != "a") {
+			t.Errorf("strict=%v: group = %+v, want a (keep) then b", tt.strict, groups[0].projects)
+		}
+	}
+}
+
+func TestDedupKeyPresets(t *testing.T) {
+	base := internal.Project{Name: "acme/app:package.json", Origin: "github", Branch: "main", TargetID: "t1"}
+	with := func(f func(p *internal.Project)) internal.Project {
+		p := base
+		f(&p)
+		return p
+	}
+	otherOrigin := with(func(p *internal.Project) { p.Origin = "github-cloud-app"; p.TargetID = "t2" })
+	otherBranch := with(func(p *internal.Project) { p.Branch = "dev" })
+	sameTarget := with(func(p *internal.Project) { p.Name = "ACME/app:package.json" })
+
+	// For each preset: whether base is grouped with otherOrigin, otherBranch, sameTarget.
+	tests := map[string][3]bool{
+		"name":           {true, true, false},
+		"name+origin":    {false, true, false},
+		"name+branch":    {true, false, false},
+		"target":         {false, true, true},
+		"canonical-repo": {true, false, false},
+	}
+	for preset, want := range tests {
+		keyFn, err := dedupKeyForPreset(preset)
+		if err != nil {
+			t.Fatalf("preset %q: %v", preset, err)
+		}
+		for i, p := range []internal.Project{otherOrigin, otherBranch, sameTarget} {
+			if got := keyFn(base) == keyFn(p); got != want[i] {
+				t.Errorf("preset %q, case %d: grouped = %v, want %v", preset, i, got, want[i])
+			}
+		}
+	}
+	if _, err := dedupKeyForPreset("fuzzy"); err == nil {
+		t.Error("unknown preset: want error")
+	}
+}
+
+func TestDedupGroupScope(t *testing.T) {
+	tests := []struct {
+		name      string
+		scope     string
+		withinOrg bool
+		explicit  map[string]bool
+		want      bool
+		wantErr   bool
+	}{
+		{"defaults", "org", true, nil, false, false},
+		{"legacy withinOrg=false", "org", false, map[string]bool{"withinOrg": true}, true, false},
+		{"scope group", "group", true, map[string]bool{"scope": true}, true, false},
+		{"scope org", "org", true, map[string]bool{"scope": true}, false, false},
+		{"agreeing flags", "group", false, map[string]bool{"scope": true, "withinOrg": true}, true, false},
+		{"contradicting flags", "org", false, map[string]bool{"scope": true, "withinOrg": true}, false, true},
+		{"unknown scope", "tenant", true, map[string]bool{"scope": true}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dedupGroupScope(tt.scope, tt.withinOrg, tt.explicit)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("group scope = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestFindDuplicateGroupsGroupWide(t *testing.T) {
 	items := []projectInOrg{
 		{orgID: "org-1", orgLabel: "Org 1", project: internal.Project{Name: "repo", Created: "2020-01-01"}},
 		{orgID: "org-2", orgLabel: "Org 2", project: internal.Project{Name: "repo", Created: "2020-01-02"}},
 	}
-	groups := findDuplicateGroupsGroupWide(items, false)
+	groups := findDuplicateGroupsGroupWide(items, nameDedupKey(false))
 	if len(groups) != 1 {
 		t.Fatalf("got %d groups, want 1 (same name across orgs)", len(groups))
 	}
@@ -1025,3 +2479,1287 @@ func TestRefreshOutput_JSONRoundTrip(t *testing.T) {
 		t.Errorf("Targets mismatch: got %+v", decoded.Targets)
 	}
 }
+
+// --- limiter ---
+
+// concurrencyProbeAPI is a SnykAPI whose FetchProjects and DeleteProject record
+// the peak number of in-flight calls. Used to verify that withLimiter bounds concurrency.
+type concurrencyProbeAPI struct {
+	mockSnykAPI
+	mu               sync.Mutex
+	inFlight, peak   int
+	deletesInFlight  int
+	deletePeak       int
+	deleteCallsTotal int
+}
+
+// track increments *cur, updates *peak, sleeps briefly, then decrements *cur.
+func (p *concurrencyProbeAPI) track(cur, peak *int) {
+	p.mu.Lock()
+	*cur++
+	if *cur > *peak {
+		*peak = *cur
+	}
+	p.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	p.mu.Lock()
+	*cur--
+	p.mu.Unlock()
+}
+
+func (p *concurrencyProbeAPI) FetchProjects(ctx context.Context, orgID string) ([]internal.Project, error) {
+	p.track(&p.inFlight, &p.peak)
+	return nil, nil
+}
+
+func (p *concurrencyProbeAPI) DeleteProject(ctx context.Context, orgID, projectID string) error {
+	p.mu.Lock()
+	p.deleteCallsTotal++
+	p.mu.Unlock()
+	p.track(&p.deletesInFlight, &p.deletePeak)
+	return nil
+}
+
+func TestWithLimiter_BoundsConcurrentCalls(t *testing.T) {
+	ctx := context.Background()
+	probe := &concurrencyProbeAPI{}
+	api := withLimiter(probe, newLimiter(2), nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = api.FetchProjects(ctx, "org-1")
+		}()
+	}
+	wg.Wait()
+	if probe.peak > 2 {
+		t.Errorf("peak concurrent calls = %d, want <= 2", probe.peak)
+	}
+}
+
+// TestWithLimiter_SeparateDeleteLimit verifies that deletes are bounded by the
+// delete limiter rather than the read limiter (dedup --delete-concurrency).
+func TestWithLimiter_SeparateDeleteLimit(t *testing.T) {
+	ctx := context.Background()
+	probe := &concurrencyProbeAPI{}
+	api := withLimiter(probe, newLimiter(8), newLimiter(1))
+	reqs := make([]projectDeletion, 6)
+	for i := range reqs {
+		reqs[i] = projectDeletion{orgID: "org-1", projectID: fmt.Sprintf("p-%d", i)}
+	}
+	errs := deleteProjectsConcurrently(ctx, api, reqs)
+	if len(errs) != len(reqs) {
+		t.Fatalf("len(errs) = %d, want %d", len(errs), len(reqs))
+	}
+	if probe.deletePeak != 1 {
+		t.Errorf("peak concurrent deletes = %d, want 1", probe.deletePeak)
+	}
+	if probe.deleteCallsTotal != len(reqs) {
+		t.Errorf("delete calls = %d, want %d", probe.deleteCallsTotal, len(reqs))
+	}
+}
+
+func TestValidateConcurrency(t *testing.T) {
+	if err := validateConcurrency("concurrency", 1); err != nil {
+		t.Errorf("1: unexpected error %v", err)
+	}
+	if err := validateConcurrency("delete-concurrency", 0); err == nil {
+		t.Error("0: want error")
+	}
+}
+
+func TestLimiter_Ramp(t *testing.T) {
+	ctx := context.Background()
+	lim := newLimiter(3)
+	lim.ramp(ctx, 40*time.Millisecond)
+
+	if err := lim.acquire(ctx); err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+	// Only one slot is free at the start of the ramp.
+	short, cancel := context.WithTimeout(ctx, 5*time.Millisecond)
+	defer cancel()
+	if err := lim.acquire(short); err == nil {
+		t.Fatal("second acquire at ramp start should block")
+	}
+	// After the ramp, the remaining slots become available.
+	waitCtx, cancelWait := context.WithTimeout(ctx, time.Second)
+	defer cancelWait()
+	for i := 0; i < 2; i++ {
+		if err := lim.acquire(waitCtx); err != nil {
+			t.Fatalf("acquire after ramp: %v", err)
+		}
+	}
+}
+
+func TestLimiter_RampDisabled(t *testing.T) {
+	lim := newLimiter(2)
+	lim.ramp(context.Background(), 0)
+	if len(lim.sem) != 0 {
+		t.Errorf("ramp(0) should leave all slots free; held = %d", len(lim.sem))
+	}
+}
+
+func TestLimiter_SaturationWarning(t *testing.T) {
+	ctx := context.Background()
+	lim := newLimiter(1)
+	for i := 0; i < 30; i++ {
+		lim.acquire(ctx)
+		if i%3 != 0 {
+			// Queue a second caller behind the held slot so it counts as blocked.
+			done := make(chan struct{})
+			want := lim.blocked.Load() + 1
+			go func() { lim.acquire(ctx); close(done) }()
+			for lim.blocked.Load() < want {
+				time.Sleep(100 * time.Microsecond)
+			}
+			lim.release()
+			<-done
+		}
+		lim.release()
+	}
+	ratio, samples := lim.saturation()
+	if samples != 50 || ratio < 0.39 || ratio > 0.41 {
+		t.Fatalf("saturation = %.2f over %d, want 0.40 over 50", ratio, samples)
+	}
+	if msg := lim.saturationWarning(100); msg != "" {
+		t.Errorf("40%% saturation should not warn, got %q", msg)
+	}
+
+	busy := newLimiter(4)
+	busy.acquired.Store(100)
+	busy.blocked.Store(80)
+	if msg := busy.saturationWarning(2); msg != "" {
+		t.Errorf("few retries should not warn, got %q", msg)
+	}
+	if msg := busy.saturationWarning(20); !strings.Contains(msg, "80% of 100 API call(s)") || !strings.Contains(msg, "currently 4") {
+		t.Errorf("saturated with many retries: got %q", msg)
+	}
+}
+
+func TestLimiter_AcquireRespectsContext(t *testing.T) {
+	lim := newLimiter(1)
+	if err := lim.acquire(context.Background()); err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := lim.acquire(ctx); err == nil {
+		t.Error("acquire on full limiter with cancelled context: want error")
+	}
+	lim.release()
+}
+
+func TestValidateMaxConcurrency(t *testing.T) {
+	for _, n := range []int{0, 5, 20} {
+		if err := validateMaxConcurrency(n, 5); err != nil {
+			t.Errorf("%d: unexpected error %v", n, err)
+		}
+	}
+	if err := validateMaxConcurrency(3, 5); err == nil {
+		t.Error("ceiling below --concurrency: want error")
+	}
+}
+
+func TestLimiter_Adjust(t *testing.T) {
+	ctx := context.Background()
+	lim := newRunLimiter(4, 8)
+	free := func() int { return cap(lim.sem) - len(lim.sem) }
+	if !lim.adaptive || free() != 4 || lim.limit.Load() != 4 {
+		t.Fatalf("start: adaptive=%v free=%d limit=%d, want adaptive with 4", lim.adaptive, free(), lim.limit.Load())
+	}
+
+	for _, step := range []struct {
+		throttled bool
+		want      int
+	}{
+		{false, 5}, {false, 6}, {true, 3}, {true, 1}, {true, 1},
+		{false, 2}, {false, 3}, {false, 4}, {false, 5}, {false, 6}, {false, 7}, {false, 8}, {false, 8},
+	} {
+		lim.adjust(ctx, step.throttled)
+		if got := int(lim.limit.Load()); got != step.want || free() != step.want {
+			t.Fatalf("after adjust(throttled=%v): limit=%d free=%d, want %d", step.throttled, got, free(), step.want)
+		}
+	}
+	if lim.lowest.Load() != 1 {
+		t.Errorf("lowest = %d, want 1", lim.lowest.Load())
+	}
+
+	if fixed := newRunLimiter(4, 0); fixed.adaptive || cap(fixed.sem) != 4 {
+		t.Errorf("maxConcurrency 0: adaptive=%v cap=%d, want fixed limiter of 4", fixed.adaptive, cap(fixed.sem))
+	}
+}
+
+func TestLimiter_AdjustWaitsForInFlight(t *testing.T) {
+	ctx := context.Background()
+	lim := newAdaptiveLimiter(2, 2)
+	lim.acquire(ctx)
+	lim.acquire(ctx)
+
+	done := make(chan struct{})
+	go func() { lim.adjust(ctx, true); close(done) }()
+	select {
+	case <-done:
+		t.Fatal("shrinking should wait for an in-flight call to release its slot")
+	case <-time.After(10 * time.Millisecond):
+	}
+	lim.release()
+	<-done
+	if lim.limit.Load() != 1 {
+		t.Errorf("limit = %d, want 1", lim.limit.Load())
+	}
+	lim.release()
+	if free := cap(lim.sem) - len(lim.sem); free != 1 {
+		t.Errorf("free slots = %d, want 1", free)
+	}
+}
+
+// --- orgs ---
+
+func TestRenderOrgReport(t *testing.T) {
+	ok := refreshOrgResult{
+		orgLabel: "beta",
+		projects: 5,
+		intMeta:  map[string]string{"i-gh": "github", "i-az": "azure-repos"},
+		targets:  []internal.ImportTarget{{IntegrationID: "i-gh"}, {IntegrationID: "i-gh"}, {IntegrationID: "i-az"}},
+	}
+	failed := refreshOrgResult{orgLabel: "alpha", err: errors.New("boom")}
+	skipped := refreshOrgResult{orgLabel: "gamma", skippedStatus: 403}
+	rows := []orgReportRow{newOrgReportRow(ok), newOrgReportRow(failed), newOrgReportRow(skipped)}
+
+	got, err := renderOrgReport(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `ORG    STATUS         PROJECTS  TARGETS  azure-repos  github
+alpha  failed         0         0        0            0
+beta   ok             5         3        1            2
+gamma  skipped (403)  0         0        0            0
+TOTAL  3 org(s)       5         3        1            2
+`
+	if string(got) != want {
+		t.Errorf("report =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRenderOriginReport(t *testing.T) {
+	org := internal.Org{ID: "org-1"}
+	var totals refreshCounts
+	for _, projects := range [][]internal.Project{
+		{{Name: "a/b", Origin: "github"}, {Name: "img", Origin: "docker-hub"}, {Name: "a/c", Origin: "bitbucket-cloud-app"}},
+		{{Name: "a/d", Origin: "github"}, {Name: "g/h", Origin: "gitlab"}, {Name: "x", Origin: ""}},
+	} {
+		_, counts := projectsToImportTargets(org, projects, map[string]string{}, refreshOptions{originReport: true})
+		totals.add(counts)
+	}
+
+	got, err := renderOriginReport(totals.origins, totals.originOrgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `ORIGIN               PROJECTS  ORGS  SUPPORTED  INTEGRATION
+github               2         2     yes        github
+(none)               1         1     no         -
+bitbucket-cloud-app  1         1     yes        bitbucket-connect-app
+docker-hub           1         1     no         -
+gitlab               1         1     no         -
+`
+	if string(got) != want {
+		t.Errorf("origin report =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRenderOrgs(t *testing.T) {
+	orgs := []internal.Org{
+		{ID: "o2", Name: "Zeta", Slug: "zeta"},
+		{ID: "o1", Name: "Alpha Team", Slug: "alpha-team"},
+	}
+
+	table, err := renderOrgs(orgs, "g1", "table")
+	if err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	want := "ID  NAME        SLUG\no1  Alpha Team  alpha-team\no2  Zeta        zeta\n"
+	if string(table) != want {
+		t.Errorf("table =\n%s\nwant\n%s", table, want)
+	}
+
+	data, err := renderOrgs(orgs, "g1", "json")
+	if err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	var got []internal.Org
+	if err := json.Unmarshal(data, &got); err != nil || len(got) != 2 || got[0] != orgs[1] {
+		t.Errorf("json = %s (err %v)", data, err)
+	}
+	if data, _ := renderOrgs(nil, "g1", "json"); strings.TrimSpace(string(data)) != "[]" {
+		t.Errorf("empty json = %s, want []", data)
+	}
+
+	ids, err := renderOrgs(orgs, "g1", "ids")
+	if err != nil {
+		t.Fatalf("ids: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "orgs.txt")
+	if err := os.WriteFile(path, ids, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readOrgFile(path)
+	if err != nil || strings.Join(read, ",") != "o1,o2" {
+		t.Errorf("ids output read back as %v (err %v), want [o1 o2]", read, err)
+	}
+
+	if _, err := renderOrgs(orgs, "g1", "csv"); err == nil {
+		t.Error("unknown format: want error")
+	}
+}
+
+// --- whoami ---
+
+func TestRunWhoamiChecks(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("token rejected", func(t *testing.T) {
+		mock := &mockSnykAPI{UserErr: fmt.Errorf("fetch user: %w", internal.ErrUnauthorized)}
+		_, err := runWhoamiChecks(ctx, mock)
+		if !errors.Is(err, internal.ErrUnauthorized) {
+			t.Errorf("err = %v, want ErrUnauthorized", err)
+		}
+	})
+
+	t.Run("user and groups", func(t *testing.T) {
+		mock := &mockSnykAPI{
+			User:   internal.User{ID: "u-1", Username: "alice"},
+			Groups: []internal.Group{{ID: "g-1", Name: "Acme"}},
+		}
+		rep, err := runWhoamiChecks(ctx, mock)
+		if err != nil {
+			t.Fatalf("runWhoamiChecks: %v", err)
+		}
+		if rep.user.Username != "alice" || len(rep.groups) != 1 || rep.groups[0].ID != "g-1" || rep.groupsErr != nil {
+			t.Errorf("report = %+v", rep)
+		}
+	})
+
+	t.Run("groups error is recorded", func(t *testing.T) {
+		mock := &mockSnykAPI{GroupsErr: fmt.Errorf("status 403")}
+		rep, err := runWhoamiChecks(ctx, mock)
+		if err != nil {
+			t.Fatalf("runWhoamiChecks: %v", err)
+		}
+		if rep.groupsErr == nil {
+			t.Errorf("report = %+v, want groupsErr", rep)
+		}
+	})
+}
+
+// --- preflight ---
+
+func TestRunPreflightChecks(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("token rejected", func(t *testing.T) {
+		mock := &mockSnykAPI{UserErr: fmt.Errorf("authentication failed (401)")}
+		if _, err := runPreflightChecks(ctx, mock, "group-1", ""); err == nil {
+			t.Error("want error when user fetch fails")
+		}
+	})
+
+	t.Run("token only", func(t *testing.T) {
+		mock := &mockSnykAPI{User: internal.User{ID: "u-1", Username: "alice"}}
+		rep, err := runPreflightChecks(ctx, mock, "", "")
+		if err != nil {
+			t.Fatalf("runPreflightChecks: %v", err)
+		}
+		if rep.user.Username != "alice" || rep.scope != "" {
+			t.Errorf("report = %+v", rep)
+		}
+	})
+
+	t.Run("group access denied is recorded", func(t *testing.T) {
+		mock := &mockSnykAPI{GroupAccessErr: fmt.Errorf("status 403")}
+		rep, err := runPreflightChecks(ctx, mock, "group-1", "")
+		if err != nil {
+			t.Fatalf("runPreflightChecks: %v", err)
+		}
+		if rep.scope != "group group-1" || rep.accessErr == nil {
+			t.Errorf("report = %+v, want group scope with access error", rep)
+		}
+	})
+
+	t.Run("org access ok", func(t *testing.T) {
+		mock := &mockSnykAPI{}
+		rep, err := runPreflightChecks(ctx, mock, "", "org-1")
+		if err != nil {
+			t.Fatalf("runPreflightChecks: %v", err)
+		}
+		if rep.scope != "org org-1" || rep.accessErr != nil {
+			t.Errorf("report = %+v, want org scope without error", rep)
+		}
+	})
+}
+
+func TestWriteIntegrationProbe(t *testing.T) {
+	integrations := map[string]string{"github": "int-gh", "bitbucket-connect-app": "int-bb"}
+
+	var buf bytes.Buffer
+	missing, err := writeIntegrationProbe(&buf, "org-1", integrations, "bitbucket-connect-app")
+	if err != nil || missing != 0 {
+		t.Fatalf("bitbucket-connect-app: missing=%d err=%v", missing, err)
+	}
+	out := buf.String()
+	for _, want := range []string{"Integrations in org org-1 (2):", "  github: int-gh", "bitbucket-cloud-app", "bitbucket-connect-app  bitbucket-connect-app  int-bb"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "azure-repos") {
+		t.Errorf("type filter should hide other origins:\n%s", out)
+	}
+
+	buf.Reset()
+	missing, err = writeIntegrationProbe(&buf, "org-1", integrations, "all")
+	if err != nil || missing != len(internal.SCMOrigins())-3 {
+		t.Errorf("all: missing=%d err=%v, want every origin but github, bitbucket-connect-app, bitbucket-cloud-app", missing, err)
+	}
+	if !strings.Contains(buf.String(), "azure-repos            azure-repos            MISSING") {
+		t.Errorf("all: azure-repos should be flagged missing:\n%s", buf.String())
+	}
+
+	if _, err := writeIntegrationProbe(&buf, "org-1", integrations, "gitlab"); err == nil {
+		t.Error("unsupported type: want error")
+	}
+}
+
+// --- notify ---
+
+func TestNewNotifier_Validation(t *testing.T) {
+	if _, err := newNotifier("", "sometimes"); err == nil {
+		t.Error("invalid --notify-on: want error")
+	}
+	if _, err := newNotifier("ftp://example.com/hook", "always"); err == nil {
+		t.Error("non-http URL: want error")
+	}
+	n, err := newNotifier("", "always")
+	if err != nil || n.shouldNotify(true) {
+		t.Errorf("empty URL should be a disabled notifier: n=%+v err=%v", n, err)
+	}
+}
+
+func TestNotifier_ShouldNotify(t *testing.T) {
+	tests := []struct {
+		on      string
+		success bool
+		want    bool
+	}{
+		{"always", true, true},
+		{"always", false, true},
+		{"success", true, true},
+		{"success", false, false},
+		{"failure", true, false},
+		{"failure", false, true},
+	}
+	for _, tt := range tests {
+		n := &notifier{url: "https://example.com/hook", on: tt.on}
+		if got := n.shouldNotify(tt.success); got != tt.want {
+			t.Errorf("on=%s success=%v: got %v, want %v", tt.on, tt.success, got, tt.want)
+		}
+	}
+}
+
+func TestNotifier_PostsSummary(t *testing.T) {
+	var got runSummary
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("method=%s content-type=%s", r.Method, r.Header.Get("Content-Type"))
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	n, err := newNotifier(srv.URL, "always")
+	if err != nil {
+		t.Fatalf("newNotifier: %v", err)
+	}
+	n.notify(context.Background(), runSummary{Command: "refresh", Success: true, Targets: 3, OrgsProcessed: 2, OrgsFailed: 1})
+	if got.Command != "refresh" || got.Targets != 3 || got.OrgsProcessed != 2 || got.OrgsFailed != 1 {
+		t.Errorf("received summary = %+v", got)
+	}
+}
+
+// --- progress ---
+
+// TestProgress_ConcurrentUpdates exercises the tracker from many goroutines
+// while snapshots are taken; run with -race to verify it is race-free.
+func TestProgress_ConcurrentUpdates(t *testing.T) {
+	p := newProgress(20)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			label := fmt.Sprintf("org-%d", i)
+			p.start(label)
+			_ = p.snapshot().String()
+			var err error
+			if i%5 == 0 {
+				err = fmt.Errorf("failed")
+			}
+			p.finish(label, err)
+		}(i)
+	}
+	wg.Wait()
+	s := p.snapshot()
+	if s.done != 20 || s.failed != 4 || s.queued != 0 || len(s.active) != 0 {
+		t.Errorf("snapshot = %+v, want 20 done, 4 failed, none queued or active", s)
+	}
+}
+
+func TestProgressSnapshot_String(t *testing.T) {
+	p := newProgress(3)
+	p.start("Slow Org (slow)")
+	p.start("done-org")
+	p.finish("done-org", nil)
+	out := p.snapshot().String()
+	if !strings.Contains(out, "1/3 org(s) done") || !strings.Contains(out, "1 in flight, 1 queued") {
+		t.Errorf("summary line missing counts: %q", out)
+	}
+	if !strings.Contains(out, "in flight: Slow Org (slow)") {
+		t.Errorf("in-flight org not listed: %q", out)
+	}
+}
+
+func TestEstimateETA(t *testing.T) {
+	m := time.Minute
+	if _, _, ok := estimateETA(10*m, 2, 8, []time.Duration{m, m}); ok {
+		t.Error("estimateETA gave an estimate before etaMinDone orgs finished")
+	}
+	eta, typical, ok := estimateETA(10*m, 5, 5, []time.Duration{2 * m, 2 * m, 2 * m, 2 * m, 2 * m})
+	if !ok || eta != 10*m || typical != 10*m {
+		t.Errorf("even orgs: eta = %v, typical = %v, ok = %v; want 10m, 10m, true", eta, typical, ok)
+	}
+
+	// One huge org makes the mean a poor guide: the ETA becomes a range.
+	s := progressSnapshot{total: 10, done: 5, elapsed: 10 * m, orgTimes: []time.Duration{m, m, m, m, 36 * m}}
+	if got := s.etaText(); got != "ETA 1m0s-10m0s (org sizes vary widely)" {
+		t.Errorf("skewed etaText = %q", got)
+	}
+	s.orgTimes = []time.Duration{2 * m, 2 * m, 2 * m, 2 * m, 2 * m}
+	if got := s.etaText(); got != "ETA ~10m0s" {
+		t.Errorf("even etaText = %q", got)
+	}
+	s.done = 10
+	if got := s.etaText(); got != "" {
+		t.Errorf("etaText when done = %q, want empty", got)
+	}
+	s.done = 1
+	if got := s.etaText(); !strings.HasPrefix(got, "ETA after 3 org(s)") {
+		t.Errorf("early etaText = %q", got)
+	}
+}
+
+func TestProgress_Requeue(t *testing.T) {
+	p := newProgress(3)
+	for i, err := range []error{nil, fmt.Errorf("failed"), fmt.Errorf("failed")} {
+		label := fmt.Sprintf("org-%d", i)
+		p.start(label)
+		p.finish(label, err)
+	}
+	p.requeue(2)
+	s := p.snapshot()
+	if s.done != 1 || s.failed != 0 || s.queued != 2 {
+		t.Errorf("snapshot = %+v, want 1 done, 0 failed, 2 queued", s)
+	}
+}
+
+func TestRetriedOrgLabels(t *testing.T) {
+	got := retriedOrgLabels(map[string]string{"o2": "Beta (beta)", "o1": "Alpha (alpha)"})
+	if strings.Join(got, ",") != "Alpha (alpha),Beta (beta)" {
+		t.Errorf("retriedOrgLabels = %v, want sorted labels", got)
+	}
+}
+
+func TestDiffRefreshOutputs(t *testing.T) {
+	tgt := func(org, name string) internal.ImportTarget {
+		return internal.ImportTarget{OrgID: org, IntegrationID: "int-1", Target: internal.Target{Owner: "acme", Name: name, Branch: "main"}}
+	}
+	old := RefreshOutput{
+		Orgs:         map[string]OrgMeta{"o1": {Name: "Org One", Slug: "one"}, "o2": {Name: "Gone"}},
+		Integrations: map[string]string{"int-1": "github"},
+		Targets:      []internal.ImportTarget{tgt("o1", "kept"), tgt("o1", "removed")},
+	}
+	new := RefreshOutput{
+		Orgs:         map[string]OrgMeta{"o1": {Name: "Org One Renamed", Slug: "one"}},
+		Integrations: map[string]string{"int-1": "github", "int-2": "gitlab"},
+		Targets:      []internal.ImportTarget{tgt("o1", "kept"), tgt("o1", "added-b"), tgt("o1", "added-a")},
+	}
+	d := diffRefreshOutputs(old, new)
+	if len(d.Added) != 2 || d.Added[0].Target.Name != "added-a" || d.Added[1].Target.Name != "added-b" {
+		t.Errorf("added = %+v, want added-a, added-b", d.Added)
+	}
+	if len(d.Removed) != 1 || d.Removed[0].Target.Name != "removed" {
+		t.Errorf("removed = %+v, want removed", d.Removed)
+	}
+	wantOrgs := []metaChange{
+		{ID: "o1", Old: "Org One (one)", New: "Org One Renamed (one)"},
+		{ID: "o2", Old: "Gone"},
+	}
+	if fmt.Sprint(d.OrgChanges) != fmt.Sprint(wantOrgs) {
+		t.Errorf("orgChanges = %+v, want %+v", d.OrgChanges, wantOrgs)
+	}
+	if len(d.IntegrationChanges) != 1 || d.IntegrationChanges[0] != (metaChange{ID: "int-2", New: "gitlab"}) {
+		t.Errorf("integrationChanges = %+v, want int-2 added", d.IntegrationChanges)
+	}
+	if d := diffRefreshOutputs(old, old); !d.empty() {
+		t.Errorf("diff of identical outputs = %+v, want empty", d)
+	}
+}
+
+func TestLoadRefreshOutput_Formats(t *testing.T) {
+	flat := RefreshOutput{
+		GroupID:      "g1",
+		Orgs:         map[string]OrgMeta{"o1": {Name: "Org One"}},
+		Integrations: map[string]string{"int-1": "github"},
+		Targets:      []internal.ImportTarget{{OrgID: "o1", IntegrationID: "int-1", Target: internal.Target{Owner: "acme", Name: "app"}}},
+	}
+	dir := t.TempDir()
+	write := func(name string, v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	for _, path := range []string{write("flat.json", flat), write("grouped.json", groupOutputByOrg(flat)), write("stable.json", toStableOutput(flat))} {
+		got, err := loadRefreshOutput(path)
+		if err != nil {
+			t.Fatalf("loadRefreshOutput(%s): %v", path, err)
+		}
+		if d := diffRefreshOutputs(flat, got); !d.empty() {
+			t.Errorf("%s: loaded output differs from original: %+v", filepath.Base(path), d)
+		}
+	}
+}
+
+func TestWriteConvertedTarget(t *testing.T) {
+	tests := []struct {
+		name, origin, branch string
+		wantOK               bool
+		want                 string
+	}{
+		{"acme/api:pom.xml", "github", "main", true, "{\n  \"name\": \"api\",\n  \"owner\": \"acme\",\n  \"branch\": \"main\"\n}\n"},
+		{"PROJ/slug", "bitbucket-server", "", true, "{\n  \"projectKey\": \"PROJ\",\n  \"repoSlug\": \"slug\"\n}\n"},
+		{"no-slash", "github", "", false, "unsupported: cannot parse a repo from the name\n"},
+		{"acme/api", "gitlab", "", false, "unsupported: origin \"gitlab\" is not a supported SCM origin"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		ok, err := writeConvertedTarget(&buf, tt.name, tt.origin, tt.branch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.wantOK || !strings.HasPrefix(buf.String(), tt.want) {
+			t.Errorf("writeConvertedTarget(%q, %q, %q) = %v, %q; want %v, %q", tt.name, tt.origin, tt.branch, ok, buf.String(), tt.wantOK, tt.want)
+		}
+	}
+}
+
+func TestReconcileOrg(t *testing.T) {
+	tgt := func(integration, name string) internal.ImportTarget {
+		return internal.ImportTarget{OrgID: "o1", IntegrationID: integration, Target: internal.Target{Owner: "acme", Name: name, Branch: "main"}}
+	}
+	live := []internal.APITarget{
+		{DisplayName: "Acme/App (main)", IntegrationID: "int-1"},
+		{DisplayName: "acme/lib", IntegrationID: "int-2"},
+	}
+	present, missing := reconcileOrg([]internal.ImportTarget{tgt("int-1", "app"), tgt("int-1", "lib"), tgt("int-1", "gone")}, live)
+	if len(present) != 1 || present[0].Target.Name != "app" {
+		t.Errorf("present = %+v, want app", present)
+	}
+	if len(missing) != 2 || missing[0].Target.Name != "lib" || missing[1].Target.Name != "gone" {
+		t.Errorf("missing = %+v, want lib (other integration) and gone", missing)
+	}
+}
+
+func TestReconcileTargets(t *testing.T) {
+	out := RefreshOutput{Targets: []internal.ImportTarget{
+		{OrgID: "o1", IntegrationID: "int-1", Target: internal.Target{Owner: "acme", Name: "b"}},
+		{OrgID: "o1", IntegrationID: "int-1", Target: internal.Target{Owner: "acme", Name: "a"}},
+		{OrgID: "o1", IntegrationID: "int-1", Target: internal.Target{Owner: "acme", Name: "c"}},
+	}}
+	mock := &mockSnykAPI{Targets: []internal.APITarget{{DisplayName: "acme/a"}, {DisplayName: "acme/b"}}}
+	res := reconcileTargets(context.Background(), mock, out, 2)
+	if len(res.Present) != 2 || res.Present[0].Target.Name != "a" || res.Present[1].Target.Name != "b" {
+		t.Errorf("present = %+v, want a, b", res.Present)
+	}
+	if len(res.Missing) != 1 || res.Missing[0].Target.Name != "c" || len(res.FailedOrgs) != 0 {
+		t.Errorf("result = %+v, want c missing and no failed orgs", res)
+	}
+
+	mock.TargetsErr = fmt.Errorf("boom")
+	res = reconcileTargets(context.Background(), mock, out, 2)
+	if len(res.Present) != 0 || len(res.Missing) != 0 || res.FailedOrgs["o1"] != "boom" {
+		t.Errorf("result = %+v, want only o1 failed", res)
+	}
+}
+
+func TestToStableOutput(t *testing.T) {
+	tgt := func(org, name string) internal.ImportTarget {
+		return internal.ImportTarget{OrgID: org, IntegrationID: "int-1", Target: internal.Target{Owner: "acme", Name: name}}
+	}
+	out := RefreshOutput{
+		Orgs:         map[string]OrgMeta{"o2": {Name: "Two"}, "o1": {Name: "One", Slug: "one"}},
+		Integrations: map[string]string{"int-2": "gitlab", "int-1": "github"},
+		Targets:      []internal.ImportTarget{tgt("o2", "a"), tgt("o1", "b"), tgt("o1", "a")},
+	}
+	stable := toStableOutput(out)
+	if len(stable.Orgs) != 2 || stable.Orgs[0] != (stableOrg{ID: "o1", Name: "One", Slug: "one"}) || stable.Orgs[1].ID != "o2" {
+		t.Errorf("orgs = %+v, want o1 then o2", stable.Orgs)
+	}
+	if len(stable.Integrations) != 2 || stable.Integrations[0] != (stableIntegration{ID: "int-1", Type: "github"}) {
+		t.Errorf("integrations = %+v, want int-1 first", stable.Integrations)
+	}
+	var order []string
+	for _, tt := range stable.Targets {
+		order = append(order, tt.OrgID+"/"+tt.Target.Name)
+	}
+	if strings.Join(order, ",") != "o1/a,o1/b,o2/a" {
+		t.Errorf("target order = %v, want o1/a,o1/b,o2/a", order)
+	}
+	if out.Targets[0].OrgID != "o2" {
+		t.Error("toStableOutput reordered the input targets")
+	}
+
+	// Same content in a different order must serialize identically.
+	out.Targets[0], out.Targets[2] = out.Targets[2], out.Targets[0]
+	a, _ := json.Marshal(stable)
+	b, _ := json.Marshal(toStableOutput(out))
+	if !bytes.Equal(a, b) {
+		t.Errorf("output not byte-stable:\n%s\n%s", a, b)
+	}
+}
+
+func TestPruneFailedOrgs(t *testing.T) {
+	out := RefreshOutput{
+		Targets: []internal.ImportTarget{
+			{OrgID: "o1", Target: internal.Target{Name: "a"}},
+			{OrgID: "o2", Target: internal.Target{Name: "b"}},
+		},
+	}
+	pruneFailedOrgs(&out, []string{"o3", "o2"})
+	if len(out.Targets) != 1 || out.Targets[0].OrgID != "o1" {
+		t.Errorf("targets = %+v, want only o1", out.Targets)
+	}
+	if strings.Join(out.PartialOrgs, ",") != "o2,o3" {
+		t.Errorf("partialOrgs = %v, want [o2 o3]", out.PartialOrgs)
+	}
+	data, _ := json.Marshal(toStableOutput(out))
+	if !strings.Contains(string(data), `"partialOrgs":["o2","o3"]`) {
+		t.Errorf("stable output lost partialOrgs: %s", data)
+	}
+
+	clean := RefreshOutput{Targets: []internal.ImportTarget{{OrgID: "o1"}}}
+	pruneFailedOrgs(&clean, nil)
+	data, _ = json.Marshal(clean)
+	if strings.Contains(string(data), "partialOrgs") {
+		t.Errorf("partialOrgs written for a run with no failures: %s", data)
+	}
+}
+
+func TestPartialSnapshot(t *testing.T) {
+	out := RefreshOutput{Targets: []internal.ImportTarget{{OrgID: "o1"}}}
+	orgs := []internal.Org{{ID: "o1"}, {ID: "o2"}, {ID: "o3"}, {ID: "o4"}}
+	handled := map[string]bool{"o1": true, "o3": true}
+
+	snap := partialSnapshot(out, orgs, handled, []string{"o3"}, true)
+	if strings.Join(snap.PartialOrgs, ",") != "o2,o3,o4" || len(snap.Targets) != 1 {
+		t.Errorf("snapshot = %+v, want partialOrgs o2,o3,o4 and the o1 target", snap)
+	}
+	if out.PartialOrgs != nil {
+		t.Errorf("partialSnapshot modified out: %v", out.PartialOrgs)
+	}
+	if snap := partialSnapshot(out, orgs, handled, []string{"o3"}, false); snap.PartialOrgs != nil {
+		t.Errorf("without --prune-output: partialOrgs = %v, want none", snap.PartialOrgs)
+	}
+}
+
+func TestCollapseEquivalentTargets_MergesFiles(t *testing.T) {
+	typeByID := map[string]string{"int-gh": "github", "int-app": "github-cloud-app"}
+	repo := internal.Target{Owner: "o", Name: "r", Branch: "main"}
+	targets := []internal.ImportTarget{
+		{Target: repo, OrgID: "org", IntegrationID: "int-gh", Files: []internal.File{{Path: "a/package.json"}, {Path: "go.mod"}}},
+		{Target: repo, OrgID: "org", IntegrationID: "int-app", Files: []internal.File{{Path: "go.mod"}}},
+	}
+	kept, replaced := collapseEquivalentTargets(targets, typeByID)
+	if len(kept) != 1 || kept[0].IntegrationID != "int-app" {
+		t.Fatalf("kept = %+v, want only the github-cloud-app target", kept)
+	}
+	if len(kept[0].Files) != 2 || kept[0].Files[1].Path != "a/package.json" {
+		t.Errorf("files = %+v, want go.mod and a/package.json", kept[0].Files)
+	}
+	if replaced[internal.TargetID("org", "int-gh", repo)] != "int-app" {
+		t.Errorf("replaced = %v", replaced)
+	}
+
+	// A github target with no canonical counterpart is kept.
+	kept, replaced = collapseEquivalentTargets(targets[:1], typeByID)
+	if len(kept) != 1 || replaced != nil {
+		t.Errorf("lone github target: kept = %+v, replaced = %v", kept, replaced)
+	}
+}
+
+func TestWriteStreamRecord(t *testing.T) {
+	var buf bytes.Buffer
+	target := internal.ImportTarget{Target: internal.Target{Owner: "o", Name: "r"}, OrgID: "org-1", IntegrationID: "int-1"}
+	for _, res := range []refreshOrgResult{
+		{orgID: "org-1", targets: []internal.ImportTarget{target}},
+		{orgID: "org-2"},
+		{orgID: "org-3", err: fmt.Errorf("list projects: boom")},
+		{orgID: "org-4", skippedStatus: 403},
+	} {
+		if err := writeStreamRecord(&buf, res); err != nil {
+			t.Fatalf("writeStreamRecord(%s): %v", res.orgID, err)
+		}
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d line(s), want 3 (skipped org omitted):\n%s", len(lines), buf.String())
+	}
+	var rec streamRecord
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.OrgID != "org-1" || len(rec.Targets) != 1 || rec.Targets[0].Target.Name != "r" || rec.Error != "" {
+		t.Errorf("line 1 = %+v", rec)
+	}
+	if lines[1] != `{"orgId":"org-2","targets":[]}` {
+		t.Errorf("empty org line = %s, want an empty targets array", lines[1])
+	}
+	if lines[2] != `{"orgId":"org-3","targets":[],"error":"list projects: boom"}` {
+		t.Errorf("failed org line = %s", lines[2])
+	}
+}
+
+func TestWriteUnparseable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unparseable.json")
+	err := writeUnparseable(path, []unparseableProject{
+		{OrgID: "o2", ProjectID: "p3", Name: "x"},
+		{OrgID: "o1", ProjectID: "p2", Name: "b"},
+		{OrgID: "o1", ProjectID: "p1", Name: "a"},
+	})
+	if err != nil {
+		t.Fatalf("writeUnparseable: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []unparseableProject
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	var ids []string
+	for _, p := range got {
+		ids = append(ids, p.ProjectID)
+	}
+	if strings.Join(ids, ",") != "p1,p2,p3" {
+		t.Errorf("order = %v, want p1,p2,p3 (by org, then name)", ids)
+	}
+
+	if err := writeUnparseable(path, nil); err != nil {
+		t.Fatalf("writeUnparseable(nil): %v", err)
+	}
+	if data, _ := os.ReadFile(path); strings.TrimSpace(string(data)) != "[]" {
+		t.Errorf("empty report = %q, want []", data)
+	}
+}
+
+func TestWriteMappingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapping.json")
+	var totals refreshCounts
+	totals.add(refreshCounts{mapping: map[string]projectMapping{"p1": {OrgID: "o1", IntegrationID: "i1", Target: internal.Target{Owner: "acme", Name: "a"}}}})
+	totals.add(refreshCounts{mapping: map[string]projectMapping{"p2": {OrgID: "o2", IntegrationID: "i2", Target: internal.Target{Owner: "acme", Name: "b"}}}})
+	if err := writeMappingFile(path, totals.mapping); err != nil {
+		t.Fatalf("writeMappingFile: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]projectMapping
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(got) != 2 || got["p2"].OrgID != "o2" || got["p1"].Target.Name != "a" {
+		t.Errorf("mapping = %+v", got)
+	}
+	if !strings.Contains(string(data), `"integrationId": "i1"`) {
+		t.Errorf("mapping JSON missing integrationId:\n%s", data)
+	}
+}
+
+func TestWriteConcurrencyStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	results := []refreshOrgResult{
+		{orgID: "fast", orgLabel: "Fast (fast)", elapsed: 20 * time.Millisecond, projects: 3, targets: make([]internal.ImportTarget, 2)},
+		{orgID: "slow", orgLabel: "slow", elapsed: 900 * time.Millisecond, projects: 40, retries: 4},
+		{orgID: "broken", orgLabel: "broken", elapsed: 100 * time.Millisecond, err: fmt.Errorf("fetch projects: boom")},
+		{orgID: "forbidden", orgLabel: "forbidden", skippedStatus: 403},
+	}
+	stats := concurrencyStats{Concurrency: 5, FinalConcurrency: 5, Retries: 4}
+	for _, res := range results {
+		stats.Orgs = append(stats.Orgs, newOrgStats(res))
+	}
+	if err := writeConcurrencyStats(path, stats); err != nil {
+		t.Fatalf("writeConcurrencyStats: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got concurrencyStats
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	var order []string
+	for _, o := range got.Orgs {
+		order = append(order, o.OrgID)
+	}
+	if strings.Join(order, ",") != "slow,broken,fast,forbidden" {
+		t.Errorf("order = %v, want slowest first", order)
+	}
+	if s := got.Orgs[0]; s.ElapsedMs != 900 || s.Projects != 40 || s.Retries != 4 || s.Failed {
+		t.Errorf("slow = %+v", s)
+	}
+	if s := got.Orgs[1]; !s.Failed || s.Error != "fetch projects: boom" {
+		t.Errorf("broken = %+v", s)
+	}
+	if s := got.Orgs[2]; s.Targets != 2 || s.Label != "Fast (fast)" {
+		t.Errorf("fast = %+v", s)
+	}
+	if s := got.Orgs[3]; !s.Skipped || s.Failed {
+		t.Errorf("forbidden = %+v", s)
+	}
+}
+
+func TestProcessOrgForRefresh_RecordsStats(t *testing.T) {
+	api := &mockSnykAPI{
+		Integrations: map[string]string{"github": "int-gh"},
+		Projects: []internal.Project{
+			{Name: "owner/a", Origin: "github", Branch: "main"},
+			{Name: "owner/b", Origin: "github", Branch: "main"},
+			{Name: "img", Origin: "docker-hub"},
+		},
+	}
+	res := processOrgForRefresh(context.Background(), api, internal.Org{ID: "org-1"}, refreshOptions{})
+	if res.projects != 3 || len(res.targets) != 2 || res.elapsed <= 0 {
+		t.Errorf("projects=%d targets=%d elapsed=%v, want 3 projects, 2 targets, and a duration", res.projects, len(res.targets), res.elapsed)
+	}
+}
+
+func TestSplitByIntegrationType(t *testing.T) {
+	out := RefreshOutput{
+		GroupID:      "g1",
+		Orgs:         map[string]OrgMeta{"o1": {Name: "One"}, "o2": {Name: "Two"}, "o3": {Name: "Three"}},
+		Integrations: map[string]string{"int-gh": "github", "int-bb": "bitbucket-cloud-app", "int-az": "azure-repos"},
+		Targets: []internal.ImportTarget{
+			{OrgID: "o1", IntegrationID: "int-gh", Target: internal.Target{Owner: "acme", Name: "a"}},
+			{OrgID: "o2", IntegrationID: "int-bb", Target: internal.Target{Owner: "acme", Name: "b"}},
+			{OrgID: "o2", IntegrationID: "int-gh", Target: internal.Target{Owner: "acme", Name: "c"}},
+		},
+	}
+	parts := splitByIntegrationType(out)
+	if len(parts) != 2 {
+		t.Fatalf("got %d parts, want 2 (azure-repos has no targets): %v", len(parts), parts)
+	}
+	gh := parts["github"]
+	if len(gh.Targets) != 2 || gh.GroupID != "g1" {
+		t.Errorf("github part = %+v, want 2 targets in group g1", gh)
+	}
+	if len(gh.Orgs) != 2 || len(gh.Integrations) != 1 || gh.Integrations["int-gh"] != "github" {
+		t.Errorf("github part orgs/integrations = %v / %v, want o1,o2 and int-gh only", gh.Orgs, gh.Integrations)
+	}
+	bb, ok := parts["bitbucket-connect-app"]
+	if !ok {
+		t.Fatalf("bitbucket-cloud-app not normalized to bitbucket-connect-app: %v", parts)
+	}
+	if len(bb.Targets) != 1 || len(bb.Orgs) != 1 || bb.Orgs["o2"].Name != "Two" {
+		t.Errorf("bitbucket part = %+v, want one o2 target", bb)
+	}
+}
+
+func TestSplitFileName(t *testing.T) {
+	tests := map[string]string{
+		"github":                "refresh-github.json",
+		"bitbucket-connect-app": "refresh-bitbucket-connect-app.json",
+		"../etc/passwd":         "refresh-___etc_passwd.json",
+	}
+	for typ, want := range tests {
+		if got := splitFileName(typ); got != want {
+			t.Errorf("splitFileName(%q) = %q, want %q", typ, got, want)
+		}
+	}
+}
+
+func TestWriteFailedOrgs_RoundTripsThroughReadOrgFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "failed.txt")
+	if err := writeFailedOrgs(path, []string{"org-b", "org-a"}); err != nil {
+		t.Fatalf("writeFailedOrgs: %v", err)
+	}
+	ids, err := readOrgFile(path)
+	if err != nil {
+		t.Fatalf("readOrgFile: %v", err)
+	}
+	if strings.Join(ids, ",") != "org-a,org-b" {
+		t.Errorf("ids = %v, want [org-a org-b]", ids)
+	}
+
+	if err := writeFailedOrgs(path, nil); err != nil {
+		t.Fatalf("writeFailedOrgs(nil): %v", err)
+	}
+	if _, err := readOrgFile(path); err == nil {
+		t.Error("readOrgFile on a file with no failures: want error")
+	}
+}
+
+func TestReadOrgFile_SkipsBlankAndComments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orgs.txt")
+	if err := os.WriteFile(path, []byte("# retry list\n\n  org-1  \r\n#org-2\norg-3\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := readOrgFile(path)
+	if err != nil {
+		t.Fatalf("readOrgFile: %v", err)
+	}
+	if strings.Join(ids, ",") != "org-1,org-3" {
+		t.Errorf("ids = %v, want [org-1 org-3]", ids)
+	}
+}
+
+func TestParseLegacyBefore(t *testing.T) {
+	if got, err := parseLegacyBefore("2023-06-01"); err != nil || !got.Equal(time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("date: got %v, %v", got, err)
+	}
+	if got, err := parseLegacyBefore("2023-06-01T12:00:00+02:00"); err != nil || !got.Equal(time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("timestamp: got %v, %v", got, err)
+	}
+	if _, err := parseLegacyBefore("June 2023"); err == nil {
+		t.Error("bad value: want error")
+	}
+}
+
+func TestReadIncludeFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "include.txt")
+	content := "# reviewed list\n\norg-1:int-1:repo:owner:main\r\n  Owner/Repo  \n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := readIncludeFile(path)
+	if err != nil {
+		t.Fatalf("readIncludeFile: %v", err)
+	}
+	if !a.ids["org-1:int-1:repo:owner:main"] || !a.repos["owner/repo"] || len(a.ids)+len(a.repos) != 2 {
+		t.Errorf("allowlist = %+v", a)
+	}
+	if !a.allows("", internal.Target{ProjectKey: "OWNER", RepoSlug: "repo"}) {
+		t.Error("projectKey/repoSlug should match owner/repo line")
+	}
+
+	for _, bad := range []string{"just-a-name\n", "a/b/c\n", "# only comments\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readIncludeFile(path); err == nil {
+			t.Errorf("%q: want error", bad)
+		}
+	}
+}
+
+func TestOrgFailuresExceeded(t *testing.T) {
+	tests := []struct {
+		failed, limit int
+		want          bool
+	}{
+		{0, -1, false},
+		{100, -1, false},
+		{0, 0, false},
+		{1, 0, true},
+		{3, 3, false},
+		{4, 3, true},
+	}
+	for _, tt := range tests {
+		if got := orgFailuresExceeded(tt.failed, tt.limit); got != tt.want {
+			t.Errorf("orgFailuresExceeded(%d, %d) = %v, want %v", tt.failed, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestDumpAndReplayProjects(t *testing.T) {
+	ctx := context.Background()
+	mock := &mockSnykAPI{Projects: []internal.Project{
+		{ID: "p1", Name: "owner/repo:go.mod", Origin: "github", Branch: "main", Created: "2024-01-01T00:00:00Z"},
+	}}
+	rec := newProjectRecorder(mock)
+	if _, err := rec.FetchProjects(ctx, "org-1"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "projects.json")
+	if n, err := rec.write(path); err != nil || n != 1 {
+		t.Fatalf("write = %d, %v; want 1 org", n, err)
+	}
+
+	f, err := loadProjectsFile(path)
+	if err != nil {
+		t.Fatalf("loadProjectsFile: %v", err)
+	}
+	replay := &savedProjectsAPI{SnykAPI: &mockSnykAPI{ProjectsErr: errors.New("API must not be called")}, file: f}
+	got, err := replay.FetchProjects(ctx, "org-1")
+	if err != nil || len(got) != 1 || got[0] != mock.Projects[0] {
+		t.Errorf("replayed projects = %+v, %v; want %+v", got, err, mock.Projects)
+	}
+	if _, err := replay.FetchProjects(ctx, "org-2"); err == nil {
+		t.Error("org missing from the file: want error")
+	}
+}
+
+// TestReplayProjectsFileOffline records a group run with --dump-projects and
+// replays it against an API where every call fails, as without a network or
+// token: orgs, integrations, integration status, and projects must all come
+// from the file.
+func TestReplayProjectsFileOffline(t *testing.T) {
+	ctx := context.Background()
+	live := &mockSnykAPI{
+		Orgs:                 []internal.Org{{ID: "org-1", Name: "One", Slug: "one"}},
+		Integrations:         map[string]string{"github": "int-github", "bitbucket-cloud": "int-bb"},
+		InactiveIntegrations: map[string]bool{"int-bb": true},
+		Projects: []internal.Project{
+			{ID: "p1", Name: "owner/repo:go.mod", Origin: "github", Branch: "main"},
+			{ID: "p2", Name: "ws/repo:pom.xml", Origin: "bitbucket-cloud", Branch: "main"},
+		},
+	}
+	opts := refreshOptions{filterIntegrationActive: true}
+	run := func(api SnykAPI) refreshOrgResult {
+		t.Helper()
+		orgs, err := resolveOrgs(ctx, api, "group-1", "", "")
+		if err != nil || len(orgs) != 1 {
+			t.Fatalf("resolveOrgs = %+v, %v; want org-1", orgs, err)
+		}
+		res := processOrgForRefresh(ctx, api, orgs[0], opts)
+		if res.err != nil {
+			t.Fatalf("processOrgForRefresh: %v", res.err)
+		}
+		return res
+	}
+
+	rec := newProjectRecorder(live)
+	want := run(rec)
+	path := filepath.Join(t.TempDir(), "projects.json")
+	if _, err := rec.write(path); err != nil {
+		t.Fatal(err)
+	}
+	f, err := loadProjectsFile(path)
+	if err != nil {
+		t.Fatalf("loadProjectsFile: %v", err)
+	}
+
+	offline := errors.New("API must not be called")
+	dead := &mockSnykAPI{
+		OrgsErr: offline, IntegrationsErr: offline, ProjectsErr: offline, TargetsErr: offline,
+		DeleteProjectErr: offline, DeleteTargetErr: offline, UserErr: offline, GroupsErr: offline,
+		GroupAccessErr: offline, OrgAccessErr: offline, IntegrationStatusErr: offline,
+	}
+	got := run(&savedProjectsAPI{SnykAPI: dead, file: f})
+	if len(got.targets) != 1 || fmt.Sprintf("%+v", got.targets) != fmt.Sprintf("%+v", want.targets) {
+		t.Errorf("replayed targets = %+v, want %+v", got.targets, want.targets)
+	}
+	if fmt.Sprint(got.inactiveIntegrations) != "[bitbucket-cloud]" || fmt.Sprint(got.orgMeta) != fmt.Sprint(want.orgMeta) {
+		t.Errorf("replayed inactive = %v, orgMeta = %v; want %v, %v", got.inactiveIntegrations, got.orgMeta, want.inactiveIntegrations, want.orgMeta)
+	}
+}
+
+func TestResumeFromOrg(t *testing.T) {
+	orgs := []internal.Org{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	got, err := resumeFromOrg(orgs, "b")
+	if err != nil || len(got) != 2 || got[0].ID != "b" || got[1].ID != "c" {
+		t.Errorf("resume from b = %+v, %v; want [b c]", got, err)
+	}
+	if got, err := resumeFromOrg(orgs, "a"); err != nil || len(got) != 3 {
+		t.Errorf("resume from first = %+v, %v; want all orgs", got, err)
+	}
+	if _, err := resumeFromOrg(orgs, "zz"); err == nil {
+		t.Error("unknown org: want error")
+	}
+}
+
+func TestShuffleOrgs(t *testing.T) {
+	var orgs []internal.Org
+	for i := 0; i < 20; i++ {
+		orgs = append(orgs, internal.Org{ID: fmt.Sprintf("org-%02d", i)})
+	}
+	shuffled := shuffleOrgs(orgs, rand.New(rand.NewPCG(1, 2)))
+	if len(shuffled) != len(orgs) {
+		t.Fatalf("got %d orgs, want %d", len(shuffled), len(orgs))
+	}
+	seen := make(map[string]bool)
+	moved := false
+	for i, o := range shuffled {
+		seen[o.ID] = true
+		if o.ID != orgs[i].ID {
+			moved = true
+		}
+	}
+	if len(seen) != len(orgs) {
+		t.Errorf("shuffle lost or duplicated orgs: %v", shuffled)
+	}
+	if !moved {
+		t.Error("order unchanged after shuffle")
+	}
+	if orgs[0].ID != "org-00" || orgs[19].ID != "org-19" {
+		t.Error("shuffleOrgs modified its input")
+	}
+}
+
+func TestFilterOrgsByID(t *testing.T) {
+	orgs := []internal.Org{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	kept, missing := filterOrgsByID(orgs, []string{"c", "a", "zzz"})
+	if len(kept) != 2 || kept[0].ID != "a" || kept[1].ID != "c" {
+		t.Errorf("kept = %+v, want a, c in group order", kept)
+	}
+	if len(missing) != 1 || missing[0] != "zzz" {
+		t.Errorf("missing = %v, want [zzz]", missing)
+	}
+}
+
+func TestRenderHCL(t *testing.T) {
+	out := RefreshOutput{
+		Integrations: map[string]string{"int-1": "github"},
+		Targets: []internal.ImportTarget{{
+			OrgID:         "org-1",
+			IntegrationID: "int-1",
+			Target:        internal.Target{Owner: "acme", Name: "app-${x}", Branch: "main"},
+			Files:         []internal.File{{Path: "package.json"}, {Path: "api/go.mod"}},
+		}},
+	}
+	got := string(renderHCL(out))
+	for _, want := range []string{
+		"locals {\n  snyk_import_targets = [\n    {\n",
+		`      org_id           = "org-1"` + "\n",
+		`      integration_type = "github"` + "\n",
+		`      name             = "app-$${x}"` + "\n",
+		`      files            = ["package.json", "api/go.mod"]` + "\n",
+		"    },\n  ]\n}\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("renderHCL output missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "project_key") {
+		t.Errorf("empty fields should be omitted:\n%s", got)
+	}
+}
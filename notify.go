@@ -0,0 +1,99 @@
+// notify.go posts a JSON run summary to a webhook (--notify-url) when a
+// refresh run completes, for wiring runs into Slack/Teams or CI pipelines.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/snyk-playground/snyk-target-export/internal"
+)
+
+// runSummary is the JSON body posted to --notify-url.
+type runSummary struct {
+	Command       string `json:"command"`
+	Success       bool   `json:"success"`
+	Targets       int    `json:"targets"`
+	OrgsProcessed int    `json:"orgsProcessed"`
+	OrgsFailed    int    `json:"orgsFailed"`
+	Output        string `json:"output,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// notifier sends run summaries to a webhook according to the --notify-on mode.
+// A zero notifier (empty url) never sends.
+type notifier struct {
+	url    string
+	on     string // "success", "failure", or "always"
+	client *http.Client
+}
+
+// newNotifier validates the webhook URL and mode. An empty rawURL yields a
+// disabled notifier.
+func newNotifier(rawURL, on string) (*notifier, error) {
+	switch on {
+	case "success", "failure", "always":
+	default:
+		return nil, fmt.Errorf("--notify-on must be success, failure, or always, got %q", on)
+	}
+	if rawURL == "" {
+		return &notifier{on: on}, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("--notify-url must be an http(s) URL, got %q", rawURL)
+	}
+	return &notifier{url: rawURL, on: on, client: internal.NewHTTPClient()}, nil
+}
+
+// shouldNotify reports whether a run with the given outcome should be sent.
+func (n *notifier) shouldNotify(success bool) bool {
+	if n.url == "" {
+		return false
+	}
+	switch n.on {
+	case "always":
+		return true
+	case "success":
+		return success
+	default:
+		return !success
+	}
+}
+
+// notify posts s to the webhook if the mode calls for it. Failures are logged
+// as warnings and never affect the caller's exit code.
+func (n *notifier) notify(ctx context.Context, s runSummary) {
+	if !n.shouldNotify(s.Success) {
+		return
+	}
+	if err := n.post(ctx, s); err != nil {
+		log.Printf("WARNING: Failed to send notification: %v", err)
+	}
+}
+
+func (n *notifier) post(ctx context.Context, s runSummary) error {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("marshal summary: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post summary: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("post summary: status %d", resp.StatusCode)
+	}
+	return nil
+}
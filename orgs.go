@@ -0,0 +1,126 @@
+// orgs.go implements the orgs subcommand: list a group's orgs (ID, name, and
+// slug) without fetching any projects, to audit membership or build an
+// --org-file.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/snyk-playground/snyk-target-export/internal"
+)
+
+// renderOrgs formats orgs for the orgs subcommand, sorted by name then ID:
+// "table" for an aligned ID/NAME/SLUG table, "json" for an array of
+// {id, name, slug}, or "ids" for one ID per line in the --org-file format.
+func renderOrgs(orgs []internal.Org, groupID, format string) ([]byte, error) {
+	sorted := append([]internal.Org(nil), orgs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Name != sorted[j].Name {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	var buf bytes.Buffer
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tSLUG")
+		for _, o := range sorted {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", o.ID, o.Name, o.Slug)
+		}
+		if err := tw.Flush(); err != nil {
+			return nil, err
+		}
+	case "json":
+		if sorted == nil {
+			sorted = []internal.Org{}
+		}
+		data, err := json.MarshalIndent(sorted, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("marshal orgs: %w", err)
+		}
+		buf.Write(append(data, '\n'))
+	case "ids":
+		fmt.Fprintf(&buf, "# %d org(s) in group %s; pass to --org-file\n", len(sorted), groupID)
+		for _, o := range sorted {
+			fmt.Fprintf(&buf, "%s\n", o.ID)
+		}
+	default:
+		return nil, fmt.Errorf("--format must be table, json, or ids, got %q", format)
+	}
+	return buf.Bytes(), nil
+}
+
+// runOrgs implements the orgs subcommand.
+func runOrgs(args []string) {
+	fs := flag.NewFlagSet("orgs", flag.ExitOnError)
+	apiOpts := addAPIFlags(fs)
+	groupID := fs.String("groupId", "", "Snyk group ID whose orgs to list")
+	fastOrgFetch := fs.Bool("fast-org-fetch", false, "List group orgs with the REST API (cursor pagination), falling back to the v1 API on error")
+	output := fs.String("output", "", "Write the list to this file instead of stdout")
+	format := fs.String("format", "table", "Output format: table, json, or ids (one org ID per line, for --org-file)")
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	// Not applyEnvDefaults: SNYK_OUTPUT names the refresh output file, which
+	// must not be overwritten with an org list.
+	if *groupID == "" {
+		*groupID = os.Getenv("SNYK_GROUP_ID")
+	}
+	if *groupID == "" {
+		fmt.Fprintf(os.Stderr, "Error: --groupId is required\n")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if _, err := renderOrgs(nil, "", *format); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := configureAPI(apiOpts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer warnDeprecatedAPIVersion()
+
+	token, err := internal.GetSnykToken()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	api := newSnykAPI(internal.NewHTTPClient(), token, *fastOrgFetch)
+	orgs, err := api.FetchOrgs(ctx, *groupID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error fetching orgs: %v\n", err)
+		os.Exit(1)
+	}
+	data, err := renderOrgs(orgs, *groupID, *format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *output == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	safePath, err := sanitizeOutputPath(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := writeFileAtomic(safePath, data); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: writing %s: %v\n", safePath, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d org(s) to %s\n", len(orgs), safePath)
+}
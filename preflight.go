@@ -0,0 +1,177 @@
+// preflight.go implements the preflight subcommand: a quick check that the
+// token, API region, and target group/org are usable before a long run.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/snyk-playground/snyk-target-export/internal"
+)
+
+// preflightReport holds the outcome of the preflight checks.
+type preflightReport struct {
+	user      internal.User
+	scope     string // "group <id>", "org <id>", or "" when neither was given
+	accessErr error
+}
+
+// runPreflightChecks verifies the token by fetching the current user and, when
+// groupID or orgID is set, confirms that scope is readable. The returned error
+// is non-nil only when the token itself is rejected; scope failures are recorded
+// in the report so the caller can print them alongside the user info.
+func runPreflightChecks(ctx context.Context, api SnykAPI, groupID, orgID string) (preflightReport, error) {
+	var rep preflightReport
+
+	user, err := api.FetchUser(ctx)
+	if err != nil {
+		return rep, err
+	}
+	rep.user = user
+
+	switch {
+	case groupID != "":
+		rep.scope = "group " + groupID
+		rep.accessErr = api.CheckGroupAccess(ctx, groupID)
+	case orgID != "":
+		rep.scope = "org " + orgID
+		rep.accessErr = api.CheckOrgAccess(ctx, orgID)
+	}
+	return rep, nil
+}
+
+// writeIntegrationProbe prints an org's integrations as ListIntegrations
+// returned them, then how each SCM origin maps through OriginToIntegrationKey
+// to an integration ID (--probe-integration). typ limits the mapping to
+// origins whose name or key is typ; "all" shows every SCM origin. It returns
+// how many shown origins have no matching integration.
+func writeIntegrationProbe(w io.Writer, orgID string, integrations map[string]string, typ string) (missing int, err error) {
+	types := make([]string, 0, len(integrations))
+	for t := range integrations {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	fmt.Fprintf(w, "Integrations in org %s (%d):\n", orgID, len(types))
+	for _, t := range types {
+		fmt.Fprintf(w, "  %s: %s\n", t, integrations[t])
+	}
+
+	var origins []string
+	for _, o := range internal.SCMOrigins() {
+		if typ == "all" || o == typ || internal.OriginToIntegrationKey(o) == typ {
+			origins = append(origins, o)
+		}
+	}
+	if len(origins) == 0 {
+		return 0, fmt.Errorf("--probe-integration: %q is not a supported SCM origin or integration type (want one of %v, or all)", typ, internal.SCMOrigins())
+	}
+	fmt.Fprintln(w, "\nProject origin mapping:")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "  ORIGIN\tINTEGRATION KEY\tINTEGRATION ID")
+	for _, o := range origins {
+		key := internal.OriginToIntegrationKey(o)
+		id := integrations[key]
+		if id == "" {
+			missing++
+			id = "MISSING: projects with this origin produce no targets"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", o, key, id)
+	}
+	return missing, tw.Flush()
+}
+
+// runPreflight implements the preflight subcommand.
+func runPreflight(args []string) {
+	fs := flag.NewFlagSet("preflight", flag.ExitOnError)
+	apiOpts := addAPIFlags(fs)
+	groupID := fs.String("groupId", "", "Snyk group ID to check access to (optional)")
+	orgID := fs.String("orgId", "", "Snyk org ID to check access to (optional)")
+	probe := fs.String("probe-integration", "", "With --orgId, list the org's integrations and show which integration each project origin of this type (or all) maps to")
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	if err := applyEnvDefaults(fs); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := configureAPI(apiOpts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer warnDeprecatedAPIVersion()
+
+	if *groupID != "" && *orgID != "" {
+		fmt.Fprintf(os.Stderr, "Error: provide either --groupId or --orgId, not both\n")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *probe != "" && *orgID == "" {
+		fmt.Fprintf(os.Stderr, "Error: --probe-integration requires --orgId\n")
+		os.Exit(1)
+	}
+
+	token, err := internal.GetSnykToken()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	api := newSnykAPI(internal.NewHTTPClient(), token, false)
+
+	fmt.Printf("API base URL: %s\n", internal.GetSnykAPIBaseURL())
+	if *apiOpts.apiPathPrefix != "" {
+		fmt.Printf("API endpoints: REST %s, v1 %s (from --api-path-prefix)\n", internal.RESTBaseURL(), internal.V1BaseURL())
+	}
+	if accept := internal.RESTAccept(); accept != internal.DefaultRESTAccept {
+		fmt.Printf("REST Accept header: %s (from SNYK_API_ACCEPT)\n", accept)
+	}
+	if *apiOpts.apiVersion != internal.DefaultRESTVersion {
+		fmt.Printf("REST API version: %s (from --api-version)\n", *apiOpts.apiVersion)
+	}
+	if *apiOpts.tokenHeader != internal.DefaultTokenHeader || *apiOpts.tokenFormat != internal.DefaultTokenFormat {
+		fmt.Printf("Token header: %s: %s (from --token-header/--token-value-format)\n", *apiOpts.tokenHeader, *apiOpts.tokenFormat)
+	}
+	rep, err := runPreflightChecks(ctx, api, *groupID, *orgID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: token check failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	name := rep.user.Username
+	if rep.user.Email != "" {
+		name = fmt.Sprintf("%s <%s>", name, rep.user.Email)
+	}
+	fmt.Printf("Authenticated as: %s (%s)\n", name, rep.user.ID)
+
+	if rep.scope != "" {
+		if rep.accessErr != nil {
+			fmt.Printf("Access to %s: FAILED (%v)\n", rep.scope, rep.accessErr)
+			os.Exit(1)
+		}
+		fmt.Printf("Access to %s: OK\n", rep.scope)
+	}
+	if *probe != "" {
+		integrations, err := api.ListIntegrations(ctx, *orgID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: listing integrations for org %s: %v\n", *orgID, err)
+			os.Exit(1)
+		}
+		fmt.Println()
+		missing, err := writeIntegrationProbe(os.Stdout, *orgID, integrations, *probe)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if missing > 0 && *probe != "all" {
+			fmt.Printf("\nNo %s integration in org %s.\n", *probe, *orgID)
+			os.Exit(1)
+		}
+	}
+	fmt.Println("\nPreflight checks passed.")
+}
@@ -0,0 +1,200 @@
+// progress.go tracks per-org progress during a run so it can be dumped on
+// demand (SIGUSR1 on Unix) or logged periodically (--heartbeat) to tell a
+// slow org apart from a busy tool.
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progress is a thread-safe tracker of orgs queued, in flight, and finished.
+type progress struct {
+	mu       sync.Mutex
+	total    int
+	done     int
+	failed   int
+	inFlight map[string]time.Time // org label -> start time
+	started  time.Time
+	orgTimes []time.Duration // how long each finished org took
+}
+
+// newProgress returns a tracker for a run over total orgs.
+func newProgress(total int) *progress {
+	return &progress{total: total, inFlight: make(map[string]time.Time), started: time.Now()}
+}
+
+// start marks an org as in flight.
+func (p *progress) start(label string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inFlight[label] = time.Now()
+}
+
+// finish marks an org as done, counting it as failed when err is non-nil.
+func (p *progress) finish(label string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if started, ok := p.inFlight[label]; ok {
+		p.orgTimes = append(p.orgTimes, time.Since(started))
+	}
+	delete(p.inFlight, label)
+	p.done++
+	if err != nil {
+		p.failed++
+	}
+}
+
+// requeue moves n failed orgs back to the queue for an --org-retries pass.
+func (p *progress) requeue(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done -= n
+	p.failed -= n
+}
+
+// activeOrg is an in-flight org and how long it has been running.
+type activeOrg struct {
+	label   string
+	elapsed time.Duration
+}
+
+// progressSnapshot is a point-in-time copy of the tracker's state.
+type progressSnapshot struct {
+	total, queued, done, failed int
+	active                      []activeOrg // longest-running first
+	elapsed                     time.Duration
+	orgTimes                    []time.Duration
+}
+
+// snapshot returns a consistent copy of the current progress.
+func (p *progress) snapshot() progressSnapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	s := progressSnapshot{
+		total:  p.total,
+		queued: p.total - p.done - len(p.inFlight),
+		done:   p.done,
+		failed: p.failed,
+	}
+	s.elapsed = now.Sub(p.started)
+	s.orgTimes = append([]time.Duration(nil), p.orgTimes...)
+	for label, started := range p.inFlight {
+		s.active = append(s.active, activeOrg{label: label, elapsed: now.Sub(started)})
+	}
+	sort.Slice(s.active, func(i, j int) bool { return s.active[i].elapsed > s.active[j].elapsed })
+	return s
+}
+
+// line renders the counts and the ETA on one line.
+func (s progressSnapshot) line() string {
+	line := fmt.Sprintf("Progress: %d/%d org(s) done (%d failed), %d in flight, %d queued",
+		s.done, s.total, s.failed, len(s.active), s.queued)
+	if eta := s.etaText(); eta != "" {
+		line += "; " + eta
+	}
+	return line
+}
+
+// etaText renders the ETA, or "" once every org is done.
+func (s progressSnapshot) etaText() string {
+	remaining := s.total - s.done
+	if remaining <= 0 {
+		return ""
+	}
+	eta, typical, ok := estimateETA(s.elapsed, s.done, remaining, s.orgTimes)
+	if !ok {
+		return fmt.Sprintf("ETA after %d org(s) finish", etaMinDone)
+	}
+	if float64(typical) > float64(eta)*etaSkew || float64(eta) > float64(typical)*etaSkew {
+		lo, hi := min(eta, typical), max(eta, typical)
+		return fmt.Sprintf("ETA %s-%s (org sizes vary widely)", roundETA(lo), roundETA(hi))
+	}
+	return "ETA ~" + roundETA(eta)
+}
+
+// etaMinDone is how many orgs must finish before an ETA is given; earlier
+// estimates swing too widely to help.
+const etaMinDone = 3
+
+// etaSkew is how far apart the throughput and typical-org estimates may be
+// before the ETA is shown as a range between them.
+const etaSkew = 2.0
+
+// estimateETA estimates the time left for remaining orgs. eta is based on the
+// run's throughput so far (elapsed time per finished org, which allows for
+// orgs running in parallel). typical scales eta by the median org time over
+// the mean, so it is not dominated by a few huge orgs. When the two are far
+// apart, org sizes vary too much for one number. ok is false until etaMinDone
+// orgs have finished.
+func estimateETA(elapsed time.Duration, done, remaining int, orgTimes []time.Duration) (eta, typical time.Duration, ok bool) {
+	if done < etaMinDone || len(orgTimes) == 0 {
+		return 0, 0, false
+	}
+	eta = time.Duration(float64(elapsed) / float64(done) * float64(remaining))
+	sorted := append([]time.Duration(nil), orgTimes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := sorted[len(sorted)/2]
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	typical = eta
+	if mean := sum / time.Duration(len(sorted)); mean > 0 {
+		typical = time.Duration(float64(eta) * float64(median) / float64(mean))
+	}
+	return eta, typical, true
+}
+
+// roundETA rounds d to the minute, or to the second below a minute.
+func roundETA(d time.Duration) string {
+	if d >= time.Minute {
+		return d.Round(time.Minute).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+// String renders the snapshot as a short multi-line report.
+func (s progressSnapshot) String() string {
+	var b strings.Builder
+	b.WriteString(s.line() + "\n")
+	for _, a := range s.active {
+		fmt.Fprintf(&b, "  in flight: %s (%s)\n", a.label, a.elapsed.Round(time.Second))
+	}
+	return b.String()
+}
+
+// startHeartbeat logs a one-line progress summary with an ETA every interval
+// (--heartbeat), naming the longest-running org. An interval of 0 disables
+// it. The returned function stops it.
+func startHeartbeat(p *progress, every time.Duration) (stop func()) {
+	if every <= 0 {
+		return func() {}
+	}
+	ticker := time.NewTicker(every)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				s := p.snapshot()
+				line := s.line()
+				if len(s.active) > 0 {
+					line += fmt.Sprintf("; longest in flight: %s (%s)", s.active[0].label, s.active[0].elapsed.Round(time.Second))
+				}
+				log.Print(line)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
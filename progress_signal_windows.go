@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+// watchProgressSignal is a no-op on Windows, which has no SIGUSR1.
+func watchProgressSignal(p *progress) (stop func()) {
+	return func() {}
+}
@@ -0,0 +1,197 @@
+// projectsfile.go implements --projects-file and --dump-projects: saving what
+// refresh reads from the API (org lists, integrations, integration status, and
+// projects), and replaying it in place of the API so a conversion can be
+// reproduced offline.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/snyk-playground/snyk-target-export/internal"
+)
+
+// projectsDump is the --dump-projects / --projects-file format. Each map holds
+// only the calls that succeeded, so a call that failed when the file was
+// written also fails when it is replayed.
+type projectsDump struct {
+	// Groups maps group ID -> the orgs FetchOrgs returned for it.
+	Groups map[string][]internal.Org `json:"groups,omitempty"`
+	// Projects maps org ID -> the org's projects.
+	Projects map[string][]internal.Project `json:"projects"`
+	// Integrations maps org ID -> {integration type: integration ID}.
+	Integrations map[string]map[string]string `json:"integrations,omitempty"`
+	// IntegrationActive maps org ID -> {integration ID: active}, recorded
+	// with --filter-integration-active.
+	IntegrationActive map[string]map[string]bool `json:"integrationActive,omitempty"`
+}
+
+// loadProjectsFile reads a --projects-file as written by --dump-projects.
+func loadProjectsFile(path string) (*projectsDump, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read projects file: %w", err)
+	}
+	var f projectsDump
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("decode projects file %s: %w", path, err)
+	}
+	if len(f.Projects) == 0 {
+		return nil, fmt.Errorf("projects file %s lists no orgs; write it with --dump-projects", path)
+	}
+	return &f, nil
+}
+
+// savedProjectsAPI serves every call refresh makes from a --projects-file,
+// so a replay needs neither the network nor a token. Anything missing from
+// the file is an error rather than a live call. Other calls pass through.
+type savedProjectsAPI struct {
+	SnykAPI
+	file *projectsDump
+}
+
+func (s *savedProjectsAPI) FetchOrgs(ctx context.Context, groupID string) ([]internal.Org, error) {
+	orgs, ok := s.file.Groups[groupID]
+	if !ok {
+		return nil, fmt.Errorf("group %s is not in --projects-file", groupID)
+	}
+	return orgs, nil
+}
+
+// CheckOrgAccess is skipped on replay: there is no token to check, and an
+// org missing from the file fails when its projects are read.
+func (s *savedProjectsAPI) CheckOrgAccess(ctx context.Context, orgID string) error {
+	return nil
+}
+
+func (s *savedProjectsAPI) ListIntegrations(ctx context.Context, orgID string) (map[string]string, error) {
+	integrations, ok := s.file.Integrations[orgID]
+	if !ok {
+		return nil, fmt.Errorf("integrations of org %s are not in --projects-file", orgID)
+	}
+	return integrations, nil
+}
+
+func (s *savedProjectsAPI) IntegrationActive(ctx context.Context, orgID, integrationID string) (bool, error) {
+	active, ok := s.file.IntegrationActive[orgID][integrationID]
+	if !ok {
+		return false, fmt.Errorf("status of integration %s in org %s is not in --projects-file; record it with --filter-integration-active", integrationID, orgID)
+	}
+	return active, nil
+}
+
+func (s *savedProjectsAPI) FetchProjects(ctx context.Context, orgID string) ([]internal.Project, error) {
+	projects, ok := s.file.Projects[orgID]
+	if !ok {
+		return nil, fmt.Errorf("org %s is not in --projects-file", orgID)
+	}
+	return projects, nil
+}
+
+// projectRecorder passes every call through and keeps the results of the
+// successful calls savedProjectsAPI serves, for --dump-projects.
+type projectRecorder struct {
+	SnykAPI
+	mu   sync.Mutex
+	file projectsDump
+}
+
+func newProjectRecorder(api SnykAPI) *projectRecorder {
+	return &projectRecorder{SnykAPI: api, file: projectsDump{
+		Groups:            make(map[string][]internal.Org),
+		Projects:          make(map[string][]internal.Project),
+		Integrations:      make(map[string]map[string]string),
+		IntegrationActive: make(map[string]map[string]bool),
+	}}
+}
+
+func (r *projectRecorder) FetchOrgs(ctx context.Context, groupID string) ([]internal.Org, error) {
+	orgs, err := r.SnykAPI.FetchOrgs(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.file.Groups[groupID] = orgs
+	r.mu.Unlock()
+	return orgs, nil
+}
+
+func (r *projectRecorder) ListIntegrations(ctx context.Context, orgID string) (map[string]string, error) {
+	integrations, err := r.SnykAPI.ListIntegrations(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.file.Integrations[orgID] = integrations
+	r.mu.Unlock()
+	return integrations, nil
+}
+
+func (r *projectRecorder) IntegrationActive(ctx context.Context, orgID, integrationID string) (bool, error) {
+	active, err := r.SnykAPI.IntegrationActive(ctx, orgID, integrationID)
+	if err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	if r.file.IntegrationActive[orgID] == nil {
+		r.file.IntegrationActive[orgID] = make(map[string]bool)
+	}
+	r.file.IntegrationActive[orgID][integrationID] = active
+	r.mu.Unlock()
+	return active, nil
+}
+
+func (r *projectRecorder) FetchProjects(ctx context.Context, orgID string) ([]internal.Project, error) {
+	projects, err := r.SnykAPI.FetchProjects(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.file.Projects[orgID] = projects
+	r.mu.Unlock()
+	return projects, nil
+}
+
+// write saves the recorded calls to path in the --projects-file format and
+// returns how many orgs' projects it holds. Empty results are written as
+// empty lists and maps, so replaying the file does not fail them.
+func (r *projectRecorder) write(path string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := projectsDump{
+		Groups:            make(map[string][]internal.Org, len(r.file.Groups)),
+		Projects:          make(map[string][]internal.Project, len(r.file.Projects)),
+		Integrations:      make(map[string]map[string]string, len(r.file.Integrations)),
+		IntegrationActive: r.file.IntegrationActive,
+	}
+	for id, orgs := range r.file.Groups {
+		if orgs == nil {
+			orgs = []internal.Org{}
+		}
+		f.Groups[id] = orgs
+	}
+	for id, projects := range r.file.Projects {
+		if projects == nil {
+			projects = []internal.Project{}
+		}
+		f.Projects[id] = projects
+	}
+	for id, integrations := range r.file.Integrations {
+		if integrations == nil {
+			integrations = map[string]string{}
+		}
+		f.Integrations[id] = integrations
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return 0, fmt.Errorf("marshal projects: %w", err)
+	}
+	safePath, err := sanitizeOutputPath(path)
+	if err != nil {
+		return 0, err
+	}
+	return len(f.Projects), writeFileAtomic(safePath, append(data, '\n'))
+}
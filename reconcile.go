@@ -0,0 +1,186 @@
+// reconcile.go implements the reconcile subcommand: check a refresh output
+// file against the targets that now exist in Snyk, to confirm an import
+// created everything it was given.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/snyk-playground/snyk-target-export/internal"
+)
+
+// reconcileResult is the outcome of checking one file against live targets.
+// FailedOrgs maps org ID to the error that kept its targets from being
+// listed; that org's file targets are in neither Present nor Missing.
+type reconcileResult struct {
+	Present    []internal.ImportTarget `json:"present"`
+	Missing    []internal.ImportTarget `json:"missing"`
+	FailedOrgs map[string]string       `json:"failedOrgs,omitempty"`
+}
+
+// liveTargetKey is the key a live Snyk target is matched on within an org:
+// its display name normalized like targetMatchKey, so "Owner/Repo (main)"
+// matches the file's owner/repo.
+func liveTargetKey(t internal.APITarget) string {
+	return targetMatchKey(t.DisplayName, true)
+}
+
+// reconcileOrg splits one org's file targets into those with a live target
+// of the same repo and those without. The branch is not compared, since
+// display names do not reliably carry it. When both sides know the
+// integration, it must match too.
+func reconcileOrg(fileTargets []internal.ImportTarget, live []internal.APITarget) (present, missing []internal.ImportTarget) {
+	integrations := make(map[string][]string)
+	for _, t := range live {
+		key := liveTargetKey(t)
+		integrations[key] = append(integrations[key], t.IntegrationID)
+	}
+	for _, t := range fileTargets {
+		found := false
+		for _, id := range integrations[targetRepo(t.Target)] {
+			if id == "" || t.IntegrationID == "" || id == t.IntegrationID {
+				found = true
+				break
+			}
+		}
+		if found {
+			present = append(present, t)
+		} else {
+			missing = append(missing, t)
+		}
+	}
+	return present, missing
+}
+
+// reconcileTargets lists the live targets of every org in out, up to
+// concurrency orgs at a time, and reconciles each org's file targets against
+// them. Results are sorted by target ID.
+func reconcileTargets(ctx context.Context, api SnykAPI, out RefreshOutput, concurrency int) reconcileResult {
+	byOrg := make(map[string][]internal.ImportTarget)
+	for _, t := range out.Targets {
+		byOrg[t.OrgID] = append(byOrg[t.OrgID], t)
+	}
+	res := reconcileResult{Present: []internal.ImportTarget{}, Missing: []internal.ImportTarget{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	orgLim := newLimiter(concurrency)
+	for orgID, targets := range byOrg {
+		wg.Add(1)
+		go func(orgID string, targets []internal.ImportTarget) {
+			defer wg.Done()
+			if err := orgLim.acquire(ctx); err != nil {
+				return
+			}
+			defer orgLim.release()
+			live, err := api.FetchTargets(internal.WithRequestLabel(ctx, "org "+orgID), orgID)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if res.FailedOrgs == nil {
+					res.FailedOrgs = make(map[string]string)
+				}
+				res.FailedOrgs[orgID] = err.Error()
+				return
+			}
+			present, missing := reconcileOrg(targets, live)
+			res.Present = append(res.Present, present...)
+			res.Missing = append(res.Missing, missing...)
+		}(orgID, targets)
+	}
+	wg.Wait()
+	byID := func(ts []internal.ImportTarget) {
+		sort.Slice(ts, func(i, j int) bool { return importTargetID(ts[i]) < importTargetID(ts[j]) })
+	}
+	byID(res.Present)
+	byID(res.Missing)
+	return res
+}
+
+// printReconcileText writes the counts, then each missing target and failed
+// org.
+func printReconcileText(w io.Writer, res reconcileResult) {
+	fmt.Fprintf(w, "Targets: %d present, %d missing\n", len(res.Present), len(res.Missing))
+	for _, t := range res.Missing {
+		fmt.Fprintf(w, "  missing %s\n", importTargetID(t))
+	}
+	if len(res.FailedOrgs) > 0 {
+		ids := make([]string, 0, len(res.FailedOrgs))
+		for id := range res.FailedOrgs {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		fmt.Fprintf(w, "Orgs not checked: %d\n", len(ids))
+		for _, id := range ids {
+			fmt.Fprintf(w, "  %s: %s\n", id, res.FailedOrgs[id])
+		}
+	}
+}
+
+// runReconcile implements the reconcile subcommand. It exits 1 when any
+// target is missing or any org could not be checked.
+func runReconcile(args []string) {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	apiOpts := addAPIFlags(fs)
+	format := fs.String("format", "text", "Output format: text or json")
+	concurrency := fs.Int("concurrency", 5, "Maximum number of orgs whose targets are listed at once")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: snyk-target-export reconcile [--format=text|json] <refresh.json>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Error: --format must be text or json, got %q\n", *format)
+		os.Exit(1)
+	}
+	if err := validateConcurrency("concurrency", *concurrency); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Error: reconcile takes exactly one refresh output file\n")
+		fs.Usage()
+		os.Exit(1)
+	}
+	out, err := loadRefreshOutput(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := configureAPI(apiOpts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer warnDeprecatedAPIVersion()
+
+	token, err := internal.GetSnykToken()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	api := newSnykAPI(internal.NewHTTPClient(), token, false)
+	res := reconcileTargets(context.Background(), api, out, *concurrency)
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		printReconcileText(os.Stdout, res)
+	}
+	if len(res.Missing) > 0 || len(res.FailedOrgs) > 0 {
+		os.Exit(1)
+	}
+}
@@ -3,13 +3,24 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math/rand/v2"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/snyk-playground/snyk-target-export/internal"
 )
@@ -26,67 +37,983 @@ type RefreshOutput struct {
 	Orgs         map[string]OrgMeta      `json:"orgs"`
 	Integrations map[string]string       `json:"integrations"`
 	Targets      []internal.ImportTarget `json:"targets"`
+	// PartialOrgs lists orgs that failed during the run (--prune-output), so
+	// consumers know the file is incomplete for them.
+	PartialOrgs []string `json:"partialOrgs,omitempty"`
+}
+
+// OrgTargets is one org's entry in GroupedRefreshOutput.
+type OrgTargets struct {
+	Meta    OrgMeta                 `json:"meta"`
+	Targets []internal.ImportTarget `json:"targets"`
+}
+
+// GroupedRefreshOutput is an alternate output schema (--group-output) that nests
+// targets under their org. It is NOT consumable by snyk-api-import; it exists
+// for custom tooling that prefers per-org grouping.
+type GroupedRefreshOutput struct {
+	GroupID      string                `json:"groupId,omitempty"`
+	Integrations map[string]string     `json:"integrations"`
+	Orgs         map[string]OrgTargets `json:"orgs"`
+	PartialOrgs  []string              `json:"partialOrgs,omitempty"`
+}
+
+// groupOutputByOrg restructures a flat RefreshOutput into GroupedRefreshOutput.
+// Orgs with metadata but no targets are kept with an empty targets list.
+func groupOutputByOrg(out RefreshOutput) GroupedRefreshOutput {
+	grouped := GroupedRefreshOutput{
+		GroupID:      out.GroupID,
+		Integrations: out.Integrations,
+		Orgs:         make(map[string]OrgTargets),
+		PartialOrgs:  out.PartialOrgs,
+	}
+	for id, meta := range out.Orgs {
+		grouped.Orgs[id] = OrgTargets{Meta: meta, Targets: []internal.ImportTarget{}}
+	}
+	for _, t := range out.Targets {
+		entry, ok := grouped.Orgs[t.OrgID]
+		if !ok {
+			entry = OrgTargets{Meta: out.Orgs[t.OrgID]}
+		}
+		entry.Targets = append(entry.Targets, t)
+		grouped.Orgs[t.OrgID] = entry
+	}
+	return grouped
+}
+
+// splitByIntegrationType partitions out into one RefreshOutput per integration
+// type (--split-by-integration-type), keyed by the type normalized with
+// OriginToIntegrationKey. Each part carries only the orgs and integrations its
+// targets reference, so it is a complete file on its own. Types with no
+// targets do not appear.
+func splitByIntegrationType(out RefreshOutput) map[string]RefreshOutput {
+	parts := make(map[string]RefreshOutput)
+	for _, t := range out.Targets {
+		typ := internal.OriginToIntegrationKey(out.Integrations[t.IntegrationID])
+		if typ == "" {
+			typ = "unknown"
+		}
+		part, ok := parts[typ]
+		if !ok {
+			part = RefreshOutput{
+				GroupID:      out.GroupID,
+				Orgs:         make(map[string]OrgMeta),
+				Integrations: make(map[string]string),
+				PartialOrgs:  out.PartialOrgs,
+			}
+		}
+		if meta, ok := out.Orgs[t.OrgID]; ok {
+			part.Orgs[t.OrgID] = meta
+		}
+		if it, ok := out.Integrations[t.IntegrationID]; ok {
+			part.Integrations[t.IntegrationID] = it
+		}
+		part.Targets = append(part.Targets, t)
+		parts[typ] = part
+	}
+	return parts
+}
+
+// splitFileName returns the file name for an integration type's part,
+// replacing anything outside [A-Za-z0-9_-] so a type can't escape the
+// output directory.
+func splitFileName(typ string) string {
+	safe := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, typ)
+	return "refresh-" + safe + ".json"
+}
+
+// stableOrg is one entry of StableRefreshOutput.Orgs.
+type stableOrg struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	Slug string `json:"slug,omitempty"`
+}
+
+// stableIntegration is one entry of StableRefreshOutput.Integrations.
+type stableIntegration struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+// StableRefreshOutput is RefreshOutput with orgs and integrations as arrays
+// sorted by ID and targets in a fixed order (--stable-maps), so the file is
+// byte-stable across runs for source control. snyk-api-import only reads
+// targets, so it still accepts this form.
+type StableRefreshOutput struct {
+	GroupID      string                  `json:"groupId,omitempty"`
+	Orgs         []stableOrg             `json:"orgs"`
+	Integrations []stableIntegration     `json:"integrations"`
+	Targets      []internal.ImportTarget `json:"targets"`
+	PartialOrgs  []string                `json:"partialOrgs,omitempty"`
+}
+
+// toStableOutput converts out to StableRefreshOutput. Targets are sorted by
+// org ID, then target ID; out is not modified.
+func toStableOutput(out RefreshOutput) StableRefreshOutput {
+	stable := StableRefreshOutput{
+		GroupID:      out.GroupID,
+		Orgs:         make([]stableOrg, 0, len(out.Orgs)),
+		Integrations: make([]stableIntegration, 0, len(out.Integrations)),
+		Targets:      append([]internal.ImportTarget{}, out.Targets...),
+		PartialOrgs:  out.PartialOrgs,
+	}
+	for id, meta := range out.Orgs {
+		stable.Orgs = append(stable.Orgs, stableOrg{ID: id, Name: meta.Name, Slug: meta.Slug})
+	}
+	sort.Slice(stable.Orgs, func(i, j int) bool { return stable.Orgs[i].ID < stable.Orgs[j].ID })
+	for id, typ := range out.Integrations {
+		stable.Integrations = append(stable.Integrations, stableIntegration{ID: id, Type: typ})
+	}
+	sort.Slice(stable.Integrations, func(i, j int) bool { return stable.Integrations[i].ID < stable.Integrations[j].ID })
+	sort.SliceStable(stable.Targets, func(i, j int) bool {
+		a, b := stable.Targets[i], stable.Targets[j]
+		if a.OrgID != b.OrgID {
+			return a.OrgID < b.OrgID
+		}
+		return importTargetID(a) < importTargetID(b)
+	})
+	return stable
+}
+
+// fromStableOutput converts a StableRefreshOutput back to the map form.
+func fromStableOutput(stable StableRefreshOutput) RefreshOutput {
+	out := RefreshOutput{
+		GroupID:      stable.GroupID,
+		Orgs:         make(map[string]OrgMeta, len(stable.Orgs)),
+		Integrations: make(map[string]string, len(stable.Integrations)),
+		Targets:      stable.Targets,
+		PartialOrgs:  stable.PartialOrgs,
+	}
+	for _, o := range stable.Orgs {
+		out.Orgs[o.ID] = OrgMeta{Name: o.Name, Slug: o.Slug}
+	}
+	for _, i := range stable.Integrations {
+		out.Integrations[i.ID] = i.Type
+	}
+	return out
+}
+
+// pruneFailedOrgs drops any targets belonging to failedIDs and records those
+// orgs, sorted, in out.PartialOrgs (--prune-output).
+func pruneFailedOrgs(out *RefreshOutput, failedIDs []string) {
+	if len(failedIDs) == 0 {
+		return
+	}
+	failed := make(map[string]bool, len(failedIDs))
+	for _, id := range failedIDs {
+		failed[id] = true
+	}
+	kept := out.Targets[:0]
+	for _, t := range out.Targets {
+		if !failed[t.OrgID] {
+			kept = append(kept, t)
+		}
+	}
+	out.Targets = kept
+	out.PartialOrgs = append([]string(nil), failedIDs...)
+	sort.Strings(out.PartialOrgs)
+}
+
+// partialSnapshot returns out as flushed by --flush-every before every org is
+// done. With --prune-output, the orgs not yet handled and those that failed
+// are listed in partialOrgs, so a consumer can tell the file is incomplete.
+func partialSnapshot(out RefreshOutput, orgs []internal.Org, handled map[string]bool, failedIDs []string, prune bool) RefreshOutput {
+	if !prune {
+		return out
+	}
+	partial := append([]string(nil), failedIDs...)
+	for _, o := range orgs {
+		if !handled[o.ID] {
+			partial = append(partial, o.ID)
+		}
+	}
+	sort.Strings(partial)
+	out.PartialOrgs = partial
+	return out
+}
+
+// orgRetryPause is how long --org-retries waits before re-attempting the
+// orgs that failed, so a short outage has a chance to clear.
+const orgRetryPause = 10 * time.Second
+
+// retriedOrgLabels returns the labels of orgs that needed an --org-retries
+// pass, sorted.
+func retriedOrgLabels(retried map[string]string) []string {
+	labels := make([]string, 0, len(retried))
+	for _, label := range retried {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// Output schemas selectable with --output-schema.
+const (
+	outputSchemaV1 = "v1" // bare targets list: orgId, integrationId, target only
+	outputSchemaV2 = "v2" // current: RefreshOutput with metadata and optional files
+)
+
+// v1ImportTarget is the minimal per-target shape of the v1 schema.
+type v1ImportTarget struct {
+	Target        internal.Target `json:"target"`
+	OrgID         string          `json:"orgId"`
+	IntegrationID string          `json:"integrationId"`
+}
+
+// v1RefreshOutput is the v1 file: only the targets list, with no org or
+// integration metadata, for consumers that reject unknown keys.
+type v1RefreshOutput struct {
+	Targets []v1ImportTarget `json:"targets"`
+}
+
+// toOutputSchema converts out to the marshaling struct for the given schema.
+func toOutputSchema(out RefreshOutput, schema string) (interface{}, error) {
+	switch schema {
+	case outputSchemaV2:
+		return out, nil
+	case outputSchemaV1:
+		v1 := v1RefreshOutput{Targets: make([]v1ImportTarget, len(out.Targets))}
+		for i, t := range out.Targets {
+			v1.Targets[i] = v1ImportTarget{Target: t.Target, OrgID: t.OrgID, IntegrationID: t.IntegrationID}
+		}
+		return v1, nil
+	default:
+		return nil, fmt.Errorf("--output-schema must be v1 or v2, got %q", schema)
+	}
 }
 
 // refreshOrgResult holds the result of processing one org for the refresh command.
 type refreshOrgResult struct {
-	targets     []internal.ImportTarget
-	orgMeta     map[string]OrgMeta
-	intMeta     map[string]string
-	gitlabCount int
-	err         error
-	orgID       string
-	orgLabel    string
+	targets  []internal.ImportTarget
+	orgMeta  map[string]OrgMeta
+	intMeta  map[string]string
+	counts   refreshCounts
+	err      error
+	orgID    string
+	orgLabel string
+	// skippedNoIntegrations is set when --skip-orgs-without-integrations
+	// skipped the org before fetching projects.
+	skippedNoIntegrations bool
+	// inactiveIntegrations lists the integration types dropped by
+	// --filter-integration-active.
+	inactiveIntegrations []string
+	// skippedStatus is the HTTP status that made the org be skipped under
+	// --skip-status (0 when not skipped); skipReason is the underlying error.
+	skippedStatus int
+	skipReason    error
+	// elapsed, retries, and projects feed --concurrency-stats-file: the
+	// org's wall time, API retries made on its behalf, and projects fetched.
+	elapsed  time.Duration
+	retries  int64
+	projects int
+}
+
+// refreshCounts tallies how projects were handled during conversion: emitted
+// targets per integration key, and projects skipped for each reason.
+type refreshCounts struct {
+	byOrigin      map[string]int       // emitted targets keyed by integration type
+	gitlab        int                  // GitLab projects (unsupported for re-import)
+	nonSCM        int                  // non-SCM origins (cli, docker-hub, ...)
+	noIntegration int                  // SCM origin with no matching integration in the org
+	importing     int                  // still importing (only counted with --skip-importing)
+	broken        int                  // inactive or broken remote (only counted with --skip-broken)
+	ownerRewrites int                  // emitted targets whose owner was remapped by --rewrite-owner
+	excluded      int                  // projects dropped by --exclude-project-id or --exclude-target-id
+	otherOrigin   int                  // projects dropped by --only-origin
+	collapsed     int                  // targets dropped by --collapse-equivalent-integrations
+	branch        int                  // projects dropped by --branch, --exclude-branch, or --require-branch
+	invalidID     int                  // targets dropped by --validate for a malformed integration ID
+	notIncluded   int                  // projects whose target is not listed in --include-file
+	legacy        int                  // projects created before --legacy-before (kept)
+	notLegacy     int                  // projects created on or after --legacy-before, or undated
+	unparseable   []unparseableProject // SCM projects whose name is not owner/repo
+	origins       map[string]int       // every fetched project, keyed by origin (only with --origin-report)
+	originOrgs    map[string]int       // orgs with at least one project of each origin (filled by add)
+	// mapping records, per converted project ID, the target it became
+	// (only with --mapping-file).
+	mapping map[string]projectMapping
+}
+
+// projectMapping is the target one Snyk project was converted to, as written
+// by --mapping-file.
+type projectMapping struct {
+	OrgID         string          `json:"orgId"`
+	IntegrationID string          `json:"integrationId"`
+	Target        internal.Target `json:"target"`
+}
+
+// unparseableProject is an SCM project dropped because its name could not be
+// turned into a target (empty, or no owner/repo), as written by
+// --report-unparseable.
+type unparseableProject struct {
+	OrgID     string `json:"orgId"`
+	ProjectID string `json:"projectId"`
+	Name      string `json:"name"`
+	Origin    string `json:"origin"`
+}
+
+// add accumulates other into c.
+func (c *refreshCounts) add(other refreshCounts) {
+	if c.byOrigin == nil {
+		c.byOrigin = make(map[string]int)
+	}
+	for k, v := range other.byOrigin {
+		c.byOrigin[k] += v
+	}
+	c.gitlab += other.gitlab
+	c.nonSCM += other.nonSCM
+	c.noIntegration += other.noIntegration
+	c.importing += other.importing
+	c.broken += other.broken
+	c.ownerRewrites += other.ownerRewrites
+	c.excluded += other.excluded
+	c.otherOrigin += other.otherOrigin
+	c.collapsed += other.collapsed
+	c.branch += other.branch
+	c.invalidID += other.invalidID
+	c.notIncluded += other.notIncluded
+	c.legacy += other.legacy
+	c.notLegacy += other.notLegacy
+	c.unparseable = append(c.unparseable, other.unparseable...)
+	if len(other.origins) > 0 && c.origins == nil {
+		c.origins = make(map[string]int, len(other.origins))
+		c.originOrgs = make(map[string]int, len(other.origins))
+	}
+	for o, n := range other.origins {
+		c.origins[o] += n
+		c.originOrgs[o]++
+	}
+	if len(other.mapping) > 0 && c.mapping == nil {
+		c.mapping = make(map[string]projectMapping, len(other.mapping))
+	}
+	for id, m := range other.mapping {
+		c.mapping[id] = m
+	}
+}
+
+// writeMappingFile writes the project ID -> target mapping (--mapping-file)
+// as a JSON object keyed by project ID.
+func writeMappingFile(path string, mapping map[string]projectMapping) error {
+	safePath, err := sanitizeOutputPath(path)
+	if err != nil {
+		return err
+	}
+	if mapping == nil {
+		mapping = map[string]projectMapping{}
+	}
+	data, err := json.MarshalIndent(mapping, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal mapping: %w", err)
+	}
+	return writeFileAtomic(safePath, append(data, '\n'))
+}
+
+// concurrencyStats is the --concurrency-stats-file report: the run's
+// concurrency settings and, per org, how long it took and how it went.
+type concurrencyStats struct {
+	Concurrency      int        `json:"concurrency"`
+	MaxConcurrency   int        `json:"maxConcurrency,omitempty"`
+	FinalConcurrency int        `json:"finalConcurrency"`
+	ElapsedMs        int64      `json:"elapsedMs"`
+	Retries          int64      `json:"retries"`
+	Orgs             []orgStats `json:"orgs"`
+}
+
+// orgStats is one org's entry in concurrencyStats.
+type orgStats struct {
+	OrgID     string `json:"orgId"`
+	Label     string `json:"label"`
+	ElapsedMs int64  `json:"elapsedMs"`
+	Projects  int    `json:"projects"`
+	Targets   int    `json:"targets"`
+	Retries   int64  `json:"retries"`
+	Failed    bool   `json:"failed"`
+	Skipped   bool   `json:"skipped,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// newOrgStats builds the concurrencyStats entry for one org's result.
+func newOrgStats(res refreshOrgResult) orgStats {
+	s := orgStats{
+		OrgID:     res.orgID,
+		Label:     res.orgLabel,
+		ElapsedMs: res.elapsed.Milliseconds(),
+		Projects:  res.projects,
+		Targets:   len(res.targets),
+		Retries:   res.retries,
+		Failed:    res.err != nil,
+		Skipped:   res.skippedStatus != 0 || res.skippedNoIntegrations,
+	}
+	if res.err != nil {
+		s.Error = res.err.Error()
+	}
+	return s
+}
+
+// writeConcurrencyStats writes stats as JSON, slowest org first, so
+// pathological orgs are at the top.
+func writeConcurrencyStats(path string, stats concurrencyStats) error {
+	safePath, err := sanitizeOutputPath(path)
+	if err != nil {
+		return err
+	}
+	if stats.Orgs == nil {
+		stats.Orgs = []orgStats{}
+	}
+	sort.SliceStable(stats.Orgs, func(i, j int) bool {
+		if stats.Orgs[i].ElapsedMs != stats.Orgs[j].ElapsedMs {
+			return stats.Orgs[i].ElapsedMs > stats.Orgs[j].ElapsedMs
+		}
+		return stats.Orgs[i].OrgID < stats.Orgs[j].OrgID
+	})
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal concurrency stats: %w", err)
+	}
+	return writeFileAtomic(safePath, append(data, '\n'))
+}
+
+// writeUnparseable writes projects to path (--report-unparseable) as a JSON
+// array sorted by org ID and name.
+func writeUnparseable(path string, projects []unparseableProject) error {
+	safePath, err := sanitizeOutputPath(path)
+	if err != nil {
+		return err
+	}
+	sorted := append([]unparseableProject{}, projects...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].OrgID != sorted[j].OrgID {
+			return sorted[i].OrgID < sorted[j].OrgID
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	data, err := json.MarshalIndent(sorted, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal unparseable projects: %w", err)
+	}
+	return writeFileAtomic(safePath, append(data, '\n'))
+}
+
+// formatOriginCounts renders counts as "github: 120, azure-repos: 10", largest first.
+func formatOriginCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s: %d", k, counts[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// refreshOptions holds the flag-derived settings that shape how projects become import targets.
+type refreshOptions struct {
+	integrationTypes map[string]bool // nil means all types
+	onlyOrigins      map[string]bool // --only-origin, raw and normalized; nil means all origins
+	skipStatuses     map[int]bool    // --skip-status: API statuses that skip an org instead of failing it
+	branches         map[string]bool // --branch: keep only these resolved branches; nil means all
+	excludeBranches  map[string]bool // --exclude-branch
+	requireBranch    bool            // --require-branch: drop projects with no resolved branch
+	explicitBranch   bool            // --explicit-branch: write an unknown branch as null
+	emitFiles        bool
+	skipImporting    bool
+	skipBroken       bool // drop projects whose status suggests the repo is gone
+	countManifests   bool // annotate each target with its source project count
+	explain          bool // log the origin -> integration key -> ID lookup per target
+	withProvenance   bool // record the source project ID and name on each target
+	verbose          bool // log each dropped unparseable project name
+	recordMapping    bool // fill refreshCounts.mapping for --mapping-file
+	originReport     bool // fill refreshCounts.origins for --origin-report
+	// collapseEquivalent (--collapse-equivalent-integrations) keeps only the
+	// canonical integration's target when a repo was imported under
+	// equivalent integrations (e.g. bitbucket-cloud and bitbucket-connect-app).
+	collapseEquivalent bool
+	// validate (--validate) drops targets whose integration ID is not a UUID.
+	validate bool
+	// skipOrgsWithoutIntegrations lists integrations first and skips the
+	// project fetch for orgs with no SCM integration.
+	skipOrgsWithoutIntegrations bool
+	// filterIntegrationActive checks each SCM integration's status and
+	// drops inactive ones before conversion (--filter-integration-active).
+	filterIntegrationActive bool
+	// sequentialInner (--parallel-inner=false) lists integrations and then
+	// fetches projects one after the other instead of concurrently.
+	sequentialInner   bool
+	ownerRewrites     map[string]string // lower-cased old owner -> new owner
+	excludeProjectIDs map[string]bool   // --exclude-project-id
+	excludeTargetIDs  map[string]bool   // --exclude-target-id, matched against internal.TargetID
+	include           *targetAllowlist  // --include-file; nil keeps every target
+	legacyBefore      time.Time         // --legacy-before; zero keeps projects of any age
+	// integrationsFallback (--integrations-file) maps org ID -> integration
+	// type -> integration ID, used when listing an org's integrations fails.
+	integrationsFallback map[string]map[string]string
+}
+
+// loadIntegrationsFile reads a --integrations-file: a JSON object mapping org
+// ID to that org's integrations, keyed by type as ListIntegrations returns
+// them, e.g. {"<org-id>": {"github": "<integration-id>"}}.
+func loadIntegrationsFile(path string) (map[string]map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read integrations file: %w", err)
+	}
+	var byOrg map[string]map[string]string
+	if err := json.Unmarshal(data, &byOrg); err != nil {
+		return nil, fmt.Errorf("decode integrations file %s: %w", path, err)
+	}
+	for orgID, integrations := range byOrg {
+		for intType, intID := range integrations {
+			if intType == "" || intID == "" {
+				return nil, fmt.Errorf("integrations file %s: org %s has an empty integration type or ID", path, orgID)
+			}
+		}
+	}
+	return byOrg, nil
+}
+
+// withIntegrationsFallback returns integrations as-is when err is nil.
+// Otherwise it falls back to the org's --integrations-file entry, if any, so
+// a flaky integrations endpoint does not discard the org's projects.
+func withIntegrationsFallback(opts refreshOptions, orgID, label string, integrations map[string]string, err error) (map[string]string, error) {
+	if err == nil {
+		return integrations, nil
+	}
+	fallback, ok := opts.integrationsFallback[orgID]
+	if !ok {
+		return nil, err
+	}
+	log.Printf("WARNING: Org %s: listing integrations failed (%v); using --integrations-file", label, err)
+	return fallback, nil
+}
+
+// originSet returns the --only-origin values, each also normalized with
+// OriginToIntegrationKey so aliases match, or nil when none were given.
+func originSet(origins stringList) map[string]bool {
+	set := origins.set()
+	for _, o := range origins {
+		set[internal.OriginToIntegrationKey(o)] = true
+	}
+	return set
+}
+
+// parseOwnerRewrites turns --rewrite-owner old=new entries into a lookup keyed
+// by lower-cased old owner (GitHub owners are case-insensitive).
+func parseOwnerRewrites(entries []string) (map[string]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	rewrites := make(map[string]string, len(entries))
+	for _, e := range entries {
+		oldOwner, newOwner, ok := strings.Cut(e, "=")
+		oldOwner, newOwner = strings.TrimSpace(oldOwner), strings.TrimSpace(newOwner)
+		if !ok || oldOwner == "" || newOwner == "" {
+			return nil, fmt.Errorf("--rewrite-owner must be old=new, got %q", e)
+		}
+		key := strings.ToLower(oldOwner)
+		if prev, dup := rewrites[key]; dup && prev != newOwner {
+			return nil, fmt.Errorf("--rewrite-owner: %q mapped to both %q and %q", oldOwner, prev, newOwner)
+		}
+		rewrites[key] = newOwner
+	}
+	return rewrites, nil
 }
 
 // projectsToImportTargets converts Snyk projects to import targets for the given org,
-// applying SCM filtering, integration-type filter, and deduplication. Returns targets and gitlab skipped count.
-func projectsToImportTargets(org internal.Org, projects []internal.Project, integrations map[string]string, integrationType string) ([]internal.ImportTarget, int) {
+// applying SCM filtering, integration-type filter, and deduplication. Returns targets and per-origin/skip counts.
+// When opts.emitFiles is set, projects that share a repo+branch are collapsed into one target whose
+// files list holds each project's manifest path. When opts.countManifests is set, each target's
+// ProjectCount records how many projects were collapsed into it.
+func projectsToImportTargets(org internal.Org, projects []internal.Project, integrations map[string]string, opts refreshOptions) ([]internal.ImportTarget, refreshCounts) {
 	var targets []internal.ImportTarget
-	seen := make(map[string]bool)
-	gitlabSkipped := 0
+	seen := make(map[string]int)
+	wholeRepo := make(map[int]bool)
+	counts := refreshCounts{byOrigin: make(map[string]int)}
+	if opts.originReport {
+		counts.origins = make(map[string]int)
+	}
 
 	for _, p := range projects {
+		if opts.originReport {
+			counts.origins[p.Origin]++
+		}
+		if opts.excludeProjectIDs[p.ID] {
+			log.Printf("Org %s: excluding project %s (%s) per --exclude-project-id", orgLabel(org), p.ID, p.Name)
+			counts.excluded++
+			continue
+		}
+		if !opts.legacyBefore.IsZero() {
+			if !createdBefore(p.Created, opts.legacyBefore) {
+				counts.notLegacy++
+				continue
+			}
+			counts.legacy++
+		}
+		if opts.onlyOrigins != nil && !opts.onlyOrigins[p.Origin] && !opts.onlyOrigins[internal.OriginToIntegrationKey(p.Origin)] {
+			counts.otherOrigin++
+			continue
+		}
+		if opts.skipImporting && p.IsImporting() {
+			counts.importing++
+			continue
+		}
+		if opts.skipBroken && p.IsBroken() {
+			counts.broken++
+			continue
+		}
 		if p.Origin == "gitlab" {
-			gitlabSkipped++
+			counts.gitlab++
 			continue
 		}
 		if !internal.IsSCMOrigin(p.Origin) {
+			counts.nonSCM++
 			continue
 		}
-		if integrationType != "" && p.Origin != integrationType && internal.OriginToIntegrationKey(p.Origin) != integrationType {
+		intKey := internal.OriginToIntegrationKey(p.Origin)
+		if opts.integrationTypes != nil && !opts.integrationTypes[p.Origin] && !opts.integrationTypes[intKey] {
 			continue
 		}
-		intKey := internal.OriginToIntegrationKey(p.Origin)
 		integrationID, ok := integrations[intKey]
 		if !ok || integrationID == "" {
+			if opts.explain {
+				log.Printf("[EXPLAIN] Org %s: project %s (%s) origin=%s key=%s: no integration with that key, skipped",
+					orgLabel(org), p.ID, p.Name, p.Origin, intKey)
+			}
+			counts.noIntegration++
 			continue
 		}
 		branch := p.Branch
 		if branch == "" {
 			branch = p.TargetReference
 		}
+		if !branchAllowed(branch, opts) {
+			counts.branch++
+			continue
+		}
 		target, ok := internal.ProjectToTarget(p.Name, p.Origin, branch)
 		if !ok {
+			if opts.verbose {
+				log.Printf("Org %s: dropping project %s: cannot parse owner/repo from name %q", orgLabel(org), p.ID, p.Name)
+			}
+			counts.unparseable = append(counts.unparseable, unparseableProject{OrgID: org.ID, ProjectID: p.ID, Name: p.Name, Origin: p.Origin})
 			continue
 		}
+		// Bitbucket Server targets (projectKey/repoSlug) have no branch field.
+		target.NullBranch = opts.explicitBranch && target.Owner != ""
+		target.Host = internal.EnterpriseHost(p.Origin, p.RepoURL)
+		newOwner, rewritten := opts.ownerRewrites[strings.ToLower(target.Owner)]
+		if rewritten {
+			target.Owner = newOwner
+		}
 		tid := internal.TargetID(org.ID, integrationID, target)
-		if seen[tid] {
+		if opts.excludeTargetIDs[tid] {
+			log.Printf("Org %s: excluding project %s (%s) per --exclude-target-id %s", orgLabel(org), p.ID, p.Name, tid)
+			counts.excluded++
+			continue
+		}
+		if opts.include != nil && !opts.include.allows(tid, target) {
+			counts.notIncluded++
 			continue
 		}
-		seen[tid] = true
-		targets = append(targets, internal.ImportTarget{
-			Target:        target,
-			OrgID:         org.ID,
-			IntegrationID: integrationID,
-		})
+		idx, dup := seen[tid]
+		if !dup {
+			idx = len(targets)
+			seen[tid] = idx
+			targets = append(targets, internal.ImportTarget{
+				Target:        target,
+				OrgID:         org.ID,
+				IntegrationID: integrationID,
+			})
+			if opts.withProvenance {
+				targets[idx].ProjectID = p.ID
+				targets[idx].ProjectName = p.Name
+			}
+			counts.byOrigin[intKey]++
+			if rewritten {
+				counts.ownerRewrites++
+			}
+			if opts.explain {
+				log.Printf("[EXPLAIN] Org %s: project %s (%s) origin=%s key=%s integration=%s -> target %s",
+					orgLabel(org), p.ID, p.Name, p.Origin, intKey, integrationID, tid)
+			}
+		}
+		if opts.recordMapping {
+			if counts.mapping == nil {
+				counts.mapping = make(map[string]projectMapping)
+			}
+			counts.mapping[p.ID] = projectMapping{OrgID: org.ID, IntegrationID: integrationID, Target: target}
+		}
+		if opts.countManifests {
+			targets[idx].ProjectCount++
+		}
+		if opts.emitFiles && !wholeRepo[idx] {
+			// A project without a manifest path covers the whole repo; listing
+			// files would narrow the import, so drop them for this target.
+			path := internal.ManifestPath(p.Name)
+			if path == "" {
+				wholeRepo[idx] = true
+				targets[idx].Files = nil
+				continue
+			}
+			targets[idx].Files = appendFile(targets[idx].Files, path)
+		}
+	}
+	if opts.collapseEquivalent {
+		typeByID := make(map[string]string, len(integrations))
+		for typ, id := range integrations {
+			typeByID[id] = typ
+		}
+		var replaced map[string]string
+		before := targets
+		targets, replaced = collapseEquivalentTargets(targets, typeByID)
+		if len(replaced) > 0 {
+			for _, t := range before {
+				if _, ok := replaced[internal.TargetID(t.OrgID, t.IntegrationID, t.Target)]; ok {
+					counts.byOrigin[typeByID[t.IntegrationID]]--
+					counts.collapsed++
+				}
+			}
+			log.Printf("Org %s: collapsed %d target(s) onto an equivalent canonical integration", orgLabel(org), len(replaced))
+		}
+		for id, m := range counts.mapping {
+			if keep, ok := replaced[internal.TargetID(m.OrgID, m.IntegrationID, m.Target)]; ok {
+				m.IntegrationID = keep
+				counts.mapping[id] = m
+			}
+		}
+	}
+	if opts.validate {
+		var bad []internal.ImportTarget
+		targets, bad = dropMalformedIntegrationIDs(targets)
+		dropped := make(map[string]bool, len(bad))
+		for _, t := range bad {
+			log.Printf("WARNING: Org %s: dropping target %s/%s: integration ID %q is not a UUID (--validate)",
+				orgLabel(org), t.Target.Owner, t.Target.Name, t.IntegrationID)
+			counts.byOrigin[typeOfIntegration(integrations, t.IntegrationID)]--
+			counts.invalidID++
+			dropped[t.IntegrationID] = true
+		}
+		for id, m := range counts.mapping {
+			if dropped[m.IntegrationID] {
+				delete(counts.mapping, id)
+			}
+		}
+	}
+	return targets, counts
+}
+
+// uuidPattern matches a canonical, hyphenated UUID.
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// dropMalformedIntegrationIDs splits targets into those whose integration ID
+// is a UUID, as the Snyk API issues them, and those whose ID is not (a sign
+// that ListIntegrations returned an unexpected shape).
+func dropMalformedIntegrationIDs(targets []internal.ImportTarget) (kept, bad []internal.ImportTarget) {
+	kept = targets[:0:0]
+	for _, t := range targets {
+		if uuidPattern.MatchString(t.IntegrationID) {
+			kept = append(kept, t)
+		} else {
+			bad = append(bad, t)
+		}
 	}
-	return targets, gitlabSkipped
+	return kept, bad
+}
+
+// typeOfIntegration returns the integration type whose ID is id, or "".
+func typeOfIntegration(integrations map[string]string, id string) string {
+	for typ, v := range integrations {
+		if v == id {
+			return typ
+		}
+	}
+	return ""
+}
+
+// collapseEquivalentTargets drops each target that repeats another target's
+// repo and branch under an equivalent integration (see
+// internal.CanonicalIntegrationKey), keeping the one whose integration is the
+// canonical key. typeByID maps integration ID to integration key. Project
+// counts and manifest files of a dropped target are merged into the kept one.
+// It returns the remaining targets in their original order and, keyed by each
+// dropped target's TargetID, the integration ID of the target kept instead.
+func collapseEquivalentTargets(targets []internal.ImportTarget, typeByID map[string]string) ([]internal.ImportTarget, map[string]string) {
+	repoKey := func(t internal.ImportTarget) string {
+		fam := internal.CanonicalIntegrationKey(typeByID[t.IntegrationID])
+		tt := t.Target
+		return strings.ToLower(strings.Join([]string{t.OrgID, fam, tt.Owner, tt.Name, tt.ProjectKey, tt.RepoSlug}, "/")) + "@" + tt.Branch
+	}
+	canonical := make(map[string]int)
+	for i, t := range targets {
+		typ := typeByID[t.IntegrationID]
+		if internal.CanonicalIntegrationKey(typ) == typ {
+			if _, ok := canonical[repoKey(t)]; !ok {
+				canonical[repoKey(t)] = i
+			}
+		}
+	}
+	var replaced map[string]string
+	dropped := make(map[int]bool)
+	for i, t := range targets {
+		j, ok := canonical[repoKey(t)]
+		if !ok || j == i || typeByID[t.IntegrationID] == typeByID[targets[j].IntegrationID] {
+			continue
+		}
+		k := &targets[j]
+		k.ProjectCount += t.ProjectCount
+		switch {
+		case k.Files == nil:
+		case t.Files == nil:
+			k.Files = nil // t covered the whole repo
+		default:
+			for _, f := range t.Files {
+				k.Files = appendFile(k.Files, f.Path)
+			}
+		}
+		dropped[i] = true
+		if replaced == nil {
+			replaced = make(map[string]string)
+		}
+		replaced[internal.TargetID(t.OrgID, t.IntegrationID, t.Target)] = k.IntegrationID
+	}
+	if len(dropped) == 0 {
+		return targets, nil
+	}
+	kept := make([]internal.ImportTarget, 0, len(targets)-len(dropped))
+	for i, t := range targets {
+		if !dropped[i] {
+			kept = append(kept, t)
+		}
+	}
+	return kept, replaced
+}
+
+// branchAllowed reports whether a project on the resolved branch passes
+// --branch, --exclude-branch, and --require-branch. A project without a
+// branch passes --branch and --exclude-branch, since its branch is unknown.
+func branchAllowed(branch string, opts refreshOptions) bool {
+	if branch == "" {
+		return !opts.requireBranch
+	}
+	if opts.branches != nil && !opts.branches[branch] {
+		return false
+	}
+	return !opts.excludeBranches[branch]
+}
+
+// parseLegacyBefore parses a --legacy-before value: a date (2006-01-02,
+// midnight UTC) or an RFC 3339 timestamp.
+func parseLegacyBefore(v string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("--legacy-before must be a date (YYYY-MM-DD) or RFC 3339 timestamp, got %q", v)
+	}
+	return t, nil
+}
+
+// createdBefore reports whether a project's Created timestamp is before
+// cutoff. A missing or unparseable timestamp is not, since the project's age
+// is unknown.
+func createdBefore(created string, cutoff time.Time) bool {
+	t, err := time.Parse(time.RFC3339, created)
+	return err == nil && t.Before(cutoff)
+}
+
+// targetAllowlist is the set of targets read from --include-file: exact
+// target IDs (as printed by diff) and owner/repo names, which match the repo
+// in any org, integration, or branch.
+type targetAllowlist struct {
+	ids   map[string]bool
+	repos map[string]bool // lower-cased owner/repo, or projectKey/repoSlug
+}
+
+// targetRepo returns the lower-cased owner/repo a target is listed under in
+// --include-file: projectKey/repoSlug for Bitbucket Server, owner/name
+// otherwise.
+func targetRepo(t internal.Target) string {
+	if t.ProjectKey != "" || t.RepoSlug != "" {
+		return strings.ToLower(t.ProjectKey + "/" + t.RepoSlug)
+	}
+	return strings.ToLower(t.Owner + "/" + t.Name)
+}
+
+// allows reports whether the target with ID tid is listed.
+func (a *targetAllowlist) allows(tid string, t internal.Target) bool {
+	return a.ids[tid] || a.repos[targetRepo(t)]
+}
+
+// readIncludeFile reads a --include-file: one target per line, either a
+// target ID (orgId:integrationId:<target fields>) or owner/repo. Blank lines
+// and lines starting with # are ignored.
+func readIncludeFile(path string) (*targetAllowlist, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read include file: %w", err)
+	}
+	a := &targetAllowlist{ids: make(map[string]bool), repos: make(map[string]bool)}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.Count(line, ":") >= 2:
+			a.ids[line] = true
+		case strings.Count(line, "/") == 1 && !strings.ContainsAny(line, ": \t") && !strings.HasPrefix(line, "/") && !strings.HasSuffix(line, "/"):
+			a.repos[strings.ToLower(line)] = true
+		default:
+			return nil, fmt.Errorf("include file %s line %d: want a target ID or owner/repo, got %q", path, i+1, line)
+		}
+	}
+	if len(a.ids) == 0 && len(a.repos) == 0 {
+		return nil, fmt.Errorf("include file %s lists no targets", path)
+	}
+	return a, nil
+}
+
+// appendFile adds path to files unless it is already present.
+func appendFile(files []internal.File, path string) []internal.File {
+	for _, f := range files {
+		if f.Path == path {
+			return files
+		}
+	}
+	return append(files, internal.File{Path: path})
+}
+
+// hasSCMIntegration reports whether any integration key is a supported SCM type.
+func hasSCMIntegration(integrations map[string]string) bool {
+	for intType, intID := range integrations {
+		if intID != "" && internal.IsSCMOrigin(intType) {
+			return true
+		}
+	}
+	return false
 }
 
 // processOrgForRefresh fetches integrations and projects for one org and converts projects to import targets.
-func processOrgForRefresh(ctx context.Context, api SnykAPI, org internal.Org, integrationType string) refreshOrgResult {
-	res := refreshOrgResult{
+func processOrgForRefresh(ctx context.Context, api SnykAPI, org internal.Org, opts refreshOptions) (res refreshOrgResult) {
+	start := time.Now()
+	var retries atomic.Int64
+	ctx = internal.WithRetryCounter(ctx, &retries)
+	defer func() {
+		res.elapsed = time.Since(start)
+		res.retries = retries.Load()
+	}()
+	res = refreshOrgResult{
 		orgID:    org.ID,
 		orgLabel: orgLabel(org),
 		orgMeta:  make(map[string]OrgMeta),
@@ -95,52 +1022,142 @@ func processOrgForRefresh(ctx context.Context, api SnykAPI, org internal.Org, in
 	if org.Name != "" || org.Slug != "" {
 		res.orgMeta[org.ID] = OrgMeta{Name: org.Name, Slug: org.Slug}
 	}
+	ctx = internal.WithRequestLabel(ctx, "org "+res.orgLabel)
 
 	var integrations map[string]string
 	var projects []internal.Project
 	var intErr, projErr error
-	var innerWg sync.WaitGroup
-	innerWg.Add(2)
-	go func() {
-		defer innerWg.Done()
+	if opts.skipOrgsWithoutIntegrations || opts.sequentialInner {
+		// Sequential: a cheap integrations call decides whether the
+		// potentially large project list is worth fetching at all.
 		integrations, intErr = api.ListIntegrations(ctx, org.ID)
-	}()
-	go func() {
-		defer innerWg.Done()
-		projects, projErr = api.FetchProjects(ctx, org.ID)
-	}()
-	innerWg.Wait()
+		integrations, intErr = withIntegrationsFallback(opts, org.ID, res.orgLabel, integrations, intErr)
+		if opts.skipOrgsWithoutIntegrations && intErr == nil && !hasSCMIntegration(integrations) {
+			res.skippedNoIntegrations = true
+			return res
+		}
+		if intErr == nil {
+			projects, projErr = api.FetchProjects(ctx, org.ID)
+		}
+	} else {
+		var innerWg sync.WaitGroup
+		innerWg.Add(2)
+		go func() {
+			defer innerWg.Done()
+			integrations, intErr = api.ListIntegrations(ctx, org.ID)
+		}()
+		go func() {
+			defer innerWg.Done()
+			projects, projErr = api.FetchProjects(ctx, org.ID)
+		}()
+		innerWg.Wait()
+		integrations, intErr = withIntegrationsFallback(opts, org.ID, res.orgLabel, integrations, intErr)
+	}
 	if intErr != nil {
-		res.err = fmt.Errorf("list integrations: %w", intErr)
-		return res
+		return failOrSkip(res, opts, fmt.Errorf("list integrations: %w", intErr))
 	}
 	if projErr != nil {
-		res.err = fmt.Errorf("fetch projects: %w", projErr)
-		return res
+		return failOrSkip(res, opts, fmt.Errorf("fetch projects: %w", projErr))
+	}
+	if opts.filterIntegrationActive {
+		var err error
+		integrations, res.inactiveIntegrations, err = dropInactiveIntegrations(ctx, api, org.ID, integrations)
+		if err != nil {
+			return failOrSkip(res, opts, fmt.Errorf("check integration status: %w", err))
+		}
 	}
 	for intType, intID := range integrations {
 		res.intMeta[intID] = intType
 	}
+	res.projects = len(projects)
 	if len(projects) == 0 {
 		return res
 	}
 
-	res.targets, res.gitlabCount = projectsToImportTargets(org, projects, integrations, integrationType)
+	res.targets, res.counts = projectsToImportTargets(org, projects, integrations, opts)
 	return res
 }
 
+// dropInactiveIntegrations returns integrations without the SCM integrations
+// the API reports as inactive, and the sorted types it dropped. Projects of a
+// dropped type are then skipped as having no integration.
+func dropInactiveIntegrations(ctx context.Context, api SnykAPI, orgID string, integrations map[string]string) (map[string]string, []string, error) {
+	kept := make(map[string]string, len(integrations))
+	var dropped []string
+	for intType, intID := range integrations {
+		if intID != "" && internal.IsSCMOrigin(intType) {
+			active, err := api.IntegrationActive(ctx, orgID, intID)
+			if err != nil {
+				return nil, nil, fmt.Errorf("integration %s: %w", intType, err)
+			}
+			if !active {
+				dropped = append(dropped, intType)
+				continue
+			}
+		}
+		kept[intType] = intID
+	}
+	sort.Strings(dropped)
+	return kept, dropped, nil
+}
+
+// failOrSkip records err on res or, when err is an API status listed in
+// --skip-status, marks the org as skipped instead.
+func failOrSkip(res refreshOrgResult, opts refreshOptions, err error) refreshOrgResult {
+	var se *internal.StatusError
+	if errors.As(err, &se) && opts.skipStatuses[se.StatusCode] {
+		res.skippedStatus = se.StatusCode
+		res.skipReason = err
+		return res
+	}
+	res.err = err
+	return res
+}
+
+// parseSkipStatuses parses --skip-status values into a set of HTTP status
+// codes. Only 4xx and 5xx codes are accepted.
+func parseSkipStatuses(values []string) (map[int]bool, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	set := make(map[int]bool, len(values))
+	for _, v := range values {
+		code, err := strconv.Atoi(v)
+		if err != nil || code < 400 || code > 599 {
+			return nil, fmt.Errorf("--skip-status: %q is not an HTTP error status (400-599)", v)
+		}
+		set[code] = true
+	}
+	return set, nil
+}
+
 // mergeRefreshResult merges a single org's result into the aggregate output and logs progress.
 func mergeRefreshResult(out *RefreshOutput, res refreshOrgResult) {
 	if res.err != nil {
 		return
 	}
-	if res.gitlabCount > 0 {
+	if res.skippedNoIntegrations {
+		log.Printf("Org %s: skipped (no SCM integrations)", res.orgLabel)
+	}
+	if len(res.inactiveIntegrations) > 0 {
+		log.Printf("WARNING: Org %s: integration(s) not active, their projects are skipped: %s",
+			res.orgLabel, strings.Join(res.inactiveIntegrations, ", "))
+	}
+	if res.counts.gitlab > 0 {
 		log.Printf("WARNING: Org %s: skipping %d GitLab project(s) -- Snyk API does not provide numeric GitLab project ID required for re-import",
-			res.orgLabel, res.gitlabCount)
+			res.orgLabel, res.counts.gitlab)
+	}
+	if res.counts.legacy > 0 || res.counts.notLegacy > 0 {
+		log.Printf("Org %s: %d project(s) created before --legacy-before; %d newer or undated skipped",
+			res.orgLabel, res.counts.legacy, res.counts.notLegacy)
+	}
+	if n := len(res.counts.unparseable); n > 0 {
+		log.Printf("WARNING: Org %s: dropped %d project(s) whose name could not be parsed as owner/repo (list them with --verbose or --report-unparseable)",
+			res.orgLabel, n)
 	}
 	if len(res.targets) > 0 {
 		log.Printf("Org %s: %d target(s)", res.orgLabel, len(res.targets))
-	} else if res.gitlabCount == 0 {
+	} else if res.counts.gitlab == 0 && !res.skippedNoIntegrations {
 		log.Printf("Org %s: no SCM projects found", res.orgLabel)
 	}
 	out.Targets = append(out.Targets, res.targets...)
@@ -152,32 +1169,296 @@ func mergeRefreshResult(out *RefreshOutput, res refreshOrgResult) {
 	}
 }
 
-// writeRefreshOutput marshals out to JSON and writes it to safePath.
+// writeRefreshOutput marshals out (a RefreshOutput or GroupedRefreshOutput) to JSON and writes it to safePath.
+// When compact is true the JSON is written on a single line instead of indented.
 // safePath must have been produced by sanitizeOutputPath to avoid path traversal.
 // Returns the sanitized path on success so the caller can print it.
-func writeRefreshOutput(out RefreshOutput, safePath string) (string, error) {
-	jsonData, err := json.MarshalIndent(out, "", "  ")
+func writeRefreshOutput(out interface{}, safePath string, compact bool) (string, error) {
+	jsonData, err := marshalRefreshOutput(out, compact)
 	if err != nil {
-		return "", fmt.Errorf("marshaling JSON: %w", err)
+		return "", err
 	}
-	if err := os.WriteFile(safePath, jsonData, 0600); err != nil {
+	if err := writeFileAtomic(safePath, jsonData); err != nil {
 		return "", fmt.Errorf("writing output file: %w", err)
 	}
 	return safePath, nil
 }
 
+// marshalRefreshOutput encodes out as indented JSON, or on a single line when
+// compact is true.
+func marshalRefreshOutput(out interface{}, compact bool) ([]byte, error) {
+	var jsonData []byte
+	var err error
+	if compact {
+		jsonData, err = json.Marshal(out)
+	} else {
+		jsonData, err = json.MarshalIndent(out, "", "  ")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("marshaling JSON: %w", err)
+	}
+	return jsonData, nil
+}
+
+// gzipOutputPath returns path with a ".gz" suffix for --gzip output, leaving
+// a path that already ends in ".gz" unchanged.
+func gzipOutputPath(path string) string {
+	if strings.HasSuffix(strings.ToLower(path), ".gz") {
+		return path
+	}
+	return path + ".gz"
+}
+
+// outputVars are the run metadata --output placeholders expand to.
+type outputVars struct {
+	group string    // {group}: --groupId
+	start time.Time // {date} and {time}: when the run started, in UTC
+	count int       // {count}: targets written
+}
+
+// outputPlaceholder matches one {name} placeholder in --output.
+var outputPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// expandOutputPath replaces the placeholders in an --output path: {group},
+// {date} (YYYY-MM-DD), {time} (HHMMSS), and {count}. Unknown placeholders,
+// {group} without a group ID, and values that would change the directory are
+// errors.
+func expandOutputPath(path string, v outputVars) (string, error) {
+	var expandErr error
+	expanded := outputPlaceholder.ReplaceAllStringFunc(path, func(ph string) string {
+		var val string
+		switch ph {
+		case "{group}":
+			val = v.group
+			if val == "" {
+				expandErr = errors.New("--output placeholder {group} requires --groupId")
+			}
+		case "{date}":
+			val = v.start.UTC().Format(time.DateOnly)
+		case "{time}":
+			val = v.start.UTC().Format("150405")
+		case "{count}":
+			val = strconv.Itoa(v.count)
+		default:
+			expandErr = fmt.Errorf("--output: unknown placeholder %s (want {group}, {date}, {time}, or {count})", ph)
+		}
+		if strings.ContainsAny(val, `/\`) || val == ".." {
+			expandErr = fmt.Errorf("--output placeholder %s expands to %q, which is not a valid file name part", ph, val)
+		}
+		return val
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+	return expanded, nil
+}
+
+// streamRecord is one line of --stream output: an org's targets, or the
+// error that org failed with.
+type streamRecord struct {
+	OrgID   string                  `json:"orgId"`
+	Targets []internal.ImportTarget `json:"targets"`
+	Error   string                  `json:"error,omitempty"`
+}
+
+// openStream opens the --stream destination: stdout for "-", otherwise the
+// file or fifo at path (opening a fifo blocks until a reader attaches).
+func openStream(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	safePath, err := sanitizeOutputPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(safePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+}
+
+// nopWriteCloser adds a no-op Close to a writer that must stay open.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// writeStreamRecord writes res as one JSON line of --stream output. Orgs
+// skipped by --skip-status are not written.
+func writeStreamRecord(w io.Writer, res refreshOrgResult) error {
+	rec := streamRecord{OrgID: res.orgID, Targets: res.targets}
+	switch {
+	case res.err != nil:
+		rec.Error = res.err.Error()
+	case res.skippedStatus != 0:
+		return nil
+	}
+	if rec.Targets == nil {
+		rec.Targets = []internal.ImportTarget{}
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// orgFailuresExceeded reports whether failed orgs are over --max-org-failures
+// (a negative limit means no limit).
+func orgFailuresExceeded(failed, limit int) bool {
+	return limit >= 0 && failed > limit
+}
+
+// writeFailedOrgs writes the failed org IDs, sorted, in the format read by
+// --org-file. The file is written even when there are no failures so a stale
+// list from an earlier run is not retried by mistake.
+func writeFailedOrgs(path string, orgIDs []string) error {
+	safePath, err := sanitizeOutputPath(path)
+	if err != nil {
+		return err
+	}
+	ids := append([]string(nil), orgIDs...)
+	sort.Strings(ids)
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %d org(s) failed during refresh; re-run with --org-file=%s\n", len(ids), path)
+	for _, id := range ids {
+		b.WriteString(id + "\n")
+	}
+	return writeFileAtomic(safePath, []byte(b.String()))
+}
+
+// writeFileAtomic writes data to a temp file in path's directory and renames it
+// over path, so readers only ever see the old file or the complete new one.
+// The file is created with 0600 permissions.
+func writeFileAtomic(path string, data []byte) error {
+	return writeFileAtomicWith(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeFileAtomicGzip is writeFileAtomic with data gzip-compressed on its way
+// to the temp file.
+func writeFileAtomicGzip(path string, data []byte) error {
+	return writeFileAtomicWith(path, func(w io.Writer) error {
+		zw := gzip.NewWriter(w)
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+}
+
+// writeFileAtomicWith is writeFileAtomic with the content produced by write.
+func writeFileAtomicWith(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op after a successful rename
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
+
 // runRefresh implements the refresh subcommand (default behavior).
 func runRefresh(args []string) {
 	fs := flag.NewFlagSet("refresh", flag.ExitOnError)
+	apiOpts := addAPIFlags(fs)
 	showVersion := fs.Bool("version", false, "Print version information and exit")
 	groupID := fs.String("groupId", "", "Snyk group ID (all orgs in this group will be scanned)")
+	orgName := fs.String("org-name", "", "Resolve a single org in --groupId by name or slug (case-insensitive)")
+	fastOrgFetch := fs.Bool("fast-org-fetch", false, "List group orgs with the REST API (cursor pagination), falling back to the v1 API on error")
 	orgID := fs.String("orgId", "", "Single Snyk org ID to scan (alternative to --groupId)")
-	integrationType := fs.String("integrationType", "", "Filter to a specific integration type (e.g. github-cloud-app)")
-	concurrency := fs.Int("concurrency", 5, "Number of orgs to process in parallel")
-	output := fs.String("output", "export-targets.json", "Output file path")
+	orgFile := fs.String("org-file", "", "With --groupId, only process the org IDs listed in this file (one per line, # comments allowed)")
+	var integrationTypes stringList
+	fs.Var(&integrationTypes, "integrationType", "Filter to integration type(s); repeatable or comma-separated (e.g. github-cloud-app,bitbucket-connect-app)")
+	var onlyOrigin, skipStatus stringList
+	var branches, excludeBranches stringList
+	fs.Var(&branches, "branch", "Keep only projects on this branch (e.g. main,master); repeatable or comma-separated. Projects without a branch are kept unless --require-branch")
+	fs.Var(&excludeBranches, "exclude-branch", "Drop projects on this branch; repeatable or comma-separated")
+	explicitBranch := fs.Bool("explicit-branch", false, "Always write the branch key on targets: the discovered branch (from branch or targetReference), or null when it is unknown, instead of omitting it")
+	requireBranch := fs.Bool("require-branch", false, "Drop projects whose branch is unknown (no branch or target reference)")
+	fs.Var(&skipStatus, "skip-status", "Skip (with a warning) orgs whose integrations or projects request fails with this HTTP status, e.g. 403, instead of failing them; repeatable or comma-separated")
+	fs.Var(&onlyOrigin, "only-origin", "Keep only projects with this origin (e.g. azure-repos), dropping the rest with a count; repeatable or comma-separated")
+	var rewriteOwner stringList
+	var excludeTargetIDs, excludeProjectIDs stringList
+	fs.Var(&excludeTargetIDs, "exclude-target-id", "Drop projects whose computed target ID (as printed by the diff command) matches; repeatable or comma-separated")
+	fs.Var(&excludeProjectIDs, "exclude-project-id", "Drop projects with this Snyk project ID before they become targets; repeatable or comma-separated")
+	legacyBefore := fs.String("legacy-before", "", "Only emit targets for projects created before this date (YYYY-MM-DD or RFC 3339), e.g. imports made under an old integration")
+	includeFile := fs.String("include-file", "", "Only emit targets listed in this file, one target ID (as printed by diff) or owner/repo per line (# comments allowed)")
+	projectNameTemplate := fs.String("project-name-template", "", "Parse project names with this regular expression instead of the built-in owner/repo(branch):manifest format; named groups owner (or projectKey) and repo (or repoSlug), and optionally branch")
+	fs.Var(&rewriteOwner, "rewrite-owner", "Remap a renamed repo owner as old=new (e.g. after a GitHub org rename); repeatable or comma-separated")
+	concurrency := fs.Int("concurrency", 5, "Maximum number of concurrent Snyk API calls (bounds orgs processed in parallel)")
+	maxConcurrency := fs.Int("max-concurrency", 0, "Enable adaptive concurrency: start at --concurrency, halve on 429s, grow when clean, never above this ceiling (0 disables)")
+	orgConcurrency := fs.Int("org-concurrency", 0, "Maximum number of orgs processed at once (0 = no separate limit; --concurrency still bounds API calls)")
+	heartbeat := fs.Duration("heartbeat", 0, "Log a one-line progress summary with an ETA at this interval, e.g. 1m (0 disables)")
+	shuffle := fs.Bool("shuffle-orgs", false, "Process orgs in random order so a cluster of large orgs does not hold every concurrency slot")
+	resumeFrom := fs.String("resume-from-org", "", "With --groupId, skip the orgs listed before this org ID and process it and the rest (to continue a run that died)")
+	parallelInner := fs.Bool("parallel-inner", true, "Fetch an org's integrations and projects concurrently (false fetches them one after the other)")
+	concurrencyRamp := fs.Duration("concurrency-ramp", 2*time.Second, "Stagger concurrency up to --concurrency over this duration to avoid initial 429 bursts (0 disables)")
+	output := fs.String("output", "export-targets.json", "Output file path; may contain {group}, {date}, {time}, and {count}, expanded when the file is written")
+	compact := fs.Bool("compact", false, "Write compact single-line JSON instead of pretty-printed")
+	originReport := fs.String("origin-report", "", "Also write a table of every distinct project origin seen, with project and org counts and the integration each maps to (- to print it with the summary)")
+	report := fs.String("report", "", "Also write a per-org table of targets by integration type to this file (- to print it with the summary)")
+	stream := fs.String("stream", "", "As each org finishes, write {orgId, targets} as a JSON line to this file or fifo (- for stdout; the summary then goes to stderr). Line order is not deterministic")
+	streamJSON := fs.Bool("stream-json", false, "Write each org's targets to the --output file as the org finishes instead of holding every target in memory until the end; the orgs and integrations maps follow the targets array")
+	gzipOut := fs.Bool("gzip", false, "Gzip-compress the output, adding .gz to the file name (for archival/transfer; decompress before snyk-api-import)")
+	outputSchema := fs.String("output-schema", outputSchemaV2, "Output schema: v2 (current, with metadata) or v1 (bare targets list)")
+	groupOutput := fs.Bool("group-output", false, "Nest targets under their org (alternate schema; NOT compatible with snyk-api-import)")
+	format := fs.String("format", "json", "Output format: json, or hcl for a Terraform locals block (experimental; not readable by snyk-api-import)")
+	pruneOutput := fs.Bool("prune-output", false, "Drop targets of orgs that failed and list those orgs in a partialOrgs field, so consumers can tell the file is incomplete")
+	maxOrgFailures := fs.Int("max-org-failures", -1, "Abort once more than this many orgs fail: cancel the remaining orgs and exit non-zero without writing output (-1 = no limit)")
+	orgRetries := fs.Int("org-retries", 0, "After the first pass, re-attempt orgs that failed up to this many more times before writing the output")
+	writePartialOnAbort := fs.Bool("write-partial-on-abort", false, "With --max-org-failures and --prune-output, still write the output when aborting, with every org not processed listed in partialOrgs")
+	flushEvery := fs.Int("flush-every", 0, "Rewrite the output file after every N orgs complete, so a crash late in a long run still leaves most targets on disk (0 = only at the end)")
+	countOnly := fs.Bool("count-only", false, "Run the full collection and conversion but only print the target counts; no output file is built or written")
+	splitByType := fs.Bool("split-by-integration-type", false, "Write one refresh-<type>.json per integration type into the --output directory (default: current directory)")
+	stableMaps := fs.Bool("stable-maps", false, "Write orgs and integrations as arrays sorted by ID and sort targets, so the file is byte-stable across runs")
+	explain := fs.Bool("explain", false, "Log, per emitted target, the project origin, mapped integration key, and resolved integration ID (and the key tried for projects skipped for lack of an integration)")
+	validate := fs.Bool("validate", false, "Drop (with a warning) targets whose integration ID is not a UUID, to catch unexpected API responses before they reach the import file")
+	collapseEquivalent := fs.Bool("collapse-equivalent-integrations", false, "When a repo was imported under equivalent integrations (bitbucket-cloud/bitbucket-connect-app, github/github-cloud-app), emit only the target of the canonical (app) integration")
+	withProvenance := fs.Bool("with-provenance", false, "Record on each target the projectId and projectName of the Snyk project it was derived from (for audits)")
+	countManifests := fs.Bool("count-manifests", false, "Annotate each target with projectCount, the number of projects collapsed into it (capacity planning for monorepos)")
+	emitFiles := fs.Bool("emit-files", false, "Collapse projects of the same repo+branch into one target with a files list of manifest paths")
+	skipImporting := fs.Bool("skip-importing", false, "Exclude projects that are still importing/pending so half-populated targets are not emitted")
+	skipBroken := fs.Bool("skip-broken", false, "Exclude projects whose status is inactive or broken (best-effort sign that the repo was deleted from the SCM), so re-importing them does not fail")
+	filterIntegrationActive := fs.Bool("filter-integration-active", false, "Check each SCM integration's status (one extra API call per integration) and skip projects of integrations that are disabled or gone")
+	skipOrgsNoInt := fs.Bool("skip-orgs-without-integrations", false, "List integrations first and skip fetching projects for orgs with no SCM integration")
+	projectsFile := fs.String("projects-file", "", "Read orgs, integrations, and projects from this JSON file (as written by --dump-projects) instead of the API; no token is needed")
+	dumpProjects := fs.String("dump-projects", "", "Also save the orgs, integrations, and projects fetched for each org to this JSON file, for replay with --projects-file")
+	integrationsFile := fs.String("integrations-file", "", "JSON map of org ID -> {integration type: integration ID} to use when listing an org's integrations fails")
+	statsFile := fs.String("concurrency-stats-file", "", "Write per-org timing, project count, retries, and failure state to this JSON file, for tuning concurrency")
+	mappingFile := fs.String("mapping-file", "", "Write a JSON map of project ID -> {orgId, integrationId, target} for every converted project")
+	reportUnparseable := fs.String("report-unparseable", "", "Write SCM projects dropped because their name could not be parsed to this JSON file")
+	verbose := fs.Bool("verbose", false, "Log extra detail, such as each project dropped because its name could not be parsed")
+	retryFailedFile := fs.String("retry-failed-file", "", "Write the IDs of orgs that failed to this file, for a follow-up run with --org-file")
+	notifyURL := fs.String("notify-url", "", "POST a JSON run summary to this URL when the run completes")
+	notifyOn := fs.String("notify-on", "always", "When to send --notify-url: success, failure, or always")
 	if err := fs.Parse(args); err != nil {
 		os.Exit(1)
 	}
+	if err := applyEnvDefaults(fs); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := configureAPI(apiOpts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer warnDeprecatedAPIVersion()
 
 	if *showVersion {
 		printVersion()
@@ -189,42 +1470,339 @@ func runRefresh(args []string) {
 		fs.Usage()
 		os.Exit(1)
 	}
-
-	token, err := internal.GetSnykToken()
+	if err := validateConcurrency("concurrency", *concurrency); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := validateMaxConcurrency(*maxConcurrency, *concurrency); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *heartbeat < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --heartbeat must be 0 (off) or positive, got %v\n", *heartbeat)
+		os.Exit(1)
+	}
+	if *orgConcurrency < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --org-concurrency must be 0 (no limit) or positive, got %d\n", *orgConcurrency)
+		os.Exit(1)
+	}
+	if _, err := toOutputSchema(RefreshOutput{}, *outputSchema); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *groupOutput && *outputSchema != outputSchemaV2 {
+		fmt.Fprintf(os.Stderr, "Error: --group-output requires --output-schema=v2\n")
+		os.Exit(1)
+	}
+	switch *format {
+	case "json":
+	case "hcl":
+		if *groupOutput || *stableMaps || *compact || *outputSchema != outputSchemaV2 {
+			fmt.Fprintf(os.Stderr, "Error: --format=hcl cannot be combined with --group-output, --stable-maps, --compact, or --output-schema=v1\n")
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: --format must be json or hcl, got %q\n", *format)
+		os.Exit(1)
+	}
+	if *stableMaps && (*groupOutput || *outputSchema != outputSchemaV2) {
+		fmt.Fprintf(os.Stderr, "Error: --stable-maps requires --output-schema=v2 and cannot be combined with --group-output\n")
+		os.Exit(1)
+	}
+	if *pruneOutput && (*outputSchema != outputSchemaV2 || *format != "json") {
+		fmt.Fprintf(os.Stderr, "Error: --prune-output requires --output-schema=v2 and --format=json\n")
+		os.Exit(1)
+	}
+	if *maxOrgFailures < -1 {
+		fmt.Fprintf(os.Stderr, "Error: --max-org-failures must be 0 or more, or -1 for no limit\n")
+		os.Exit(1)
+	}
+	if *orgRetries < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --org-retries must be 0 or more\n")
+		os.Exit(1)
+	}
+	if *writePartialOnAbort && (*maxOrgFailures < 0 || !*pruneOutput) {
+		fmt.Fprintf(os.Stderr, "Error: --write-partial-on-abort requires --max-org-failures and --prune-output\n")
+		os.Exit(1)
+	}
+	if *splitByType && (*groupOutput || *format != "json") {
+		fmt.Fprintf(os.Stderr, "Error: --split-by-integration-type cannot be combined with --group-output or --format=hcl\n")
+		os.Exit(1)
+	}
+	if *countOnly && (*splitByType || *gzipOut || *stream != "" || *writePartialOnAbort) {
+		fmt.Fprintf(os.Stderr, "Error: --count-only writes no output and cannot be combined with --split-by-integration-type, --gzip, --stream, or --write-partial-on-abort\n")
+		os.Exit(1)
+	}
+	if *splitByType {
+		outputSet := false
+		fs.Visit(func(f *flag.Flag) { outputSet = outputSet || f.Name == "output" })
+		if !outputSet {
+			*output = "."
+		}
+	}
+	// Expand once now so a bad placeholder fails before any API call; the
+	// real expansion happens at write time, when {count} is known.
+	outputStart := time.Now()
+	if _, err := expandOutputPath(*output, outputVars{group: *groupID, start: outputStart}); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *flushEvery < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --flush-every must be 0 or more\n")
+		os.Exit(1)
+	}
+	if *flushEvery > 0 && (*splitByType || *countOnly || strings.Contains(*output, "{count}")) {
+		fmt.Fprintf(os.Stderr, "Error: --flush-every needs a single output file: it cannot be combined with --split-by-integration-type, --count-only, or {count} in --output\n")
+		os.Exit(1)
+	}
+	if *flushEvery > 0 && *maxOrgFailures >= 0 && !*writePartialOnAbort {
+		fmt.Fprintf(os.Stderr, "Error: --flush-every with --max-org-failures requires --write-partial-on-abort, since an aborted run would leave a flushed file behind\n")
+		os.Exit(1)
+	}
+	if *streamJSON && (*splitByType || *countOnly || *gzipOut || *groupOutput || *stableMaps || *format != "json" || *outputSchema != outputSchemaV2 || *flushEvery > 0 || strings.Contains(*output, "{count}")) {
+		fmt.Fprintf(os.Stderr, "Error: --stream-json writes a single v2 JSON file as it goes: it cannot be combined with --split-by-integration-type, --count-only, --gzip, --group-output, --stable-maps, --format=hcl, --output-schema=v1, --flush-every, or {count} in --output\n")
+		os.Exit(1)
+	}
+	if !*splitByType {
+		if *gzipOut {
+			*output = gzipOutputPath(*output)
+		} else if strings.HasSuffix(strings.ToLower(*output), ".gz") {
+			fmt.Fprintf(os.Stderr, "Error: --output %s ends in .gz; pass --gzip to write compressed output\n", *output)
+			os.Exit(1)
+		}
+	}
+	if *resumeFrom != "" && (*groupID == "" || *orgName != "" || *shuffle) {
+		fmt.Fprintf(os.Stderr, "Error: --resume-from-org requires --groupId and cannot be combined with --org-name or --shuffle-orgs\n")
+		os.Exit(1)
+	}
+	if *orgFile != "" && (*groupID == "" || *orgName != "") {
+		fmt.Fprintf(os.Stderr, "Error: --org-file requires --groupId and cannot be combined with --org-name\n")
+		os.Exit(1)
+	}
+	var orgFileIDs []string
+	if *orgFile != "" {
+		ids, err := readOrgFile(*orgFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		orgFileIDs = ids
+	}
+	var legacyCutoff time.Time
+	if *legacyBefore != "" {
+		t, err := parseLegacyBefore(*legacyBefore)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		legacyCutoff = t
+	}
+	var include *targetAllowlist
+	if *includeFile != "" {
+		a, err := readIncludeFile(*includeFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		include = a
+	}
+	if *projectsFile != "" && *dumpProjects != "" {
+		fmt.Fprintf(os.Stderr, "Error: --projects-file and --dump-projects cannot be combined\n")
+		os.Exit(1)
+	}
+	var savedProjects *projectsDump
+	if *projectsFile != "" {
+		f, err := loadProjectsFile(*projectsFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		savedProjects = f
+	}
+	var integrationsFallback map[string]map[string]string
+	if *integrationsFile != "" {
+		byOrg, err := loadIntegrationsFile(*integrationsFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		integrationsFallback = byOrg
+	}
+	if err := internal.SetProjectNameTemplate(*projectNameTemplate); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --project-name-template: %v\n", err)
+		os.Exit(1)
+	}
+	ownerRewrites, err := parseOwnerRewrites(rewriteOwner)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	skipStatuses, err := parseSkipStatuses(skipStatus)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	notify, err := newNotifier(*notifyURL, *notifyOn)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
 	ctx := context.Background()
-	api := newSnykAPI(internal.NewHTTPClient(), token)
+	summary := runSummary{Command: "refresh"}
+	var jsonStream *jsonStreamWriter
+	// fail reports a fatal error, sends a failure notification, and exits.
+	// A partly written --stream-json document is discarded. os.Exit skips
+	// deferred calls, so the API deprecation warning is printed here.
+	fail := func(prefix string, err error) {
+		jsonStream.abort()
+		fmt.Fprintf(os.Stderr, "%s: %v\n", prefix, err)
+		summary.Error = err.Error()
+		notify.notify(ctx, summary)
+		warnDeprecatedAPIVersion()
+		os.Exit(1)
+	}
 
-	orgs, err := resolveOrgs(ctx, api, *groupID, *orgID)
+	// A --projects-file replay serves every call from the file, so it needs
+	// no token.
+	token, err := internal.GetSnykToken()
+	if err != nil && savedProjects == nil {
+		fail("Error", err)
+	}
+
+	lim := newRunLimiter(*concurrency, *maxConcurrency)
+	lim.ramp(ctx, *concurrencyRamp)
+	lim.adapt(ctx, adaptInterval)
+	api := withLimiter(newSnykAPI(internal.NewHTTPClient(), token, *fastOrgFetch), lim, nil)
+	var recorder *projectRecorder
+	if savedProjects != nil {
+		api = &savedProjectsAPI{SnykAPI: api, file: savedProjects}
+	} else if *dumpProjects != "" {
+		recorder = newProjectRecorder(api)
+		api = recorder
+	}
+
+	orgs, err := resolveOrgs(ctx, api, *groupID, *orgID, *orgName)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error fetching orgs: %v\n", err)
-		os.Exit(1)
+		fail("Error fetching orgs", err)
+	}
+	if orgFileIDs != nil {
+		var missing []string
+		orgs, missing = filterOrgsByID(orgs, orgFileIDs)
+		for _, id := range missing {
+			log.Printf("WARNING: --org-file: org %s is not in group %s; skipping", id, *groupID)
+		}
+	}
+	if *resumeFrom != "" {
+		total := len(orgs)
+		if orgs, err = resumeFromOrg(orgs, *resumeFrom); err != nil {
+			fail("Error: --resume-from-org", err)
+		}
+		log.Printf("Resuming from org %s: skipping %d of %d org(s) listed before it", *resumeFrom, total-len(orgs), total)
+	}
+	if *shuffle {
+		orgs = shuffleOrgs(orgs, rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())))
 	}
 
-	log.Printf("Processing %d organization(s) with concurrency %d...", len(orgs), *concurrency)
+	opts := refreshOptions{
+		integrationTypes:            integrationTypes.set(),
+		onlyOrigins:                 originSet(onlyOrigin),
+		branches:                    branches.set(),
+		excludeBranches:             excludeBranches.set(),
+		requireBranch:               *requireBranch,
+		explicitBranch:              *explicitBranch,
+		skipStatuses:                skipStatuses,
+		emitFiles:                   *emitFiles,
+		countManifests:              *countManifests,
+		explain:                     *explain,
+		withProvenance:              *withProvenance,
+		verbose:                     *verbose,
+		recordMapping:               *mappingFile != "",
+		originReport:                *originReport != "",
+		collapseEquivalent:          *collapseEquivalent,
+		validate:                    *validate,
+		skipImporting:               *skipImporting,
+		skipBroken:                  *skipBroken,
+		filterIntegrationActive:     *filterIntegrationActive,
+		skipOrgsWithoutIntegrations: *skipOrgsNoInt,
+		sequentialInner:             !*parallelInner,
+		ownerRewrites:               ownerRewrites,
+		excludeProjectIDs:           excludeProjectIDs.set(),
+		excludeTargetIDs:            excludeTargetIDs.set(),
+		include:                     include,
+		legacyBefore:                legacyCutoff,
+		integrationsFallback:        integrationsFallback,
+	}
 
-	results := make(chan refreshOrgResult, len(orgs))
-	sem := make(chan struct{}, *concurrency)
-	var wg sync.WaitGroup
+	log.Printf("Processing %d organization(s) with concurrency %d...", len(orgs), *concurrency)
+	var orgLim *limiter
+	if *orgConcurrency > 0 {
+		orgLim = newLimiter(*orgConcurrency)
+		log.Printf("At most %d org(s) in flight at once (--org-concurrency)", *orgConcurrency)
+	}
 
-	for _, org := range orgs {
-		wg.Add(1)
-		go func(o internal.Org) {
-			defer wg.Done()
-			sem <- struct{}{}        // acquire
-			defer func() { <-sem }() // release
-			results <- processOrgForRefresh(ctx, api, o, *integrationType)
-		}(org)
+	var streamOut io.WriteCloser
+	if *stream != "" {
+		if streamOut, err = openStream(*stream); err != nil {
+			fail("Error opening --stream", err)
+		}
+	}
+	if *streamJSON {
+		path, err := expandOutputPath(*output, outputVars{group: *groupID, start: outputStart})
+		if err == nil {
+			path, err = sanitizeOutputPath(path)
+		}
+		if err == nil {
+			jsonStream, err = newJSONStreamWriter(path, *compact)
+		}
+		if err != nil {
+			fail("Error opening --output for --stream-json", err)
+		}
 	}
 
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
+	runStart := time.Now()
+	// orgCtx is cancelled when --max-org-failures is exceeded; ctx stays live
+	// for the failure notification.
+	orgCtx, cancelOrgs := context.WithCancel(ctx)
+	defer cancelOrgs()
+	prog := newProgress(len(orgs))
+	stopWatch := watchProgressSignal(prog)
+	defer stopWatch()
+	stopHeartbeat := startHeartbeat(prog, *heartbeat)
+	defer stopHeartbeat()
+
+	// runPass processes batch concurrently; the returned channel is closed
+	// once every org in it has a result.
+	runPass := func(batch []internal.Org) <-chan refreshOrgResult {
+		results := make(chan refreshOrgResult, len(batch))
+		var wg sync.WaitGroup
+		for _, org := range batch {
+			wg.Add(1)
+			go func(o internal.Org) {
+				defer wg.Done()
+				if orgLim != nil {
+					if err := orgLim.acquire(orgCtx); err != nil {
+						results <- refreshOrgResult{orgID: o.ID, orgLabel: orgLabel(o), err: err}
+						return
+					}
+					defer orgLim.release()
+				}
+				prog.start(orgLabel(o))
+				res := processOrgForRefresh(orgCtx, api, o, opts)
+				prog.finish(res.orgLabel, res.err)
+				results <- res
+			}(org)
+		}
+		go func() {
+			wg.Wait()
+			close(results)
+		}()
+		return results
+	}
+	orgByID := make(map[string]internal.Org, len(orgs))
+	for _, o := range orgs {
+		orgByID[o.ID] = o
+	}
 
 	out := RefreshOutput{
 		Orgs:         make(map[string]OrgMeta),
@@ -236,37 +1814,400 @@ func runRefresh(args []string) {
 
 	failedOrgs := 0
 	processedOrgs := 0
+	skippedStatusOrgs := 0
+	inactiveIntegrations := 0
+	var totals refreshCounts
+	var failedOrgIDs []string
+	aborted := false
 
-	for res := range results {
+	var perOrg []orgStats
+	var reportRows []orgReportRow
+	writeFile := writeFileAtomic
+	if *gzipOut {
+		writeFile = writeFileAtomicGzip
+	}
+	writeOutput := func(o RefreshOutput, path string) (string, error) {
+		if *format == "hcl" {
+			if err := writeFile(path, renderHCL(o)); err != nil {
+				return "", fmt.Errorf("writing output file: %w", err)
+			}
+			return path, nil
+		}
+		payload, err := toOutputSchema(o, *outputSchema)
+		if err != nil {
+			return "", err
+		}
+		if *groupOutput {
+			payload = groupOutputByOrg(o)
+		}
+		if *stableMaps {
+			payload = toStableOutput(o)
+		}
+		data, err := marshalRefreshOutput(payload, *compact)
+		if err != nil {
+			return "", err
+		}
+		if err := writeFile(path, data); err != nil {
+			return "", fmt.Errorf("writing output file: %w", err)
+		}
+		return path, nil
+	}
+	// flushPartial rewrites the output with the orgs handled so far
+	// (--flush-every).
+	handled := make(map[string]bool, len(orgs))
+	flushPartial := func() {
+		expanded, err := expandOutputPath(*output, outputVars{group: *groupID, start: outputStart})
+		if err == nil {
+			expanded, err = sanitizeOutputPath(expanded)
+		}
+		if err == nil {
+			expanded, err = writeOutput(partialSnapshot(out, orgs, handled, failedOrgIDs, *pruneOutput), expanded)
+		}
+		if err != nil {
+			log.Printf("WARNING: Failed to flush the output (--flush-every): %v", err)
+			return
+		}
+		log.Printf("Flushed %d target(s) from %d of %d org(s) to %s", len(out.Targets), len(handled), len(orgs), expanded)
+	}
+	// handleOrgResult records an org's final result: stats, report row,
+	// stream record, and either its failure or its targets.
+	handleOrgResult := func(res refreshOrgResult) {
+		handled[res.orgID] = true
+		if *flushEvery > 0 && len(handled)%*flushEvery == 0 && len(handled) < len(orgs) {
+			defer flushPartial()
+		}
+		if *statsFile != "" {
+			perOrg = append(perOrg, newOrgStats(res))
+		}
+		if *report != "" {
+			reportRows = append(reportRows, newOrgReportRow(res))
+		}
+		if streamOut != nil {
+			if err := writeStreamRecord(streamOut, res); err != nil {
+				fail("Error writing --stream", err)
+			}
+		}
 		if res.err != nil {
 			failedOrgs++
+			failedOrgIDs = append(failedOrgIDs, res.orgID)
+			if aborted && errors.Is(res.err, context.Canceled) {
+				return
+			}
 			log.Printf("WARNING: Failed to process org %s: %v", res.orgLabel, res.err)
-			continue
+			if !aborted && orgFailuresExceeded(failedOrgs, *maxOrgFailures) {
+				aborted = true
+				log.Printf("ERROR: %d org(s) failed, more than --max-org-failures=%d; cancelling the remaining orgs", failedOrgs, *maxOrgFailures)
+				cancelOrgs()
+			}
+			return
+		}
+		if res.skippedStatus != 0 {
+			skippedStatusOrgs++
+			log.Printf("WARNING: Skipping org %s: status %d is listed in --skip-status (%v)", res.orgLabel, res.skippedStatus, res.skipReason)
+			return
 		}
 		processedOrgs++
+		inactiveIntegrations += len(res.inactiveIntegrations)
+		totals.add(res.counts)
 		mergeRefreshResult(&out, res)
+		if jsonStream != nil {
+			// The targets are on disk now; drop them so memory stays bounded.
+			if err := jsonStream.writeTargets(out.Targets); err != nil {
+				fail("Error writing --stream-json", err)
+			}
+			out.Targets = nil
+		}
+	}
+	// retried holds the labels of orgs that needed an --org-retries pass;
+	// recovered counts those that then succeeded.
+	retried := make(map[string]string)
+	recovered := 0
+	batch := orgs
+	for pass := 0; len(batch) > 0; pass++ {
+		var retry []internal.Org
+		for res := range runPass(batch) {
+			if res.err != nil && pass < *orgRetries && !aborted && !errors.Is(res.err, context.Canceled) {
+				log.Printf("WARNING: Failed to process org %s: %v (will retry)", res.orgLabel, res.err)
+				retry = append(retry, orgByID[res.orgID])
+				retried[res.orgID] = res.orgLabel
+				continue
+			}
+			if _, ok := retried[res.orgID]; ok && res.err == nil {
+				recovered++
+				log.Printf("Org %s: succeeded on retry", res.orgLabel)
+			}
+			handleOrgResult(res)
+		}
+		if len(retry) > 0 && !aborted {
+			log.Printf("Retrying %d failed org(s) in %v (attempt %d of %d, --org-retries)...", len(retry), orgRetryPause, pass+2, *orgRetries+1)
+			select {
+			case <-time.After(orgRetryPause):
+			case <-orgCtx.Done():
+			}
+			prog.requeue(len(retry))
+		}
+		batch = retry
+	}
+	if streamOut != nil {
+		// Closing signals end of stream to a fifo reader before the output
+		// file is written.
+		if err := streamOut.Close(); err != nil {
+			fail("Error closing --stream", err)
+		}
 	}
 
-	if len(out.Targets) == 0 {
+	if *pruneOutput {
+		pruneFailedOrgs(&out, failedOrgIDs)
+	}
+	targetCount := len(out.Targets)
+	if jsonStream != nil {
+		targetCount = jsonStream.count
+	}
+	if aborted {
+		summary.Error = fmt.Sprintf("aborted: %d org(s) failed or were cancelled after exceeding --max-org-failures=%d", failedOrgs, *maxOrgFailures)
+	}
+	if targetCount == 0 {
 		log.Println("No targets found to refresh.")
 	}
+	log.Printf("API retries during run: %d", internal.RetryCount())
+	lim.logSaturation()
 
-	safePath, err := sanitizeOutputPath(*output)
+	if *statsFile != "" {
+		stats := concurrencyStats{
+			Concurrency:      *concurrency,
+			MaxConcurrency:   *maxConcurrency,
+			FinalConcurrency: lim.currentLimit(),
+			ElapsedMs:        time.Since(runStart).Milliseconds(),
+			Retries:          internal.RetryCount(),
+			Orgs:             perOrg,
+		}
+		if err := writeConcurrencyStats(*statsFile, stats); err != nil {
+			log.Printf("WARNING: Failed to write --concurrency-stats-file: %v", err)
+		} else {
+			log.Printf("Wrote stats for %d org(s) to %s", len(perOrg), *statsFile)
+		}
+	}
+	if *mappingFile != "" {
+		if err := writeMappingFile(*mappingFile, totals.mapping); err != nil {
+			log.Printf("WARNING: Failed to write --mapping-file: %v", err)
+		} else {
+			log.Printf("Wrote the target for %d project(s) to %s", len(totals.mapping), *mappingFile)
+		}
+	}
+	var reportTable []byte
+	if *report != "" {
+		if reportTable, err = renderOrgReport(reportRows); err != nil {
+			log.Printf("WARNING: Failed to render --report: %v", err)
+		} else if *report != "-" {
+			if err := writeReport(*report, reportTable); err != nil {
+				log.Printf("WARNING: Failed to write --report: %v", err)
+			} else {
+				log.Printf("Wrote the per-org report to %s", *report)
+			}
+		}
+	}
+	var originTable []byte
+	if *originReport != "" {
+		if originTable, err = renderOriginReport(totals.origins, totals.originOrgs); err != nil {
+			log.Printf("WARNING: Failed to render --origin-report: %v", err)
+		} else if *originReport != "-" {
+			if err := writeReport(*originReport, originTable); err != nil {
+				log.Printf("WARNING: Failed to write --origin-report: %v", err)
+			} else {
+				log.Printf("Wrote %d distinct origin(s) to %s", len(totals.origins), *originReport)
+			}
+		}
+	}
+	if recorder != nil {
+		if n, err := recorder.write(*dumpProjects); err != nil {
+			log.Printf("WARNING: Failed to write --dump-projects: %v", err)
+		} else {
+			log.Printf("Wrote the API responses of %d org(s) to %s", n, *dumpProjects)
+		}
+	}
+	if *reportUnparseable != "" {
+		if err := writeUnparseable(*reportUnparseable, totals.unparseable); err != nil {
+			log.Printf("WARNING: Failed to write --report-unparseable: %v", err)
+		} else {
+			log.Printf("Wrote %d unparseable project(s) to %s", len(totals.unparseable), *reportUnparseable)
+		}
+	}
+	if *retryFailedFile != "" {
+		if err := writeFailedOrgs(*retryFailedFile, failedOrgIDs); err != nil {
+			log.Printf("WARNING: Failed to write --retry-failed-file: %v", err)
+		} else if len(failedOrgIDs) > 0 {
+			log.Printf("Wrote %d failed org ID(s) to %s; re-run them with --org-file=%s", len(failedOrgIDs), *retryFailedFile, *retryFailedFile)
+		}
+	}
+
+	summary.Targets = targetCount
+	summary.OrgsProcessed = processedOrgs
+	summary.OrgsFailed = failedOrgs
+	if aborted && !*writePartialOnAbort {
+		fail("Error", fmt.Errorf("%s; no output was written", summary.Error))
+	}
+
+	// With --stream=- stdout carries JSON lines, so keep the summary off it.
+	var summaryOut io.Writer = os.Stdout
+	if *stream == "-" {
+		summaryOut = os.Stderr
+	}
+	fmt.Fprintf(summaryOut, "\nTotal: %d target(s) across %d org(s)", targetCount, processedOrgs)
+	if failedOrgs > 0 {
+		fmt.Fprintf(summaryOut, " (%d org(s) failed)", failedOrgs)
+	}
+	if skippedStatusOrgs > 0 {
+		fmt.Fprintf(summaryOut, " (%d org(s) skipped by --skip-status)", skippedStatusOrgs)
+	}
+	if len(retried) > 0 {
+		fmt.Fprintf(summaryOut, "\nRetried %d org(s) (--org-retries), %d recovered: %s",
+			len(retried), recovered, strings.Join(retriedOrgLabels(retried), ", "))
+	}
+	if len(totals.byOrigin) > 0 {
+		fmt.Fprintf(summaryOut, "\nBy integration: %s", formatOriginCounts(totals.byOrigin))
+	}
+	if totals.gitlab > 0 || totals.nonSCM > 0 || totals.noIntegration > 0 || totals.importing > 0 || totals.broken > 0 || totals.excluded > 0 || totals.otherOrigin > 0 || len(totals.unparseable) > 0 || totals.collapsed > 0 || totals.branch > 0 || totals.invalidID > 0 || totals.notIncluded > 0 || totals.notLegacy > 0 {
+		fmt.Fprintf(summaryOut, "\nSkipped projects: gitlab: %d, non-SCM: %d, no integration: %d",
+			totals.gitlab, totals.nonSCM, totals.noIntegration)
+		if totals.importing > 0 {
+			fmt.Fprintf(summaryOut, ", still importing: %d", totals.importing)
+		}
+		if totals.broken > 0 {
+			fmt.Fprintf(summaryOut, ", inactive or broken (--skip-broken): %d", totals.broken)
+		}
+		if totals.excluded > 0 {
+			fmt.Fprintf(summaryOut, ", excluded: %d", totals.excluded)
+		}
+		if len(totals.unparseable) > 0 {
+			fmt.Fprintf(summaryOut, ", unparseable name: %d", len(totals.unparseable))
+		}
+		if totals.otherOrigin > 0 {
+			fmt.Fprintf(summaryOut, ", other origin (--only-origin): %d", totals.otherOrigin)
+		}
+		if totals.branch > 0 {
+			fmt.Fprintf(summaryOut, ", branch filter: %d", totals.branch)
+		}
+		if totals.notIncluded > 0 {
+			fmt.Fprintf(summaryOut, ", not in --include-file: %d", totals.notIncluded)
+		}
+		if totals.notLegacy > 0 {
+			fmt.Fprintf(summaryOut, ", created on/after --legacy-before or undated: %d", totals.notLegacy)
+		}
+		if totals.invalidID > 0 {
+			fmt.Fprintf(summaryOut, ", malformed integration ID (--validate, targets): %d", totals.invalidID)
+		}
+		if totals.collapsed > 0 {
+			fmt.Fprintf(summaryOut, ", equivalent integration (collapsed targets): %d", totals.collapsed)
+		}
+	}
+	if inactiveIntegrations > 0 {
+		fmt.Fprintf(summaryOut, "\nInactive integrations skipped (--filter-integration-active): %d", inactiveIntegrations)
+	}
+	if totals.ownerRewrites > 0 {
+		fmt.Fprintf(summaryOut, "\nOwners rewritten: %d target(s)", totals.ownerRewrites)
+	}
+	if totals.legacy > 0 {
+		fmt.Fprintf(summaryOut, "\nCreated before --legacy-before: %d project(s)", totals.legacy)
+	}
+	if *report == "-" && reportTable != nil {
+		fmt.Fprintf(summaryOut, "\n\n%s", reportTable)
+	}
+	if *originReport == "-" && originTable != nil {
+		fmt.Fprintf(summaryOut, "\n\n%s", originTable)
+	}
+	if *countOnly {
+		summary.Success = failedOrgs == 0
+		notify.notify(ctx, summary)
+		fmt.Fprintln(summaryOut, "\nNo output written (--count-only).")
+		return
+	}
+
+	expandedOutput, err := expandOutputPath(*output, outputVars{group: *groupID, start: outputStart, count: targetCount})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fail("Error", err)
 	}
-	sanitizedOutput, err := writeRefreshOutput(out, safePath)
+	safePath, err := sanitizeOutputPath(expandedOutput)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fail("Error", err)
 	}
+	sanitizedOutput := safePath
+	var splitFiles []string
+	if *splitByType {
+		if err := os.MkdirAll(safePath, 0o755); err != nil {
+			fail("Error", fmt.Errorf("create output directory: %w", err))
+		}
+		parts := splitByIntegrationType(out)
+		types := make([]string, 0, len(parts))
+		for typ := range parts {
+			types = append(types, typ)
+		}
+		sort.Strings(types)
+		for _, typ := range types {
+			name := splitFileName(typ)
+			if *gzipOut {
+				name = gzipOutputPath(name)
+			}
+			path, err := writeOutput(parts[typ], filepath.Join(safePath, name))
+			if err != nil {
+				fail("Error", err)
+			}
+			log.Printf("Wrote %d %s target(s) to %s", len(parts[typ].Targets), typ, path)
+			splitFiles = append(splitFiles, path)
+		}
+	} else if jsonStream != nil {
+		if err := jsonStream.finish(out); err != nil {
+			fail("Error", err)
+		}
+		sanitizedOutput = jsonStream.path
+	} else {
+		sanitizedOutput, err = writeOutput(out, safePath)
+		if err != nil {
+			fail("Error", err)
+		}
+	}
+	summary.Output = sanitizedOutput
+	summary.Success = failedOrgs == 0
+	notify.notify(ctx, summary)
 
-	fmt.Printf("\nTotal: %d target(s) across %d org(s)", len(out.Targets), processedOrgs)
-	if failedOrgs > 0 {
-		fmt.Printf(" (%d org(s) failed)", failedOrgs)
+	if len(out.PartialOrgs) > 0 {
+		fmt.Fprintf(summaryOut, "\nMarked %d failed org(s) in partialOrgs", len(out.PartialOrgs))
+	}
+	if aborted {
+		// Skip the import hint: the file is knowingly incomplete.
+		fmt.Fprintf(summaryOut, "\nPartial output written to: %s\n", sanitizedOutput)
+		fmt.Fprintf(os.Stderr, "Error: %s\n", summary.Error)
+		warnDeprecatedAPIVersion()
+		os.Exit(1)
+	}
+	if *splitByType {
+		fmt.Fprintf(summaryOut, "\nOutput written to: %s (%d file(s), one per integration type)\n", sanitizedOutput, len(splitFiles))
+		if len(splitFiles) == 0 {
+			return
+		}
+		if *gzipOut {
+			fmt.Fprintln(summaryOut, "\nNote: --gzip output must be decompressed (e.g. gunzip -k <file>) before snyk-api-import can read it.")
+			return
+		}
+		fmt.Fprintln(summaryOut, "\nTo import, run each file separately, e.g.:")
+		for _, f := range splitFiles {
+			fmt.Fprintf(summaryOut, "  snyk-api-import import --file=%s\n", f)
+		}
+		return
+	}
+	fmt.Fprintf(summaryOut, "\nOutput written to: %s\n", sanitizedOutput)
+	if *gzipOut {
+		fmt.Fprintln(summaryOut, "\nNote: --gzip output must be decompressed before snyk-api-import can read it:")
+		fmt.Fprintf(summaryOut, "  gunzip -k %s\n", sanitizedOutput)
+		return
+	}
+	if *groupOutput {
+		fmt.Fprintln(summaryOut, "\nNote: --group-output uses a per-org schema that snyk-api-import cannot read.")
+		return
+	}
+	if *format == "hcl" {
+		fmt.Fprintln(summaryOut, "\nNote: --format=hcl is experimental and cannot be read by snyk-api-import.")
+		return
 	}
-	fmt.Printf("\nOutput written to: %s\n", sanitizedOutput)
-	fmt.Println("\nTo import, run:")
-	fmt.Printf("  snyk-api-import import --file=%s\n", sanitizedOutput)
+	fmt.Fprintln(summaryOut, "\nTo import, run:")
+	fmt.Fprintf(summaryOut, "  snyk-api-import import --file=%s\n", sanitizedOutput)
 }
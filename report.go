@@ -0,0 +1,137 @@
+// report.go implements refresh --report, a per-org table of targets by
+// integration type, and --origin-report, a table of every project origin
+// seen. Both are written alongside the JSON output for people to read.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/snyk-playground/snyk-target-export/internal"
+)
+
+// orgReportRow is one org's line in the --report table.
+type orgReportRow struct {
+	label    string
+	status   string         // ok, failed, or skipped (with the reason)
+	projects int            // projects fetched
+	byType   map[string]int // emitted targets per integration type
+}
+
+// newOrgReportRow builds the --report row for one org's result.
+func newOrgReportRow(res refreshOrgResult) orgReportRow {
+	row := orgReportRow{label: res.orgLabel, status: "ok", projects: res.projects, byType: make(map[string]int)}
+	switch {
+	case res.err != nil:
+		row.status = "failed"
+	case res.skippedStatus != 0:
+		row.status = fmt.Sprintf("skipped (%d)", res.skippedStatus)
+	case res.skippedNoIntegrations:
+		row.status = "skipped (no SCM integrations)"
+	}
+	for _, t := range res.targets {
+		typ := res.intMeta[t.IntegrationID]
+		if typ == "" {
+			typ = "unknown"
+		}
+		row.byType[typ]++
+	}
+	return row
+}
+
+// writeReport writes a rendered --report table to path.
+func writeReport(path string, table []byte) error {
+	safePath, err := sanitizeOutputPath(path)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(safePath, table)
+}
+
+// renderOrgReport formats rows as an aligned table sorted by org, with one
+// column per integration type seen and a TOTAL line.
+func renderOrgReport(rows []orgReportRow) ([]byte, error) {
+	sorted := append([]orgReportRow(nil), rows...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].label < sorted[j].label })
+	typeSet := make(map[string]bool)
+	for _, r := range sorted {
+		for typ := range r.byType {
+			typeSet[typ] = true
+		}
+	}
+	types := make([]string, 0, len(typeSet))
+	for typ := range typeSet {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	line := func(cells ...string) {
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	line(append([]string{"ORG", "STATUS", "PROJECTS", "TARGETS"}, types...)...)
+	total := orgReportRow{label: "TOTAL", byType: make(map[string]int)}
+	for _, r := range sorted {
+		targets := 0
+		cells := []string{r.label, r.status, fmt.Sprint(r.projects), ""}
+		for _, typ := range types {
+			targets += r.byType[typ]
+			total.byType[typ] += r.byType[typ]
+			cells = append(cells, fmt.Sprint(r.byType[typ]))
+		}
+		cells[3] = fmt.Sprint(targets)
+		total.projects += r.projects
+		line(cells...)
+	}
+	totalTargets := 0
+	cells := []string{total.label, fmt.Sprintf("%d org(s)", len(sorted)), fmt.Sprint(total.projects), ""}
+	for _, typ := range types {
+		totalTargets += total.byType[typ]
+		cells = append(cells, fmt.Sprint(total.byType[typ]))
+	}
+	cells[3] = fmt.Sprint(totalTargets)
+	line(cells...)
+	if err := tw.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderOriginReport formats the distinct project origins seen, with how many
+// projects and orgs had each, whether refresh supports the origin, and the
+// integration key it maps to. Origins are sorted by project count, largest
+// first.
+func renderOriginReport(projects, orgs map[string]int) ([]byte, error) {
+	origins := make([]string, 0, len(projects))
+	for o := range projects {
+		origins = append(origins, o)
+	}
+	sort.Slice(origins, func(i, j int) bool {
+		if projects[origins[i]] != projects[origins[j]] {
+			return projects[origins[i]] > projects[origins[j]]
+		}
+		return origins[i] < origins[j]
+	})
+
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ORIGIN\tPROJECTS\tORGS\tSUPPORTED\tINTEGRATION")
+	for _, o := range origins {
+		label, supported, key := o, "no", "-"
+		if label == "" {
+			label = "(none)"
+		}
+		if internal.IsSCMOrigin(o) {
+			supported, key = "yes", internal.OriginToIntegrationKey(o)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n", label, projects[o], orgs[o], supported, key)
+	}
+	if err := tw.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,90 @@
+// whoami.go implements the whoami subcommand: confirm which user a token
+// belongs to and list the groups it can access, to help pick --groupId.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/snyk-playground/snyk-target-export/internal"
+)
+
+// whoamiReport holds the token's user and, when they could be listed, its
+// groups.
+type whoamiReport struct {
+	user      internal.User
+	groups    []internal.Group
+	groupsErr error
+}
+
+// runWhoamiChecks fetches the token's user and groups. The returned error is
+// non-nil only when the user fetch fails; a failure to list groups (e.g. an
+// org-scoped token) is recorded in the report.
+func runWhoamiChecks(ctx context.Context, api SnykAPI) (whoamiReport, error) {
+	var rep whoamiReport
+	user, err := api.FetchUser(ctx)
+	if err != nil {
+		return rep, err
+	}
+	rep.user = user
+	rep.groups, rep.groupsErr = api.FetchGroups(ctx)
+	return rep, nil
+}
+
+// runWhoami implements the whoami subcommand.
+func runWhoami(args []string) {
+	fs := flag.NewFlagSet("whoami", flag.ExitOnError)
+	apiOpts := addAPIFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	if err := configureAPI(apiOpts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer warnDeprecatedAPIVersion()
+
+	token, err := internal.GetSnykToken()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	api := newSnykAPI(internal.NewHTTPClient(), token, false)
+
+	rep, err := runWhoamiChecks(ctx, api)
+	if err != nil {
+		if errors.Is(err, internal.ErrUnauthorized) {
+			fmt.Fprintf(os.Stderr, "Error: the API at %s rejected the token (401).\n", internal.GetSnykAPIBaseURL())
+			fmt.Fprintf(os.Stderr, "Check that SNYK_TOKEN is current, and that SNYK_API points at your region (e.g. https://api.eu.snyk.io).\n")
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	name := rep.user.Username
+	if rep.user.Email != "" {
+		name = fmt.Sprintf("%s <%s>", name, rep.user.Email)
+	}
+	fmt.Printf("Authenticated as: %s (%s)\n", name, rep.user.ID)
+	fmt.Printf("API base URL: %s\n", internal.GetSnykAPIBaseURL())
+
+	switch {
+	case rep.groupsErr != nil:
+		fmt.Printf("\nCould not list groups: %v\n", rep.groupsErr)
+		fmt.Println("The token may be scoped to a single org; use --orgId instead of --groupId.")
+	case len(rep.groups) == 0:
+		fmt.Println("\nNo groups are visible to this token; use --orgId instead of --groupId.")
+	default:
+		fmt.Printf("\nGroups (%d):\n", len(rep.groups))
+		for _, g := range rep.groups {
+			fmt.Printf("  %s  %s\n", g.ID, g.Name)
+		}
+		fmt.Println("\nPass one of these IDs as --groupId.")
+	}
+}